@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// version is set at build time:
+// go build -ldflags "-X main.version=1.2.0"
+var version = "dev"
+
+// agentInfo is the document an agent keeps up to date in the
+// agents collection while it runs so it can be told apart
+type agentInfo struct {
+	Id           string               `json:"_id"                    bson:"_id"`
+	Hostname     string               `json:"hostname"               bson:"hostname"`
+	Version      string               `json:"version"                bson:"version"`
+	Queues       []string             `json:"queues,omitempty"       bson:"queues,omitempty"`
+	Capabilities []string             `json:"capabilities,omitempty" bson:"capabilities,omitempty"`
+	Slots        int                  `json:"slots"                  bson:"slots"`
+	Jobs         []primitive.ObjectID `json:"jobs"                   bson:"jobs"` // running now
+	StartedAt    time.Time            `json:"startedAt"              bson:"startedAt"`
+	Heartbeat    time.Time            `json:"heartbeat"              bson:"heartbeat"`
+}
+
+// alive tells if the agent has updated its document within timeout;
+// the agents that crashed or lost the store stop doing that
+func (a agentInfo) alive(now time.Time, timeout time.Duration) bool {
+	return now.Sub(a.Heartbeat) <= timeout
+}
+
+// agentView is an agent as the API and CLI show it
+type agentView struct {
+	agentInfo
+	Alive bool `json:"alive"`
+}
+
+func newAgentViews(agents []agentInfo, now time.Time, timeout time.Duration) []agentView {
+	views := make([]agentView, 0, len(agents))
+	for _, a := range agents {
+		views = append(views, agentView{agentInfo: a, Alive: a.alive(now, timeout)})
+	}
+	return views
+}
+
+// agentRegistry tracks what the agent is doing for its document.
+// A nil registry (agent doesn't register) ignores the calls.
+type agentRegistry struct {
+	m    sync.Mutex
+	info agentInfo
+	jobs map[primitive.ObjectID]bool
+	dead map[string]bool // the dead agents reported already
+}
+
+func newAgentRegistry(s settings) *agentRegistry {
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	id := s.AgentId
+	if id == "" {
+		// the restarted agent is a new one; the old
+		// document tells the jobs it was running
+		var suffix [4]byte
+		rand.Read(suffix[:])
+		id = hostname + "-" + hex.EncodeToString(suffix[:])
+	}
+
+	return &agentRegistry{
+		info: agentInfo{
+			Id:           id,
+			Hostname:     hostname,
+			Version:      version,
+			Queues:       s.Queues,
+			Capabilities: s.Capabilities,
+			Slots:        s.MaxJobs,
+			StartedAt:    time.Now().UTC(),
+		},
+		jobs: make(map[primitive.ObjectID]bool),
+		dead: make(map[string]bool),
+	}
+}
+
+func (r *agentRegistry) jobStarted(id primitive.ObjectID) {
+	if r == nil {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.jobs[id] = true
+}
+
+func (r *agentRegistry) jobFinished(id primitive.ObjectID) {
+	if r == nil {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	delete(r.jobs, id)
+}
+
+func (r *agentRegistry) setSlots(slots int) {
+	if r == nil {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.info.Slots = slots
+}
+
+// snapshot returns the agent document as of now
+func (r *agentRegistry) snapshot(now time.Time) agentInfo {
+	r.m.Lock()
+	defer r.m.Unlock()
+	info := r.info
+	info.Heartbeat = now
+	info.Jobs = make([]primitive.ObjectID, 0, len(r.jobs))
+	for id := range r.jobs {
+		info.Jobs = append(info.Jobs, id)
+	}
+	sort.Slice(info.Jobs, func(i, j int) bool { return info.Jobs[i].Hex() < info.Jobs[j].Hex() })
+	return info
+}
+
+// registryLoop registers the agent and updates its document every
+// AgentInterval until ctx is done; then it removes the document
+func registryLoop(ctx context.Context, store JobStore, s settings, reg *agentRegistry) {
+
+	logger := logging.FromContext(ctx).With("agent", reg.info.Id)
+	logger.Info("registering agent", "hostname", reg.info.Hostname, "version", reg.info.Version)
+
+	ticker := time.NewTicker(s.AgentInterval)
+	defer ticker.Stop() // to prevent ticker goroutine leak
+
+	for {
+		if err := registerAgent(ctx, store, s, reg); err != nil {
+			logger.Error("can't register agent", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			timeout, cancel := context.WithTimeout(context.Background(), s.DbOpTimeout)
+			defer cancel()
+			if err := store.RemoveAgent(timeout, reg.info.Id); err != nil {
+				logger.Error("can't remove agent", "err", err)
+			}
+			return
+		}
+	}
+}
+
+// registerAgent updates the agent document, reports the agents that have
+// stopped updating theirs, puts the jobs they left running back to the
+// queue and removes their documents after AgentRetention
+func registerAgent(ctx context.Context, store JobStore, s settings, reg *agentRegistry) error {
+
+	logger := logging.FromContext(ctx)
+
+	timeout, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	if err := store.RegisterAgent(timeout, reg.snapshot(now)); err != nil {
+		return err
+	}
+
+	released, err := store.ReleaseStale(timeout, now.Add(-s.HeartbeatTimeout))
+	if err != nil {
+		return err
+	}
+	if released > 0 {
+		logger.Warn("released the jobs without heartbeat", "jobs", released)
+	}
+
+	agents, err := store.Agents(timeout)
+	if err != nil {
+		return err
+	}
+
+	var expired []agentInfo
+
+	reg.m.Lock()
+	for _, a := range agents {
+		if a.alive(now, s.AgentTimeout) {
+			delete(reg.dead, a.Id)
+			continue
+		}
+		if now.Sub(a.Heartbeat) > s.AgentRetention {
+			delete(reg.dead, a.Id)
+			expired = append(expired, a)
+			continue
+		}
+		if !reg.dead[a.Id] {
+			reg.dead[a.Id] = true
+			logger.Warn("agent is dead", "agent", a.Id, "hostname", a.Hostname,
+				"heartbeat", a.Heartbeat, "jobs", formatIds(a.Jobs))
+		}
+	}
+	reg.m.Unlock()
+
+	for _, a := range expired {
+		if err := store.RemoveAgent(timeout, a.Id); err != nil {
+			return err
+		}
+		logger.Info("removed dead agent", "agent", a.Id, "hostname", a.Hostname, "heartbeat", a.Heartbeat)
+	}
+	return nil
+}
+
+func formatIds(ids []primitive.ObjectID) string {
+	hexIds := make([]string, len(ids))
+	for i, id := range ids {
+		hexIds[i] = id.Hex()
+	}
+	return strings.Join(hexIds, ",")
+}
+
+const agentsUsage = `usage: octopus agents [-json]
+
+Lists the agents registered in the job store configured with OCTOPUS_* variables.
+The agents that haven't updated their registration for OCTOPUS_AGENTTIMEOUT are dead.
+`
+
+// runAgents runs octopus agents command and returns the process exit code
+func runAgents(ctx context.Context, s settings, args []string, out io.Writer, errOut io.Writer) int {
+
+	fs := newJobsFlagSet("agents")
+	asJSON := fs.Bool("json", false, "JSON output")
+
+	_, err := parseJobsFlags(fs, args, 0, 0)
+	if err == flag.ErrHelp {
+		fmt.Fprint(errOut, agentsUsage)
+		return exitOk
+	}
+	if err != nil {
+		fmt.Fprintln(errOut, "octopus agents:", err)
+		fmt.Fprint(errOut, agentsUsage)
+		return exitUsage
+	}
+
+	store, err := newJobStore(ctx, s)
+	if err != nil {
+		fmt.Fprintln(errOut, "octopus agents:", err)
+		return exitFailure
+	}
+	defer closeJobStore(store, s)
+
+	if err := listAgents(ctx, store, s, *asJSON, out); err != nil {
+		fmt.Fprintln(errOut, "octopus agents:", err)
+		return exitFailure
+	}
+
+	return exitOk
+}
+
+func listAgents(ctx context.Context, store JobStore, s settings, asJSON bool, out io.Writer) error {
+
+	opCtx, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	agents, err := store.Agents(opCtx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	views := newAgentViews(agents, now, s.AgentTimeout)
+
+	if asJSON {
+		return printJSON(out, views)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tHOSTNAME\tVERSION\tJOBS\tHEARTBEAT\tQUEUES")
+	for _, a := range views {
+		state := "alive"
+		if !a.Alive {
+			state = "dead"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v/%v\t%v ago\t%v\n", a.Id, state, a.Hostname, a.Version,
+			len(a.Jobs), a.Slots, now.Sub(a.Heartbeat).Round(time.Second), strings.Join(a.Queues, ","))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgentRegistry(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	s := settings{DbOpTimeout: time.Second, AgentId: "edge-1", MaxJobs: 3, AgentInterval: time.Hour,
+		AgentTimeout: time.Minute, AgentRetention: 24 * time.Hour, HeartbeatTimeout: time.Minute,
+		Queues: []string{defaultQueue}}
+
+	// an agent that has crashed an hour ago running a job
+	crashed := newTestJob()
+	dead := agentInfo{Id: "edge-0", Hostname: "edge", Heartbeat: time.Now().UTC().Add(-time.Hour)}
+	dead.Jobs = append(dead.Jobs, crashed.Id)
+	if err := store.RegisterAgent(ctx, dead); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := newAgentRegistry(s)
+	running := newTestJob()
+	registry.jobStarted(running.Id)
+	registry.jobStarted(crashed.Id)
+	registry.jobFinished(crashed.Id)
+
+	if err := registerAgent(ctx, store, s, registry); err != nil {
+		t.Fatal(err)
+	}
+
+	agents, err := store.Agents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 2 || agents[0].Id != "edge-0" || agents[1].Id != "edge-1" {
+		t.Fatalf("expected both agents ordered by id but got %+v", agents)
+	}
+	a := agents[1]
+	if a.Version != version || a.Slots != 3 || len(a.Jobs) != 1 || a.Jobs[0] != running.Id || a.Hostname == "" {
+		t.Fatalf("unexpected agent document %+v", a)
+	}
+	if !registry.dead["edge-0"] || registry.dead["edge-1"] {
+		t.Fatalf("expected the crashed agent to be reported dead but got %v", registry.dead)
+	}
+
+	// the agent removes its document on shutdown
+	loopCtx, stop := context.WithCancel(ctx)
+	stop()
+	registryLoop(loopCtx, store, s, registry)
+	if agents, err := store.Agents(ctx); err != nil || len(agents) != 1 || agents[0].Id != "edge-0" {
+		t.Fatalf("expected only the dead agent to be left but got %+v (%v)", agents, err)
+	}
+}
+
+func TestAgentRegistryReleasesStaleJobs(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	s := settings{DbOpTimeout: time.Second, AgentId: "edge-2", AgentTimeout: time.Minute,
+		AgentRetention: 24 * time.Hour, HeartbeatTimeout: time.Minute}
+
+	now := time.Now().UTC()
+	lastHeartbeat := now.Add(-time.Hour)
+	stale := newTestJob()
+	stale.Status, stale.Heartbeat, stale.Progress = running, &lastHeartbeat, &jobProgress{Bytes: 5}
+	fresh := newTestJob()
+	fresh.Status, fresh.Heartbeat = running, &now
+	for _, j := range []job{stale, fresh} {
+		if err := store.Insert(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// crashed an hour and two days ago
+	for _, a := range []agentInfo{
+		{Id: "edge-0", Heartbeat: now.Add(-48 * time.Hour), Jobs: []primitive.ObjectID{fresh.Id}},
+		{Id: "edge-1", Heartbeat: now.Add(-time.Hour), Jobs: []primitive.ObjectID{stale.Id}},
+	} {
+		if err := store.RegisterAgent(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := newAgentRegistry(s)
+	if err := registerAgent(ctx, store, s, registry); err != nil {
+		t.Fatal(err)
+	}
+
+	released, err := store.Get(ctx, stale.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released.Status != created || released.Heartbeat != nil || released.Progress != nil {
+		t.Fatalf("expected the job without heartbeat to be back in the queue but got %+v", released)
+	}
+	if j, err := store.Get(ctx, fresh.Id); err != nil || j.Status != running {
+		t.Fatalf("expected the job with heartbeat to keep running but got %+v (%v)", j, err)
+	}
+
+	agents, err := store.Agents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 2 || agents[0].Id != "edge-1" || agents[1].Id != "edge-2" {
+		t.Fatalf("expected the agent dead for two days to be removed but got %+v", agents)
+	}
+	if !registry.dead["edge-1"] || registry.dead["edge-0"] {
+		t.Fatalf("expected only the recently crashed agent to be reported dead but got %v", registry.dead)
+	}
+}
+
+func TestListAgents(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	s := settings{DbOpTimeout: time.Second, AgentTimeout: time.Minute}
+
+	now := time.Now().UTC()
+	for _, a := range []agentInfo{
+		{Id: "edge-0", Hostname: "edge", Version: "1.0.0", Slots: 3, Heartbeat: now.Add(-time.Hour)},
+		{Id: "edge-1", Hostname: "edge", Version: "1.1.0", Slots: 3, Heartbeat: now, Queues: []string{"default"}},
+	} {
+		if err := store.RegisterAgent(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := listAgents(ctx, store, s, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "dead") || !strings.Contains(lines[2], "alive") {
+		t.Fatalf("expected dead and alive agents but got\n%v", out.String())
+	}
+
+	srv := httptest.NewServer(newHttpHandler(store, s, nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/agents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var views []struct {
+		Id    string `json:"_id"`
+		Alive bool   `json:"alive"`
+		Slots int    `json:"slots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&views); err != nil {
+		t.Fatal(err)
+	}
+	if len(views) != 2 || views[0].Alive || !views[1].Alive || views[1].Slots != 3 {
+		t.Fatalf("unexpected agents %+v", views)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/viktorburka/octopus/netio"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jobRequest is the body of POST /jobs
+type jobRequest struct {
+	SrcUrl      string               `json:"srcUrl"`
+	DstUrl      string               `json:"dstUrl"`
+	Description string               `json:"description"`
+	Options     map[string]string    `json:"options"`
+	NotBefore   *time.Time           `json:"notBefore"`
+	DependsOn   []primitive.ObjectID `json:"dependsOn"`
+	Queue       string               `json:"queue"`
+	Requires    []string             `json:"requires"`
+}
+
+// newJob validates the request and creates a job from it
+func (req jobRequest) newJob() (job, error) {
+
+	if req.SrcUrl == "" || req.DstUrl == "" {
+		return job{}, fmt.Errorf("srcUrl and dstUrl are required")
+	}
+
+	if err := netio.ValidateTransfer(req.SrcUrl, req.DstUrl); err != nil {
+		return job{}, err
+	}
+
+	return job{
+		Id:          primitive.NewObjectID(),
+		Status:      created,
+		SrcUrl:      req.SrcUrl,
+		DstUrl:      req.DstUrl,
+		Description: req.Description,
+		NotBefore:   req.NotBefore,
+		DependsOn:   req.DependsOn,
+		Queue:       req.Queue,
+		Requires:    req.Requires,
+		Options:     req.Options,
+	}, nil
+}
+
+// checkFileRoots rejects the file urls that are not under one of the roots
+func checkFileRoots(roots []string, urls ...string) error {
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme != "file" {
+			continue
+		}
+		p := u.Path
+		if u.Opaque != "" { // file:relative/path
+			p = u.Opaque
+		}
+		if !underRoots(p, roots) {
+			return fmt.Errorf("%v is not under the file roots %v", raw, roots)
+		}
+	}
+	return nil
+}
+
+func underRoots(p string, roots []string) bool {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, p)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// maximum number of jobs returned by GET /jobs unless limit is given
+const defaultListLimit = 100
+
+// apiHandler implements REST API to submit and manage jobs:
+//
+//	POST /jobs                submit a job
+//	GET  /jobs?status=&limit= list jobs
+//	GET  /jobs/{id}           get job status and progress
+//	POST /jobs/{id}/cancel    cancel created or running job
+//	POST /jobs/{id}/retry     put failed or cancelled job back to the queue
+//	GET  /agents              list registered agents
+//
+// The requests carry the ApiToken as a bearer token or come from the
+// same host if there is none. The secrets of the jobs are redacted.
+type apiHandler struct {
+	store JobStore
+	s     settings
+}
+
+func newApiHandler(store JobStore, s settings) *apiHandler {
+	return &apiHandler{store: store, s: s}
+}
+
+func (h *apiHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("/jobs", h.auth(h.jobs))
+	mux.HandleFunc("/jobs/", h.auth(h.job))
+	mux.HandleFunc("/agents", h.auth(h.agents))
+}
+
+// auth lets the request through if it carries the ApiToken
+// or, without ApiToken, if it comes from the same host
+func (h *apiHandler) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.s.ApiToken == "" {
+			if !isLoopback(r.RemoteAddr) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("API token is not configured, only local clients are allowed"))
+				return
+			}
+		} else {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(h.s.ApiToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid API token"))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// isLoopback tells if the host:port address is a loopback one
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (h *apiHandler) jobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.list(w, r)
+	case http.MethodPost:
+		h.submit(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	}
+}
+
+func (h *apiHandler) job(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+
+	id, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		writeError(w, http.StatusNotFound, errJobNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		h.get(w, r, id)
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		h.transition(w, r, id, h.store.Cancel)
+	case len(parts) == 2 && parts[1] == "retry" && r.Method == http.MethodPost:
+		h.transition(w, r, id, h.store.Retry)
+	case len(parts) <= 2:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%v not found", r.URL.Path))
+	}
+}
+
+func (h *apiHandler) agents(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.s.DbOpTimeout)
+	defer cancel()
+
+	agents, err := h.store.Agents(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAgentViews(agents, time.Now().UTC(), h.s.AgentTimeout))
+}
+
+func (h *apiHandler) submit(w http.ResponseWriter, r *http.Request) {
+
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %v", err))
+		return
+	}
+
+	newJob, err := req.newJob()
+	if err == nil {
+		err = checkFileRoots(h.s.FileRoots, newJob.SrcUrl, newJob.DstUrl)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.s.DbOpTimeout)
+	defer cancel()
+
+	if err := h.store.Insert(ctx, newJob); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+newJob.Id.Hex())
+	writeJSON(w, http.StatusCreated, newJob.redacted())
+}
+
+func (h *apiHandler) list(w http.ResponseWriter, r *http.Request) {
+
+	filter := jobFilter{Limit: defaultListLimit}
+
+	query := r.URL.Query()
+	for _, status := range query["status"] {
+		for _, st := range strings.Split(status, ",") {
+			filter.Status = append(filter.Status, st)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || l <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", limit))
+			return
+		}
+		filter.Limit = l
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.s.DbOpTimeout)
+	defer cancel()
+
+	jobs, err := h.store.List(ctx, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	views := make([]job, 0, len(jobs)) // render empty list as [] rather than null
+	for _, j := range jobs {
+		views = append(views, j.redacted())
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *apiHandler) get(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) {
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.s.DbOpTimeout)
+	defer cancel()
+
+	j, err := h.store.Get(ctx, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, j.redacted())
+}
+
+func (h *apiHandler) transition(w http.ResponseWriter, r *http.Request, id primitive.ObjectID,
+	fn func(ctx context.Context, id primitive.ObjectID) error) {
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.s.DbOpTimeout)
+	defer cancel()
+
+	if err := fn(ctx, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	h.get(w, r, id)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch err {
+	case errJobNotFound:
+		writeError(w, http.StatusNotFound, err)
+	case errInvalidJobState:
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestApi(t *testing.T) (*fileStore, *httptest.Server, func()) {
+	store, cleanup := newTestFileStore(t)
+	srv := httptest.NewServer(newHttpHandler(store, settings{DbOpTimeout: time.Second}, nil))
+	return store, srv, func() {
+		srv.Close()
+		cleanup()
+	}
+}
+
+func postJSON(t *testing.T, url string, body string) *http.Response {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestApiSubmitAndGet(t *testing.T) {
+
+	_, srv, cleanup := newTestApi(t)
+	defer cleanup()
+
+	resp := postJSON(t, srv.URL+"/jobs", `{
+		"srcUrl": "https://example.com/key.mp4",
+		"dstUrl": "s3://amazon.aws.com/bucket/key.mp4",
+		"options": {"bucketNameStyle": "virtual-hosted-style"}
+	}`)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %v but got %v", http.StatusCreated, resp.StatusCode)
+	}
+
+	var submitted job
+	if err := json.NewDecoder(resp.Body).Decode(&submitted); err != nil {
+		t.Fatal(err)
+	}
+	if submitted.Status != created || submitted.Options["bucketNameStyle"] != "virtual-hosted-style" {
+		t.Fatalf("unexpected job submitted: %+v", submitted)
+	}
+
+	resp, err := http.Get(srv.URL + resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var fetched job
+	if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Id != submitted.Id {
+		t.Fatalf("expected job %v but got %v", submitted.Id.Hex(), fetched.Id.Hex())
+	}
+}
+
+func TestApiSubmitValidation(t *testing.T) {
+
+	_, srv, cleanup := newTestApi(t)
+	defer cleanup()
+
+	bodies := []string{
+		`{`,
+		`{"srcUrl": "https://example.com/key.mp4"}`,
+		`{"srcUrl": "ftp://example.com/key.mp4", "dstUrl": "s3://amazon.aws.com/bucket/key.mp4"}`,
+		`{"srcUrl": "https://example.com/key.mp4", "dstUrl": "http://example.com/key.mp4"}`,
+	}
+
+	for _, body := range bodies {
+		resp := postJSON(t, srv.URL+"/jobs", body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status %v for %v but got %v", http.StatusBadRequest, body, resp.StatusCode)
+		}
+	}
+}
+
+func TestApiCancelAndRetry(t *testing.T) {
+
+	store, srv, cleanup := newTestApi(t)
+	defer cleanup()
+
+	j := newTestJob()
+	if err := store.Insert(context.Background(), j); err != nil {
+		t.Fatal(err)
+	}
+
+	// only failed or cancelled jobs can be retried
+	resp := postJSON(t, srv.URL+"/jobs/"+j.Id.Hex()+"/retry", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status %v but got %v", http.StatusConflict, resp.StatusCode)
+	}
+
+	resp = postJSON(t, srv.URL+"/jobs/"+j.Id.Hex()+"/cancel", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	resp = postJSON(t, srv.URL+"/jobs/"+j.Id.Hex()+"/retry", "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	var retried job
+	if err := json.NewDecoder(resp.Body).Decode(&retried); err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != created {
+		t.Fatalf("expected retried job to be %v but got %v", created, retried.Status)
+	}
+
+	resp = postJSON(t, srv.URL+"/jobs/5c8f3e9a0000000000000000/cancel", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status %v but got %v", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestApiList(t *testing.T) {
+
+	store, srv, cleanup := newTestApi(t)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		if err := store.Insert(context.Background(), newTestJob()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/jobs?status=Created,Running&limit=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var jobs []job
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected %v jobs but got %v", 2, len(jobs))
+	}
+}
+
+func TestApiAuth(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	s := settings{DbOpTimeout: time.Second}
+	request := func(s settings, remoteAddr string, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		newHttpHandler(store, s, nil).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// without a token only the local clients are served
+	if code := request(s, "127.0.0.1:5000", ""); code != http.StatusOK {
+		t.Fatalf("expected status %v for a local client but got %v", http.StatusOK, code)
+	}
+	if code := request(s, "10.0.0.7:5000", ""); code != http.StatusForbidden {
+		t.Fatalf("expected status %v for a remote client but got %v", http.StatusForbidden, code)
+	}
+
+	s.ApiToken = "s3cr3t"
+	for token, expected := range map[string]int{"": http.StatusUnauthorized, "guess": http.StatusUnauthorized,
+		"s3cr3t": http.StatusOK} {
+		if code := request(s, "10.0.0.7:5000", token); code != expected {
+			t.Fatalf("expected status %v for token %q but got %v", expected, token, code)
+		}
+	}
+
+	// the probes stay open
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.RemoteAddr = "10.0.0.7:5000"
+	w := httptest.NewRecorder()
+	newHttpHandler(store, s, nil).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v for /healthz but got %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestApiFileRoots(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(newHttpHandler(store,
+		settings{DbOpTimeout: time.Second, FileRoots: []string{"/data/in", "/data/out"}}, nil))
+	defer srv.Close()
+
+	for body, expected := range map[string]int{
+		`{"srcUrl": "file:///data/in/a.mp4", "dstUrl": "file:///data/out/a.mp4"}`:       http.StatusCreated,
+		`{"srcUrl": "file:///data/in/../../etc/passwd", "dstUrl": "file:///data/out/"}`: http.StatusBadRequest,
+		`{"srcUrl": "https://example.com/a.mp4", "dstUrl": "file:///root/.ssh/keys"}`:   http.StatusBadRequest,
+		`{"srcUrl": "file:///data/input", "dstUrl": "file:///data/out/a.mp4"}`:          http.StatusBadRequest,
+	} {
+		resp := postJSON(t, srv.URL+"/jobs", body)
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status %v for %v but got %v", expected, body, resp.StatusCode)
+		}
+	}
+
+	// no file urls without the roots
+	_, srv, cleanupApi := newTestApi(t)
+	defer cleanupApi()
+	resp := postJSON(t, srv.URL+"/jobs", `{"srcUrl": "file:///data/in/a.mp4", "dstUrl": "file:///data/out/a.mp4"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %v but got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestApiRedactsSecrets(t *testing.T) {
+
+	store, srv, cleanup := newTestApi(t)
+	defer cleanup()
+
+	j := newTestJob()
+	j.Options = map[string]string{"awsAccessKeyId": "AKIA", "awsSecretAccessKey": "wJalrXUtnFEMI"}
+	if err := store.Insert(context.Background(), j); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/jobs", "/jobs/" + j.Id.Hex()} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(body), "wJalrXUtnFEMI") || !strings.Contains(string(body), "AKIA") {
+			t.Fatalf("expected the secret options of %v redacted but got %s", path, body)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/viktorburka/octopus/logging"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The configuration file is a YAML document with the settings as top level
+// keys named like the fields with the first letter lowered (maxJobs for
+// OCTOPUS_MAXJOBS) and scalar or list values. The environment variables
+// override the file.
+
+// envPrefix is the prefix of the settings environment variables
+const envPrefix = "octopus"
+
+// loadSettings reads the defaults and the environment variables, then the
+// configuration file (OCTOPUS_CONFIG) for the settings that aren't set in
+// the environment and validates the ones the command uses
+func loadSettings(command string) (settings, error) {
+
+	var s settings
+	if err := envconfig.Process(envPrefix, &s); err != nil {
+		return s, err
+	}
+
+	if s.Config != "" {
+		data, err := ioutil.ReadFile(s.Config)
+		if err != nil {
+			return s, err
+		}
+		entries, err := parseConfig(data)
+		if err != nil {
+			return s, fmt.Errorf("%v:%v", s.Config, err)
+		}
+		if err := applyConfig(&s, entries, os.LookupEnv); err != nil {
+			return s, fmt.Errorf("%v:%v", s.Config, err)
+		}
+	}
+
+	return s, s.validate(command)
+}
+
+// configEntry is a top level key of the configuration file
+type configEntry struct {
+	line   int
+	key    string
+	values []string
+	list   bool
+	// the key has no value (or ~, null)
+	null bool
+}
+
+// parseConfig returns the entries of the YAML document in the file order;
+// the errors are prefixed with the line number
+func parseConfig(data []byte) ([]configEntry, error) {
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		// "yaml: line 3: ..." to "3: ..." like the other errors
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "yaml: line "))
+	}
+	if len(doc.Content) == 0 { // no document
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%v: expected the settings as key: value", root.Line)
+	}
+
+	var entries []configEntry
+	seen := make(map[string]bool)
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+
+		key, val := root.Content[i], resolveAlias(root.Content[i+1])
+		if seen[strings.ToLower(key.Value)] { // the keys are case insensitive
+			return nil, fmt.Errorf("%v: %v is repeated", key.Line, key.Value)
+		}
+		seen[strings.ToLower(key.Value)] = true
+
+		entry := configEntry{line: key.Line, key: key.Value}
+		switch val.Kind {
+		case yaml.ScalarNode:
+			if val.Tag == "!!null" {
+				entry.null = true
+				break
+			}
+			entry.values = []string{val.Value}
+		case yaml.SequenceNode:
+			entry.list = true
+			for _, item := range val.Content {
+				item = resolveAlias(item)
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%v: nested lists and mappings are not supported", item.Line)
+				}
+				entry.values = append(entry.values, item.Value)
+			}
+		default:
+			return nil, fmt.Errorf("%v: nested mappings are not supported", val.Line)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// resolveAlias returns the node the alias (*name) refers to
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// settingsField is a settings field with its configuration file key and environment variable
+type settingsField struct {
+	key   string
+	env   string
+	field reflect.StructField
+	value reflect.Value
+}
+
+func settingsFields(s *settings) []settingsField {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	fields := make([]settingsField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := []rune(f.Name)
+		name[0] = unicode.ToLower(name[0])
+		fields = append(fields, settingsField{
+			key:   string(name),
+			env:   strings.ToUpper(envPrefix + "_" + f.Name),
+			field: f,
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+// applyConfig sets the settings of the entries that aren't set in the
+// environment; the ones without a value keep their defaults
+func applyConfig(s *settings, entries []configEntry, lookupEnv func(string) (string, bool)) error {
+
+	byKey := make(map[string]settingsField)
+	for _, f := range settingsFields(s) {
+		byKey[strings.ToLower(f.key)] = f
+	}
+
+	for _, e := range entries {
+		f, ok := byKey[strings.ToLower(e.key)]
+		if !ok || f.field.Name == "Config" {
+			return fmt.Errorf("%v: unknown setting %v", e.line, e.key)
+		}
+		if _, ok := lookupEnv(f.env); ok || e.null {
+			continue // the environment overrides the file
+		}
+		if err := setField(f.value, e); err != nil {
+			return fmt.Errorf("%v: %v: %v", e.line, e.key, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(v reflect.Value, e configEntry) error {
+
+	if v.Kind() == reflect.Slice {
+		values := e.values
+		if !e.list && len(values) == 1 { // comma separated like the env variable
+			values = splitList(values[0])
+		}
+		v.Set(reflect.ValueOf(append([]string{}, values...)))
+		return nil
+	}
+
+	if e.list && len(e.values) > 0 {
+		return fmt.Errorf("expected a single value but got a list")
+	}
+	var val string
+	if len(e.values) > 0 {
+		val = e.values[0]
+	}
+
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", val)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", val)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", val)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.String:
+		v.SetString(val)
+	default:
+		return fmt.Errorf("unsupported setting type %v", v.Type())
+	}
+
+	return nil
+}
+
+// validate checks the settings the command uses reporting all the invalid
+// ones; octopus cp doesn't use the job store nor run the jobs
+func (s settings) validate(command string) error {
+
+	var problems []string
+	check := func(ok bool, key string, format string, args ...interface{}) {
+		if !ok {
+			f := settingsFieldByKey(&s, key)
+			problems = append(problems, fmt.Sprintf("  %v (%v): %v", key, f.env, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	_, err := logging.ParseLevel(s.LogLevel)
+	check(err == nil, "logLevel", "expected debug, info, warn or error but got %q", s.LogLevel)
+	check(s.LogFormat == logging.FormatLogfmt || s.LogFormat == logging.FormatJSON, "logFormat",
+		"expected %v or %v but got %q", logging.FormatLogfmt, logging.FormatJSON, s.LogFormat)
+
+	check(s.TraceEndpoint == "" || validHttpUrl(s.TraceEndpoint), "traceEndpoint", "invalid http url %q", s.TraceEndpoint)
+
+	if command == "cp" {
+		return problemsError(problems)
+	}
+
+	check(s.Store == "mongo" || s.Store == "file", "store", "expected mongo or file but got %q", s.Store)
+	check(s.Store != "file" || s.StorePath != "", "storePath", "required for the file store")
+	check(s.MaxJobs > 0, "maxJobs", "must be positive")
+	check(s.MaxAttempts > 0, "maxAttempts", "must be positive")
+	_, err = parseSize(s.BandwidthLimit)
+	check(s.BandwidthLimit == "" || err == nil, "bandwidthLimit", "invalid size %q", s.BandwidthLimit)
+	check(len(s.Queues) > 0, "queues", "at least one queue is required")
+	check(s.PprofAddress == "" || isLoopback(s.PprofAddress), "pprofAddress",
+		"expected a loopback address (e.g. 127.0.0.1:6060) but got %q", s.PprofAddress)
+	for _, root := range s.FileRoots {
+		check(filepath.IsAbs(root), "fileRoots", "expected an absolute path but got %q", root)
+	}
+	check(s.ShutdownMode == shutdownDrain || s.ShutdownMode == shutdownRelease, "shutdownMode",
+		"expected %v or %v but got %q", shutdownDrain, shutdownRelease, s.ShutdownMode)
+
+	for _, d := range []struct {
+		key string
+		val time.Duration
+	}{
+		{"eventLoopSleep", s.EventLoopSleep},
+		{"dbOpTimeout", s.DbOpTimeout},
+		{"heartbeatInterval", s.HeartbeatInterval},
+		{"scheduleInterval", s.ScheduleInterval},
+		{"agentInterval", s.AgentInterval},
+		{"webhookTimeout", s.WebhookTimeout},
+	} {
+		check(d.val > 0, d.key, "must be positive")
+	}
+	check(s.RetryBackoff >= 0, "retryBackoff", "must not be negative")
+	check(s.ShutdownTimeout >= 0, "shutdownTimeout", "must not be negative")
+	check(s.HeartbeatTimeout > s.HeartbeatInterval, "heartbeatTimeout",
+		"must be longer than heartbeatInterval (%v)", s.HeartbeatInterval)
+	check(s.AgentTimeout > s.AgentInterval, "agentTimeout", "must be longer than agentInterval (%v)", s.AgentInterval)
+	check(s.AgentRetention >= s.AgentTimeout, "agentRetention", "must not be shorter than agentTimeout (%v)", s.AgentTimeout)
+
+	for _, u := range s.WebhookUrls {
+		check(validHttpUrl(u), "webhookUrls", "invalid http url %q", redactUrl(u))
+	}
+	check(len(s.WebhookUrls) == 0 || s.WebhookAttempts > 0, "webhookAttempts", "must be positive")
+
+	return problemsError(problems)
+}
+
+// problemsError returns the validation problems as one error or nil
+func problemsError(problems []string) error {
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n%v", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+func settingsFieldByKey(s *settings, key string) settingsField {
+	for _, f := range settingsFields(s) {
+		if f.key == key {
+			return f
+		}
+	}
+	panic("unknown settings key " + key)
+}
+
+func validHttpUrl(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+const redacted = "REDACTED"
+
+// redactUrl hides the password and the query of the url
+func redactUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	return u.String()
+}
+
+// printSettings writes the settings as a configuration file. The fields
+// tagged secret:"true" are redacted and so are the passwords of the urls
+// tagged secret:"url".
+func printSettings(w io.Writer, s settings) error {
+
+	var b strings.Builder
+	b.WriteString("# effective configuration, secrets are redacted\n")
+
+	for _, f := range settingsFields(&s) {
+		if f.field.Name == "Config" {
+			continue
+		}
+		secret := f.field.Tag.Get("secret")
+		switch v := f.value.Interface().(type) {
+		case []string:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = redactValue(item, secret)
+			}
+			fmt.Fprintf(&b, "%v: [%v]\n", f.key, strings.Join(items, ", "))
+		case string:
+			fmt.Fprintf(&b, "%v: %v\n", f.key, redactValue(v, secret))
+		default:
+			fmt.Fprintf(&b, "%v: %v\n", f.key, v)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func redactValue(val string, secret string) string {
+	switch {
+	case val == "":
+	case secret == "true":
+		val = redacted
+	case secret == "url":
+		val = redactUrl(val)
+	}
+	return quoteScalar(val)
+}
+
+// quoteScalar quotes the value if it would not be read back as is as a
+// plain scalar in a flow list; the Go escapes are valid in YAML too
+func quoteScalar(val string) string {
+	var doc yaml.Node
+	err := yaml.Unmarshal([]byte(val), &doc)
+	plain := err == nil && len(doc.Content) == 1 && doc.Content[0].Kind == yaml.ScalarNode &&
+		doc.Content[0].Tag == "!!str" && doc.Content[0].Value == val
+	if !plain || strings.ContainsAny(val, "#:[]{},\"'") {
+		return strconv.Quote(val)
+	}
+	return val
+}
+
+const configUsage = `usage: octopus config print
+
+Prints the effective configuration (defaults, OCTOPUS_CONFIG file
+and OCTOPUS_* variables) in the configuration file format.
+`
+
+// runConfig runs octopus config command and returns the process exit code
+func runConfig(s settings, args []string, out io.Writer, errOut io.Writer) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprint(errOut, configUsage)
+		return exitUsage
+	}
+	if err := printSettings(out, s); err != nil {
+		fmt.Fprintln(errOut, "octopus config:", err)
+		return exitFailure
+	}
+	return exitOk
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+# agent on the edge
+---
+store: file
+storePath: "/var/lib/octopus/jobs.json"   # quoted
+maxJobs: 8
+watchChanges: true
+shutdownTimeout: 2m
+queues: [edge, 'transcode, urgent']
+capabilities:
+  - zone:dmz
+  - region:us-east-1
+httpAddress:
+webhookSecret: 's3cr''t#1'
+`
+
+func TestParseConfig(t *testing.T) {
+
+	var s settings
+	entries, err := parseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.HttpAddress = ":8080"
+	s.DbConnection = "mongodb://localhost:27017"
+	env := map[string]string{"OCTOPUS_MAXJOBS": "4"}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	if err := applyConfig(&s, entries, lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := settings{
+		Store:           "file",
+		StorePath:       "/var/lib/octopus/jobs.json",
+		HttpAddress:     ":8080",
+		DbConnection:    "mongodb://localhost:27017",
+		WatchChanges:    true,
+		ShutdownTimeout: 2 * time.Minute,
+		Queues:          []string{"edge", "transcode, urgent"},
+		Capabilities:    []string{"zone:dmz", "region:us-east-1"},
+		WebhookSecret:   "s3cr't#1",
+	}
+	// MaxJobs is left to the environment and httpAddress
+	// without a value keeps its default
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected\n%+v\nbut got\n%+v", expected, s)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+
+	for config, expected := range map[string]string{
+		"maxJobs: 3\nmaxjobs: 4":          "2: maxjobs is repeated",
+		"store:\n  database: x":           "2: nested mappings are not supported",
+		"webhookEvents: {a: 1}":           "1: nested mappings are not supported",
+		"queues: [[edge]]":                "1: nested lists and mappings are not supported",
+		"- edge":                          "1: expected the settings as key: value",
+		"queues: [edge":                   "1: did not find expected ',' or ']'",
+		"maxJobs: many":                   `1: maxJobs: invalid integer "many"`,
+		"eventLoopSleep: 5":               `1: eventLoopSleep: invalid duration "5"`,
+		"maxJobs: [1, 2]":                 "1: maxJobs: expected a single value but got a list",
+		"shutdownMode: drain\nunknown: 1": "2: unknown setting unknown",
+		`storePath: "unterminated`:        "yaml: found unexpected end of stream",
+	} {
+		var s settings
+		entries, err := parseConfig([]byte(config))
+		if err == nil {
+			err = applyConfig(&s, entries, func(string) (string, bool) { return "", false })
+		}
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected %q to fail with %q but got %v", config, expected, err)
+		}
+	}
+}
+
+func TestConfigYaml(t *testing.T) {
+
+	config := `
+queues: &queues
+  - edge
+capabilities: *queues
+webhookSecret: |-
+  s3cr
+  t
+traceServiceName: "octo pus\x21\u00e9"
+storePath: >-
+  /var/lib/octopus/jobs.json
+`
+	var s settings
+	entries, err := parseConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(&s, entries, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := settings{
+		Queues:           []string{"edge"},
+		Capabilities:     []string{"edge"},
+		WebhookSecret:    "s3cr\nt",
+		TraceServiceName: "octo pus!\u00e9",
+		StorePath:        "/var/lib/octopus/jobs.json",
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected\n%+v\nbut got\n%+v", expected, s)
+	}
+}
+
+func TestConfigNullValues(t *testing.T) {
+
+	s := settings{MaxJobs: 3, HttpAddress: ":8080", Queues: []string{defaultQueue}, Capabilities: []string{"zone:dmz"}}
+	entries, err := parseConfig([]byte("maxJobs:\nhttpAddress: ''\nqueues: ~\ncapabilities: []\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(&s, entries, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys without a value are unset, an empty string or list is a value
+	if s.MaxJobs != 3 || s.HttpAddress != "" || !reflect.DeepEqual(s.Queues, []string{defaultQueue}) || len(s.Capabilities) != 0 {
+		t.Fatalf("expected the defaults kept for the keys without a value but got %+v", s)
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+
+	file, err := ioutil.TempFile(os.TempDir(), "octopus*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("maxJobs: 8\nlogLevel: loud\n")
+	file.Close()
+
+	for key, val := range map[string]string{"OCTOPUS_CONFIG": file.Name(), "OCTOPUS_SHUTDOWNMODE": "abandon"} {
+		os.Setenv(key, val)
+		defer os.Unsetenv(key)
+	}
+
+	_, err = loadSettings("agent")
+	if err == nil {
+		t.Fatalf("expected invalid settings to fail")
+	}
+	for _, problem := range []string{
+		`logLevel (OCTOPUS_LOGLEVEL): expected debug, info, warn or error but got "loud"`,
+		`shutdownMode (OCTOPUS_SHUTDOWNMODE): expected drain or release but got "abandon"`,
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("expected %q to be reported but got %v", problem, err)
+		}
+	}
+
+	// octopus cp only checks the settings it uses
+	_, err = loadSettings("cp")
+	if err == nil || !strings.Contains(err.Error(), "logLevel") || strings.Contains(err.Error(), "shutdownMode") {
+		t.Fatalf("expected only logLevel to be reported for cp but got %v", err)
+	}
+
+	os.Setenv("OCTOPUS_SHUTDOWNMODE", "release")
+	os.Setenv("OCTOPUS_LOGLEVEL", "debug")
+	defer os.Unsetenv("OCTOPUS_LOGLEVEL")
+
+	s, err := loadSettings("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MaxJobs != 8 || s.LogLevel != "debug" || s.ShutdownMode != shutdownRelease || s.Store != "mongo" {
+		t.Fatalf("expected the file and the environment over the defaults but got %+v", s)
+	}
+}
+
+func TestPrintSettings(t *testing.T) {
+
+	var s settings
+	entries, err := parseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(&s, entries, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatal(err)
+	}
+	s.DbConnection = "mongodb://octopus:hunter2@db:27017/?authSource=admin"
+	s.WebhookUrls = []string{"https://hooks.example.com/T000/B000/XXXX"}
+	s.TraceServiceName = "null"
+
+	var out bytes.Buffer
+	if err := printSettings(&out, s); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+
+	for _, secret := range []string{"hunter2", "authSource", "s3cr", "XXXX"} {
+		if strings.Contains(printed, secret) {
+			t.Fatalf("expected %v to be redacted but got\n%v", secret, printed)
+		}
+	}
+	for _, line := range []string{
+		`dbConnection: "mongodb://octopus:REDACTED@db:27017/?REDACTED"`,
+		"webhookSecret: REDACTED",
+		`queues: [edge, "transcode, urgent"]`,
+		"shutdownTimeout: 2m0s",
+		`httpAddress: ""`,
+		`traceServiceName: "null"`,
+	} {
+		if !strings.Contains(printed, line+"\n") {
+			t.Fatalf("expected %v but got\n%v", line, printed)
+		}
+	}
+
+	// the printed configuration is a valid configuration file
+	var reread settings
+	entries, err = parseConfig(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(&reread, entries, func(string) (string, bool) { return "", false }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reread.Queues, s.Queues) || reread.ShutdownTimeout != s.ShutdownTimeout ||
+		reread.TraceServiceName != "null" {
+		t.Fatalf("expected printed settings to read back but got %+v", reread)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"github.com/viktorburka/octopus/netio"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const cpUsage = `usage: octopus cp [flags] <src> <dst>
+
+Copies a file or a directory (src ending with '/' or -recursive)
+from src to dst url without the job store, e.g.
+
+  octopus cp https://example.com/video.mp4 s3://s3.amazonaws.com/bucket/video.mp4
+  octopus cp -part-size 64MB -concurrency 8 s3://s3.amazonaws.com/bucket/video.mp4 file:///tmp/video.mp4
+  octopus cp s3://s3.amazonaws.com/bucket/videos/ file:///tmp/videos/
+  octopus cp -sync -delete file:///data/videos/ s3://s3.amazonaws.com/bucket/videos/
+  octopus cp -decompress gzip -compress zstd s3://s3.amazonaws.com/bucket/logs.gz file:///tmp/logs.zst
+
+AWS credentials not given with the flags are taken from the
+standard AWS environment variables and configuration files.
+
+flags:
+`
+
+// exit codes
+const (
+	exitOk      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+type cpCommand struct {
+	src     string
+	dst     string
+	options map[string]string
+	quiet   bool
+	verbose bool
+}
+
+// runCp runs octopus cp command and returns the process exit code
+func runCp(ctx context.Context, args []string) int {
+
+	cmd, err := parseCpArgs(args, os.Stderr)
+	if err == flag.ErrHelp {
+		return exitOk
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "octopus cp:", err)
+		return exitUsage
+	}
+
+	if err := netio.ValidateTransfer(cmd.src, cmd.dst); err != nil {
+		fmt.Fprintln(os.Stderr, "octopus cp:", err)
+		return exitUsage
+	}
+
+	// transfer log would garble the progress bar
+	if cmd.verbose {
+		logger, _ := logging.New(os.Stderr, logging.FormatLogfmt, logging.LevelDebug)
+		logging.SetDefault(logger)
+	} else {
+		log.SetOutput(ioutil.Discard)
+		logging.SetDefault(logging.Discard)
+	}
+
+	var bar *progressBar
+	if !cmd.quiet && !cmd.verbose && isTerminal(os.Stderr) {
+		bar = newProgressBar(os.Stderr)
+		ctx = netio.WithProgress(ctx, bar.update)
+		bar.start()
+	}
+
+	var result *netio.DirectoryResult
+	var fileResult netio.Result
+	if netio.IsDirectoryTransfer(cmd.src, cmd.options) {
+		var r netio.DirectoryResult
+		r, err = netio.TransferDirectory(ctx, cmd.src, cmd.dst, cmd.options)
+		result = &r
+	} else {
+		fileResult, err = netio.Transfer(ctx, cmd.src, cmd.dst, cmd.options)
+	}
+
+	if bar != nil {
+		bar.finish()
+	}
+
+	if result == nil && err == nil && !cmd.quiet {
+		fmt.Fprintln(os.Stderr, newJobResult(fileResult).summary())
+	}
+
+	if result != nil {
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", e.Path, e.Err)
+		}
+		if !cmd.quiet {
+			fmt.Fprintf(os.Stderr, "copied %v of %v files (%v)",
+				result.Copied, result.Total, formatBytes(result.Bytes))
+			if cmd.options["sync"] == "true" {
+				fmt.Fprintf(os.Stderr, ", %v up to date, %v deleted", result.Skipped, result.Deleted)
+			}
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("interrupted: %v", err)
+		}
+		fmt.Fprintln(os.Stderr, "octopus cp:", err)
+		return exitFailure
+	}
+
+	return exitOk
+}
+
+// parseCpArgs parses command line into transfer options
+func parseCpArgs(args []string, output io.Writer) (cpCommand, error) {
+
+	var cmd cpCommand
+
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprint(output, cpUsage)
+		fs.PrintDefaults()
+	}
+
+	partSize := fs.String("part-size", "", "part size for s3 ranged download and multipart upload, e.g. 16MB (default 5MB)")
+	concurrency := fs.Int("concurrency", 0, "number of parts transferred in parallel (default 3 for download, 5 for upload)")
+	bucketStyle := fs.String("bucket-style", "path-style", "s3 bucket name style: path-style or virtual-hosted-style")
+	checksum := fs.String("checksum", netio.ChecksumNone, "checksum mode: none or md5 (verify uploaded s3 parts with Content-MD5)")
+	region := fs.String("region", "", "AWS region")
+	endpoint := fs.String("endpoint", "", "S3 compatible storage endpoint url")
+	accessKey := fs.String("access-key", "", "AWS access key id")
+	secretKey := fs.String("secret-key", "", "AWS secret access key")
+	sessionToken := fs.String("session-token", "", "AWS session token")
+	recursive := fs.Bool("recursive", false, "copy all the files under src prefix")
+	parallelFiles := fs.Int("parallel-files", 0, "number of files copied in parallel for directories (default 4)")
+	manifest := fs.String("manifest", "", "file listing http directory files (default manifest.txt)")
+	syncFiles := fs.Bool("sync", false, "copy only the files missing or different in dst")
+	compare := fs.String("compare", "", "sync comparison: size, mtime or etag (default mtime)")
+	deleteMissing := fs.Bool("delete", false, "with -sync delete dst files missing from src directory")
+	encrypt := fs.Bool("encrypt", false, "encrypt the data with a key from -key-file before uploading it")
+	decrypt := fs.Bool("decrypt", false, "decrypt the data encrypted with -encrypt")
+	keyFile := fs.String("key-file", "", "file with the master keys for -encrypt and -decrypt")
+	keyId := fs.String("key-id", "", "master key to encrypt with (default the first one in -key-file)")
+	compress := fs.String("compress", "", "compress the data before uploading it: gzip or zstd")
+	decompress := fs.String("decompress", "", "decompress the source data: gzip, zstd or bzip2")
+	fs.BoolVar(&cmd.quiet, "quiet", false, "don't show progress bar")
+	fs.BoolVar(&cmd.verbose, "verbose", false, "print transfer log instead of progress bar")
+
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return cmd, err
+	}
+
+	if len(positional) != 2 {
+		fs.Usage()
+		return cmd, fmt.Errorf("expected <src> and <dst> but got %v arguments", len(positional))
+	}
+
+	cmd.src = positional[0]
+	cmd.dst = positional[1]
+	cmd.options = map[string]string{"bucketNameStyle": *bucketStyle}
+
+	if *partSize != "" {
+		size, err := parseSize(*partSize)
+		if err != nil {
+			return cmd, fmt.Errorf("invalid -part-size: %v", err)
+		}
+		cmd.options["partSize"] = strconv.FormatInt(size, 10)
+	}
+
+	if *concurrency < 0 {
+		return cmd, fmt.Errorf("invalid -concurrency: %v", *concurrency)
+	}
+	if *concurrency > 0 {
+		cmd.options["concurrency"] = strconv.Itoa(*concurrency)
+	}
+
+	if *recursive {
+		cmd.options["recursive"] = "true"
+	}
+
+	if *parallelFiles < 0 {
+		return cmd, fmt.Errorf("invalid -parallel-files: %v", *parallelFiles)
+	}
+	if *parallelFiles > 0 {
+		cmd.options["parallelFiles"] = strconv.Itoa(*parallelFiles)
+	}
+
+	if *manifest != "" {
+		cmd.options["manifest"] = *manifest
+	}
+
+	if !*syncFiles && (*compare != "" || *deleteMissing) {
+		return cmd, fmt.Errorf("-compare and -delete require -sync")
+	}
+	if *syncFiles {
+		cmd.options["sync"] = "true"
+	}
+	switch *compare {
+	case "":
+	case netio.SyncCompareSize, netio.SyncCompareModTime, netio.SyncCompareETag:
+		cmd.options["syncCompare"] = *compare
+	default:
+		return cmd, fmt.Errorf("invalid -compare: %q", *compare)
+	}
+	if *deleteMissing {
+		cmd.options["syncDelete"] = "true"
+	}
+
+	if *encrypt && *decrypt {
+		return cmd, fmt.Errorf("-encrypt and -decrypt are exclusive")
+	}
+	if (*encrypt || *decrypt) != (*keyFile != "") {
+		return cmd, fmt.Errorf("-encrypt and -decrypt require -key-file and the other way round")
+	}
+	if *keyId != "" && !*encrypt {
+		return cmd, fmt.Errorf("-key-id requires -encrypt")
+	}
+	switch {
+	case *encrypt:
+		cmd.options["encryption"] = netio.EncryptionEncrypt
+	case *decrypt:
+		cmd.options["encryption"] = netio.EncryptionDecrypt
+	}
+	if *keyFile != "" {
+		cmd.options["encryptionKeyFile"] = *keyFile
+	}
+	if *keyId != "" {
+		cmd.options["encryptionKeyId"] = *keyId
+	}
+
+	switch *compress {
+	case "":
+	case netio.CompressionGzip, netio.CompressionZstd:
+		cmd.options["compress"] = *compress
+	default:
+		return cmd, fmt.Errorf("invalid -compress: %q", *compress)
+	}
+	switch *decompress {
+	case "":
+	case netio.CompressionGzip, netio.CompressionZstd, netio.CompressionBzip2:
+		cmd.options["decompress"] = *decompress
+	default:
+		return cmd, fmt.Errorf("invalid -decompress: %q", *decompress)
+	}
+
+	if *checksum != netio.ChecksumNone && *checksum != netio.ChecksumMD5 {
+		return cmd, fmt.Errorf("invalid -checksum: %q", *checksum)
+	}
+	cmd.options["checksum"] = *checksum
+
+	for key, val := range map[string]string{
+		"awsRegion":          *region,
+		"awsEndpoint":        *endpoint,
+		"awsAccessKeyId":     *accessKey,
+		"awsSecretAccessKey": *secretKey,
+		"awsSessionToken":    *sessionToken,
+	} {
+		if val != "" {
+			cmd.options[key] = val
+		}
+	}
+
+	return cmd, nil
+}
+
+// parseFlags parses the flags that may come both before and
+// after the positional arguments and returns the latter
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseSize parses byte size with optional K, M or G suffix (powers of 1024)
+func parseSize(s string) (int64, error) {
+
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(strings.TrimSuffix(num, "B"), "I")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(num, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(num, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(num, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		num = num[:len(num)-1]
+	}
+
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return n * multiplier, nil
+}
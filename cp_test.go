@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+
+	tests := map[string]int64{
+		"1024":  1024,
+		"16K":   16 * 1024,
+		"16kb":  16 * 1024,
+		"8MB":   8 * 1024 * 1024,
+		"8MiB":  8 * 1024 * 1024,
+		"1G":    1024 * 1024 * 1024,
+		" 5mb ": 5 * 1024 * 1024,
+	}
+
+	for s, expected := range tests {
+		n, err := parseSize(s)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", s, err)
+		}
+		if n != expected {
+			t.Fatalf("expected %q to be %v bytes but got %v", s, expected, n)
+		}
+	}
+
+	for _, s := range []string{"", "MB", "-1MB", "0", "1.5MB", "10TB"} {
+		if _, err := parseSize(s); err == nil {
+			t.Fatalf("expected parseSize(%q) to return an error", s)
+		}
+	}
+}
+
+func TestParseCpArgs(t *testing.T) {
+
+	args := []string{"-part-size", "16MB", "s3://s3.amazonaws.com/bucket/key.mp4",
+		"file:///tmp/key.mp4", "-concurrency", "8", "-checksum", "md5", "-region", "us-east-1"}
+
+	cmd, err := parseCpArgs(args, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.src != "s3://s3.amazonaws.com/bucket/key.mp4" || cmd.dst != "file:///tmp/key.mp4" {
+		t.Fatalf("unexpected src %v and dst %v", cmd.src, cmd.dst)
+	}
+
+	expected := map[string]string{
+		"bucketNameStyle": "path-style",
+		"partSize":        "16777216",
+		"concurrency":     "8",
+		"checksum":        "md5",
+		"awsRegion":       "us-east-1",
+	}
+	if len(cmd.options) != len(expected) {
+		t.Fatalf("expected options %v but got %v", expected, cmd.options)
+	}
+	for key, val := range expected {
+		if cmd.options[key] != val {
+			t.Fatalf("expected option %v to be %q but got %q", key, val, cmd.options[key])
+		}
+	}
+
+	invalid := [][]string{
+		{"s3://s3.amazonaws.com/bucket/key.mp4"},
+		{"a", "b", "c"},
+		{"-part-size", "big", "a", "b"},
+		{"-checksum", "sha1", "a", "b"},
+		{"-concurrency", "-1", "a", "b"},
+		{"-unknown", "a", "b"},
+		{"-encrypt", "a", "b"},
+		{"-encrypt", "-decrypt", "-key-file", "keys", "a", "b"},
+		{"-decrypt", "-key-file", "keys", "-key-id", "k1", "a", "b"},
+		{"-delete", "a", "b"},
+		{"-sync", "-compare", "crc", "a", "b"},
+		{"-compress", "bzip2", "a", "b"},
+		{"-decompress", "xz", "a", "b"},
+	}
+	for _, args := range invalid {
+		if _, err := parseCpArgs(args, ioutil.Discard); err == nil {
+			t.Fatalf("expected parseCpArgs(%q) to return an error", args)
+		}
+	}
+}
+
+func TestCpHttpToFile(t *testing.T) {
+
+	content := bytes.Repeat([]byte{0xEF}, 512*1024)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "video.mp4", time.Now(), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "octopus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "out", "video.mp4")
+
+	if code := runCp(context.Background(), []string{"-quiet", srv.URL + "/video.mp4", "file://" + dst}); code != exitOk {
+		t.Fatalf("expected exit code %v but got %v", exitOk, code)
+	}
+
+	buf, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, content) {
+		t.Fatalf("expected %v bytes copied but got %v", len(content), len(buf))
+	}
+
+	if code := runCp(context.Background(), []string{"-quiet", "ftp://example.com/video.mp4", "file://" + dst}); code != exitUsage {
+		t.Fatalf("expected exit code %v for unsupported scheme but got %v", exitUsage, code)
+	}
+}
+
+func TestCpDirectory(t *testing.T) {
+
+	src, err := ioutil.TempDir("", "octopus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst, err := ioutil.TempDir("", "octopus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	if code := runCp(context.Background(), []string{"-quiet", "-recursive", "file://" + src, "file://" + dst}); code != exitOk {
+		t.Fatalf("expected exit code %v but got %v", exitOk, code)
+	}
+
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		buf, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != name {
+			t.Fatalf("expected %v to be copied but got %q", name, buf)
+		}
+	}
+}
+
+func TestProgressBarLine(t *testing.T) {
+
+	b := newProgressBar(ioutil.Discard)
+
+	line := b.line(5*1024*1024, 10*1024*1024, time.Second)
+	expected := "[===============>              ]  50%  5.0 MiB / 10.0 MiB  5.0 MiB/s"
+	if line != expected {
+		t.Fatalf("expected progress line %q but got %q", expected, line)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression. It supports the standard
+// five fields (minute, hour, day of month, month, day of week) with
+// lists, ranges and steps, the usual @-descriptors and "@every <duration>"
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	every  time.Duration
+	// day of month and day of week restrictions are OR-ed
+	// when both are set (the way cron does it)
+	domStar bool
+	dowStar bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var (
+	minuteField = cronField{0, 59}
+	hourField   = cronField{0, 23}
+	domField    = cronField{1, 31}
+	monthField  = cronField{1, 12}
+	dowField    = cronField{0, 6}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+		}
+		if d < time.Minute {
+			return nil, fmt.Errorf("invalid cron expression %q: interval must be at least 1m", spec)
+		}
+		return &cronSchedule{every: d}, nil
+	}
+
+	if expr, ok := cronDescriptors[spec]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields but got %v", spec, len(fields))
+	}
+
+	var err error
+	var c cronSchedule
+
+	if c.minute, err = parseCronField(fields[0], minuteField); err != nil {
+		return nil, fmt.Errorf("invalid cron minute field: %v", err)
+	}
+	if c.hour, err = parseCronField(fields[1], hourField); err != nil {
+		return nil, fmt.Errorf("invalid cron hour field: %v", err)
+	}
+	if c.dom, err = parseCronField(fields[2], domField); err != nil {
+		return nil, fmt.Errorf("invalid cron day of month field: %v", err)
+	}
+	if c.month, err = parseCronField(fields[3], monthField); err != nil {
+		return nil, fmt.Errorf("invalid cron month field: %v", err)
+	}
+	// 7 is an alias for sunday
+	if c.dow, err = parseCronField(fields[4], cronField{0, 7}); err != nil {
+		return nil, fmt.Errorf("invalid cron day of week field: %v", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domStar = fields[2] == "*" || strings.HasPrefix(fields[2], "*/")
+	c.dowStar = fields[4] == "*" || strings.HasPrefix(fields[4], "*/")
+
+	return &c, nil
+}
+
+// parseCronField converts a single field like "1,5-10,*/15"
+// into a bit set of matching values
+func parseCronField(field string, f cronField) (uint64, error) {
+
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			v, err := strconv.Atoi(part[idx+1:])
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = v
+			part = part[:idx]
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			idx := strings.Index(part, "-")
+			var err error
+			if lo, err = strconv.Atoi(part[:idx]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			if hi, err = strconv.Atoi(part[idx+1:]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = v, v
+			if step > 1 { // "5/15" means starting at 5
+				hi = f.max
+			}
+		}
+
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("value %q is out of range [%v,%v]", part, f.min, f.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// next returns the first activation time strictly after t
+func (c *cronSchedule) next(t time.Time) time.Time {
+
+	if c.every > 0 {
+		return t.Add(c.every).Truncate(time.Minute)
+	}
+
+	has := func(bits uint64, v int) bool { return bits&(1<<uint(v)) != 0 }
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// there is always a match within 5 years (Feb 29 on a certain weekday)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(c.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(c.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !has(c.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
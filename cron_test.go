@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+
+	base := time.Date(2019, time.April, 10, 14, 37, 20, 0, time.UTC) // wednesday
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2019, time.April, 10, 14, 38, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2019, time.April, 10, 14, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2019, time.April, 11, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2019, time.April, 11, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2019, time.April, 10, 15, 0, 0, 0, time.UTC)},
+		{"30 1 1 * *", time.Date(2019, time.May, 1, 1, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2019, time.April, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2019, time.April, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2019, time.April, 11, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// day of month OR day of week
+		{"0 0 13 * 5", time.Date(2019, time.April, 12, 0, 0, 0, 0, time.UTC)},
+		{"@every 2h", time.Date(2019, time.April, 10, 16, 37, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.spec)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", test.spec, err)
+		}
+		if next := c.next(base); !next.Equal(test.next) {
+			t.Fatalf("expected %q to fire at %v but got %v", test.spec, test.next, next)
+		}
+	}
+}
+
+func TestCronInvalidExpression(t *testing.T) {
+
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every 10s",
+		"@every tomorrow",
+	}
+
+	for _, spec := range specs {
+		if _, err := parseCron(spec); err == nil {
+			t.Fatalf("expected parseCron(%q) to return an error", spec)
+		}
+	}
+}
+
+func TestScheduleNextRunTimezone(t *testing.T) {
+
+	sc := schedule{Cron: "0 2 * * *", Timezone: "America/New_York"}
+	base := time.Date(2019, time.April, 10, 12, 0, 0, 0, time.UTC)
+
+	next, err := sc.nextRun(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2am EDT is 6am UTC
+	expected := time.Date(2019, time.April, 11, 6, 0, 0, 0, time.UTC)
+	if !next.Equal(expected) {
+		t.Fatalf("expected next run at %v but got %v", expected, next)
+	}
+
+	sc.Timezone = "Nowhere/Invalid"
+	if _, err := sc.nextRun(base); err == nil {
+		t.Fatalf("expected nextRun() to return an error due to invalid timezone")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// how many jobs with dependencies are read from the store at once
+const dependentBatchSize = 50
+
+const (
+	dependenciesReady = iota
+	dependenciesPending
+	dependenciesFailed
+)
+
+// dependencyState reports whether all the given jobs are complete, some are
+// still in progress or any of them has failed, has been cancelled (or doesn't
+// exist) in which case the dependent job will never be able to run. statusOf
+// returns the job status and false if there is no such job.
+func dependencyState(deps []primitive.ObjectID,
+	statusOf func(id primitive.ObjectID) (string, bool)) (int, string) {
+
+	state := dependenciesReady
+	for _, id := range deps {
+		status, ok := statusOf(id)
+		switch {
+		case !ok:
+			return dependenciesFailed, fmt.Sprintf("dependency %v not found", id.Hex())
+		case status == failed:
+			return dependenciesFailed, fmt.Sprintf("dependency %v failed", id.Hex())
+		case status == cancelled:
+			return dependenciesFailed, fmt.Sprintf("dependency %v cancelled", id.Hex())
+		case status != complete:
+			state = dependenciesPending
+		}
+	}
+
+	return state, ""
+}
+
+// errorCode of the jobs failed because of their dependencies
+const dependencyFailedCode = "DependencyFailed"
+
+func dependencyFailedReason(id primitive.ObjectID) string {
+	return fmt.Sprintf("dependency %v failed", id.Hex())
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// the event loop is considered stuck if it hasn't
+// ticked for that many EventLoopSleep intervals
+const stalledLoopTicks = 5
+
+// agentHealth is what the event loop tells about itself to the health
+// checks. It is nil for octopus serve that doesn't run the event loop.
+type agentHealth struct {
+	m        sync.Mutex
+	lastTick time.Time
+	jobs     int // running and being claimed
+	maxJobs  int
+	// the interval the event loop ticks at
+	eventLoopSleep time.Duration
+}
+
+func newAgentHealth(maxJobs int, eventLoopSleep time.Duration) *agentHealth {
+	return &agentHealth{maxJobs: maxJobs, eventLoopSleep: eventLoopSleep}
+}
+
+// tick records that the event loop is alive and how many jobs it runs
+func (h *agentHealth) tick(now time.Time, jobs int) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.lastTick = now
+	h.jobs = jobs
+}
+
+func (h *agentHealth) setMaxJobs(maxJobs int) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.maxJobs = maxJobs
+}
+
+func (h *agentHealth) setEventLoopSleep(eventLoopSleep time.Duration) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.eventLoopSleep = eventLoopSleep
+}
+
+// sinceTick returns when the event loop ticked last and the interval it ticks at
+func (h *agentHealth) sinceTick() (time.Time, time.Duration) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.lastTick, h.eventLoopSleep
+}
+
+func (h *agentHealth) state() (time.Time, int, int) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.lastTick, h.jobs, h.maxJobs
+}
+
+// healthHandler implements the Kubernetes probes:
+//
+//	GET /healthz  the process is alive and the event loop is ticking
+//	GET /readyz   the job store is reachable, the temp dir is writable
+//	              and there are free job slots
+type healthHandler struct {
+	store  JobStore
+	s      settings
+	health *agentHealth
+}
+
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+const (
+	healthOk          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+func (h *healthHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
+}
+
+func (h *healthHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]error{}
+	if h.health != nil {
+		checks["eventLoop"] = h.checkEventLoop(time.Now())
+	}
+	writeHealth(w, r, checks)
+}
+
+func (h *healthHandler) readyz(w http.ResponseWriter, r *http.Request) {
+
+	timeout, cancel := context.WithTimeout(r.Context(), h.s.DbOpTimeout)
+	defer cancel()
+
+	checks := map[string]error{
+		"store":   h.store.Ping(timeout),
+		"tempDir": checkTempDir(),
+	}
+	if h.health != nil {
+		checks["slots"] = h.checkSlots()
+	}
+	writeHealth(w, r, checks)
+}
+
+func (h *healthHandler) checkEventLoop(now time.Time) error {
+	lastTick, eventLoopSleep := h.health.sinceTick()
+	if lastTick.IsZero() {
+		return fmt.Errorf("event loop hasn't started")
+	}
+	if stalled := now.Sub(lastTick); stalled > stalledLoopTicks*eventLoopSleep {
+		return fmt.Errorf("event loop hasn't ticked for %v", stalled.Round(time.Second))
+	}
+	return nil
+}
+
+func (h *healthHandler) checkSlots() error {
+	_, jobs, maxJobs := h.health.state()
+	if jobs >= maxJobs {
+		return fmt.Errorf("all %v job slots are taken", maxJobs)
+	}
+	return nil
+}
+
+// checkTempDir makes sure the part files can be created
+func checkTempDir() error {
+	file, err := ioutil.TempFile(os.TempDir(), "octopus-readyz")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// writeHealth responds with 200 if all checks passed and 503 otherwise
+func writeHealth(w http.ResponseWriter, r *http.Request, checks map[string]error) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	status := healthStatus{Status: healthOk, Checks: make(map[string]string, len(checks))}
+	for name, err := range checks {
+		if err != nil {
+			status.Status = healthUnavailable
+			status.Checks[name] = err.Error()
+			continue
+		}
+		status.Checks[name] = healthOk
+	}
+
+	code := http.StatusOK
+	if status.Status != healthOk {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
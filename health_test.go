@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getHealth(t *testing.T, handler http.Handler, path string) (int, healthStatus) {
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var status healthStatus
+	if resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, status
+}
+
+func TestHealthz(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	s := settings{DbOpTimeout: time.Second, EventLoopSleep: time.Second}
+	health := newAgentHealth(2, s.EventLoopSleep)
+	handler := newHttpHandler(store, s, health)
+
+	if code, status := getHealth(t, handler, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected not started event loop to fail but got %v %+v", code, status)
+	}
+
+	health.tick(time.Now(), 0)
+	if code, status := getHealth(t, handler, "/healthz"); code != http.StatusOK || status.Checks["eventLoop"] != healthOk {
+		t.Fatalf("expected ticking event loop to be healthy but got %v %+v", code, status)
+	}
+
+	health.tick(time.Now().Add(-time.Minute), 0)
+	if code, status := getHealth(t, handler, "/healthz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected stalled event loop to fail but got %v %+v", code, status)
+	}
+
+	// octopus serve
+	if code, status := getHealth(t, newHttpHandler(store, s, nil), "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected serve to be healthy but got %v %+v", code, status)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	s := settings{DbOpTimeout: time.Second, EventLoopSleep: time.Second}
+	health := newAgentHealth(2, s.EventLoopSleep)
+	handler := newHttpHandler(store, s, health)
+
+	health.tick(time.Now(), 1)
+	code, status := getHealth(t, handler, "/readyz")
+	if code != http.StatusOK || status.Checks["store"] != healthOk || status.Checks["tempDir"] != healthOk ||
+		status.Checks["slots"] != healthOk {
+		t.Fatalf("expected agent to be ready but got %v %+v", code, status)
+	}
+
+	health.tick(time.Now(), 2)
+	if code, status := getHealth(t, handler, "/readyz"); code != http.StatusServiceUnavailable || status.Checks["slots"] == healthOk {
+		t.Fatalf("expected agent without free slots not to be ready but got %v %+v", code, status)
+	}
+
+	// the store file can't be locked in a missing directory
+	broken := newFileStore(filepath.Join(store.path, "missing", "octopus.json"))
+	code, status = getHealth(t, newHttpHandler(broken, s, nil), "/readyz")
+	if code != http.StatusServiceUnavailable || status.Checks["store"] == healthOk {
+		t.Fatalf("expected unreachable store not to be ready but got %v %+v", code, status)
+	}
+}
+
+func TestPprof(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	// the profiles are never served along with the API
+	s := settings{DbOpTimeout: time.Second, PprofAddress: "127.0.0.1:6060"}
+	if code, _ := getHealth(t, newHttpHandler(store, s, nil), "/debug/pprof/"); code != http.StatusNotFound {
+		t.Fatalf("expected pprof not to be served on the API address but got %v", code)
+	}
+	if code, _ := getHealth(t, newPprofHandler(), "/debug/pprof/"); code != http.StatusOK {
+		t.Fatalf("expected pprof to be served but got %v", code)
+	}
+
+	for addr, ok := range map[string]bool{"127.0.0.1:6060": true, "localhost:6060": true, "[::1]:6060": true,
+		":6060": false, "0.0.0.0:6060": false, "10.0.0.7:6060": false} {
+		s.PprofAddress = addr
+		err := s.validate("serve")
+		if invalid := err != nil && strings.Contains(err.Error(), "pprofAddress"); invalid == ok {
+			t.Fatalf("expected pprof address %v to be valid: %v but got %v", addr, ok, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"github.com/viktorburka/octopus/logging"
+	"github.com/viktorburka/octopus/metrics"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+const defaultHttpAddress = "127.0.0.1:8080"
+
+// time given to the in-flight requests to finish on shutdown
+const httpShutdownTimeout = 5 * time.Second
+
+// newHttpHandler serves the API, metrics and health checks;
+// health is nil if the event loop doesn't run
+func newHttpHandler(store JobStore, s settings, health *agentHealth) http.Handler {
+	mux := http.NewServeMux()
+	newApiHandler(store, s).register(mux)
+	(&healthHandler{store: store, s: s, health: health}).register(mux)
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// newPprofHandler serves the Go profiles
+func newPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// servePprof serves the Go profiles on PprofAddress in the
+// background until ctx is done; the profiles are not served
+// on the API address as they are not authenticated
+func servePprof(ctx context.Context, s settings) {
+	if s.PprofAddress == "" {
+		return
+	}
+	go func() {
+		err := serveHttp(ctx, s.PprofAddress, newPprofHandler())
+		if err != nil && err != http.ErrServerClosed {
+			logging.FromContext(ctx).Error("pprof server failed", "err", err)
+		}
+	}()
+}
+
+// serveHttp runs HTTP server until ctx is done
+func serveHttp(ctx context.Context, addr string, handler http.Handler) error {
+
+	srv := &http.Server{Addr: addr, Handler: handler}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		timeout, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(timeout)
+	}
+}
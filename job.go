@@ -2,95 +2,418 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/viktorburka/octopus/logging"
 	"github.com/viktorburka/octopus/netio"
-    "go.mongodb.org/mongo-driver/bson"
-    "go.mongodb.org/mongo-driver/bson/primitive"
-    "go.mongodb.org/mongo-driver/mongo"
-    "go.mongodb.org/mongo-driver/mongo/options"
-    "log"
+	"github.com/viktorburka/octopus/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync/atomic"
 	"time"
 )
 
 const (
-    created  string = "Created"
-    running  string = "Running"
-    complete string = "Complete"
-    failed   string = "Failed"
+	created   string = "Created"
+	running   string = "Running"
+	complete  string = "Complete"
+	failed    string = "Failed"
+	cancelled string = "Cancelled"
 )
 
 type job struct {
-    Id primitive.ObjectID `json:"_id"         bson:"_id"`
-    Status string         `json:"status"      bson:"status"`
-    SrcUrl string         `json:"srcUrl"      bson:"srcUrl"`
-    DstUrl string         `json:"dstUrl"      bson:"dstUrl"`
-    Description string    `json:"description" bson:"description"`
+	Id          primitive.ObjectID `json:"_id"         bson:"_id"`
+	Status      string             `json:"status"      bson:"status"`
+	SrcUrl      string             `json:"srcUrl"      bson:"srcUrl"`
+	DstUrl      string             `json:"dstUrl"      bson:"dstUrl"`
+	Description string             `json:"description" bson:"description"`
+	// NotBefore holds the job back until the given time
+	NotBefore *time.Time `json:"notBefore,omitempty" bson:"notBefore,omitempty"`
+	// ScheduleId is set for the jobs spawned by a recurring schedule
+	ScheduleId *primitive.ObjectID `json:"scheduleId,omitempty" bson:"scheduleId,omitempty"`
+	// DependsOn lists the jobs that must be complete before this one starts
+	DependsOn []primitive.ObjectID `json:"dependsOn,omitempty" bson:"dependsOn,omitempty"`
+	Error     string               `json:"error,omitempty"     bson:"error,omitempty"`
+	// ErrorCode is the machine-readable kind of the Error:
+	// netio.ErrorCode of the transfer error or DependencyFailed
+	ErrorCode string `json:"errorCode,omitempty" bson:"errorCode,omitempty"`
+	// Attempts counts the failed runs retried automatically
+	Attempts int `json:"attempts,omitempty" bson:"attempts,omitempty"`
+	// Queue and Requires route the job to the agents serving the
+	// queue and providing all of the required capabilities
+	Queue    string   `json:"queue,omitempty"    bson:"queue,omitempty"`
+	Requires []string `json:"requires,omitempty" bson:"requires,omitempty"`
+	// Heartbeat is updated periodically while the job is running
+	Heartbeat *time.Time   `json:"heartbeat,omitempty" bson:"heartbeat,omitempty"`
+	Progress  *jobProgress `json:"progress,omitempty"  bson:"progress,omitempty"`
+	// Files sums up the directory transfer
+	Files *jobFiles `json:"files,omitempty" bson:"files,omitempty"`
+	// Result sums up the file transfer
+	Result *jobResult `json:"result,omitempty" bson:"result,omitempty"`
+	// Options are passed to netio.Transfer as is
+	Options map[string]string `json:"options,omitempty" bson:"options,omitempty"`
 }
 
+// secretOptions are the job options hidden from the notifications and the API
+var secretOptions = []string{"awsSecretAccessKey", "awsSessionToken", "encryptionKeyFile"}
+
+// redacted returns a copy of the job with the secret options and the
+// passwords and queries of the urls hidden
+func (j job) redacted() job {
+	j.SrcUrl = redactUrl(j.SrcUrl)
+	j.DstUrl = redactUrl(j.DstUrl)
+	if len(j.Options) > 0 {
+		options := make(map[string]string, len(j.Options))
+		for key, val := range j.Options {
+			options[key] = val
+		}
+		for _, key := range secretOptions {
+			if _, ok := options[key]; ok {
+				options[key] = redacted
+			}
+		}
+		j.Options = options
+	}
+	return j
+}
+
+type jobProgress struct {
+	Bytes int64 `json:"bytes" bson:"bytes"`
+	Total int64 `json:"total" bson:"total"`
+}
+
+type jobFiles struct {
+	Total   int   `json:"total"   bson:"total"`
+	Copied  int   `json:"copied"  bson:"copied"`
+	Failed  int   `json:"failed"  bson:"failed"`
+	Skipped int   `json:"skipped" bson:"skipped"`
+	Deleted int   `json:"deleted" bson:"deleted"`
+	Bytes   int64 `json:"bytes"   bson:"bytes"`
+	// Errors lists up to maxFileErrors failed files
+	Errors []jobFileError `json:"errors,omitempty" bson:"errors,omitempty"`
+}
+
+type jobFileError struct {
+	Path  string `json:"path"  bson:"path"`
+	Error string `json:"error" bson:"error"`
+}
+
+// keeps the job document size reasonable
+const maxFileErrors = 100
+
+func newJobFiles(r netio.DirectoryResult) jobFiles {
+	files := jobFiles{
+		Total:   r.Total,
+		Copied:  r.Copied,
+		Failed:  len(r.Errors),
+		Skipped: r.Skipped,
+		Deleted: r.Deleted,
+		Bytes:   r.Bytes,
+	}
+	for i, e := range r.Errors {
+		if i == maxFileErrors {
+			break
+		}
+		files.Errors = append(files.Errors, jobFileError{Path: e.Path, Error: e.Err.Error()})
+	}
+	return files
+}
+
+type jobResult struct {
+	Strategy       string            `json:"strategy"            bson:"strategy"`
+	Bytes          int64             `json:"bytes"               bson:"bytes"`
+	Duration       float64           `json:"duration"            bson:"duration"`       // seconds
+	Throughput     int64             `json:"throughput"          bson:"throughput"`     // bytes per second
+	PeakThroughput int64             `json:"peakThroughput"      bson:"peakThroughput"` // bytes per second
+	Parts          int               `json:"parts"               bson:"parts"`
+	Retries        int               `json:"retries"             bson:"retries"`
+	ETag           string            `json:"etag,omitempty"      bson:"etag,omitempty"`
+	VersionId      string            `json:"versionId,omitempty" bson:"versionId,omitempty"`
+	Checksums      map[string]string `json:"checksums,omitempty" bson:"checksums,omitempty"`
+	UpToDate       bool              `json:"upToDate,omitempty"  bson:"upToDate,omitempty"`
+}
+
+func newJobResult(r netio.Result) jobResult {
+	return jobResult{
+		Strategy:       r.Strategy,
+		Bytes:          r.Bytes,
+		Duration:       r.Duration.Seconds(),
+		Throughput:     r.Throughput,
+		PeakThroughput: r.PeakThroughput,
+		Parts:          r.Parts,
+		Retries:        r.Retries,
+		ETag:           r.ETag,
+		VersionId:      r.VersionId,
+		Checksums:      r.Checksums,
+		UpToDate:       r.UpToDate,
+	}
+}
+
+// summary describes the result in one line, e.g.
+// 12.3 MiB in 4.2s (2.9 MiB/s, peak 3.1 MiB/s), 3 parts, 0 retries, concurrent
+func (r jobResult) summary() string {
+	if r.UpToDate {
+		return "destination is up to date"
+	}
+	return fmt.Sprintf("%v in %.1fs (%v/s, peak %v/s), %v parts, %v retries, %v",
+		formatBytes(r.Bytes), r.Duration, formatBytes(r.Throughput), formatBytes(r.PeakThroughput),
+		r.Parts, r.Retries, r.Strategy)
+}
+
+const (
+	// jobs without queue go to the default queue
+	defaultQueue = "default"
+	// agents serving any queue pick up jobs from all of them
+	anyQueue = "*"
+)
+
 type jobStatus struct {
-    jobError error
+	jobError error
 }
 
-func startJob(ctx context.Context, collection *mongo.Collection, t time.Duration, proc chan jobStatus) {
+// jobCreatedAt returns the job creation time encoded in its id
+func jobCreatedAt(id primitive.ObjectID) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint32(id[0:4])), 0).UTC()
+}
 
-    var transErr error
-    defer func() {proc<-jobStatus{jobError:transErr}}()
+// minObjectID returns the smallest id that can be generated at t
+func minObjectID(t time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[0:4], uint32(t.Unix()))
+	return id
+}
+
+// startJob claims a job and runs it. The outcome of the claim is sent to claims
+// and if a job has been claimed its outcome is sent to proc after it is finished.
+// The job status changes are sent to notify and the running job is tracked by registry.
+func startJob(ctx context.Context, store JobStore, s settings, notify *notifier, registry *agentRegistry,
+	claims chan<- bool, proc chan<- jobStatus) {
+
+	timeout, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	logger := logging.FromContext(ctx)
+
+	logger.Debug("querying new jobs")
+	claimStart := time.Now()
+	newJob, err := store.Claim(timeout, newClaimRequest(s))
+	if err != nil {
+		logger.Error("can't claim job", "err", err)
+		claims <- false
+		return
+	}
+	if newJob == nil {
+		logger.Debug("no new jobs")
+		claims <- false
+		return
+	}
+
+	// every record of the job tells which one it is
+	logger = logger.With("job", newJob.Id.Hex(), "src", newJob.SrcUrl, "dst", newJob.DstUrl,
+		"attempt", newJob.Attempts+1)
+	ctx = logging.NewContext(ctx, logger)
+
+	// the trace of the job starts with the claim which
+	// is known to belong to it only once it succeeded
+	ctx, jobSpan := tracing.StartAt(ctx, "job", claimStart, "job", newJob.Id.Hex(),
+		"src", newJob.SrcUrl, "dst", newJob.DstUrl, "attempt", newJob.Attempts+1)
+	_, claimSpan := tracing.StartAt(ctx, "claim", claimStart)
+	claimSpan.End()
+
+	claims <- true
+
+	var transErr error
+	defer func() { proc <- jobStatus{jobError: transErr} }()
+	defer jobSpan.End()
+
+	srcScheme, dstScheme := schemePair(newJob)
+	jobsClaimed.With(srcScheme, dstScheme).Inc()
+	jobsRunning.Inc()
+	defer jobsRunning.Dec()
+
+	registry.jobStarted(newJob.Id)
+	defer registry.jobFinished(newJob.Id)
 
-    timeout, cancel := context.WithTimeout(ctx, t)
-    defer cancel()
+	logger.Info("starting transfer")
+	notify.notify(ctx, jobNotification{Event: running, Job: *newJob})
 
-    docOpt := &options.FindOneAndUpdateOptions{}
-	docOpt.SetReturnDocument(options.After)
+	// bucket can be 'path-style' or 'virtual-hosted–style'
+	opt := map[string]string{"bucketNameStyle": "path-style"}
+	for k, v := range newJob.Options {
+		opt[k] = v
+	}
 
-    var newJob job
+	var done, total int64
+	trCtx := netio.WithProgress(ctx, func(bytesDone int64, bytesTotal int64) {
+		atomic.StoreInt64(&done, bytesDone)
+		atomic.StoreInt64(&total, bytesTotal)
+	})
 
-	log.Println("Querying new jobs...")
-    result := collection.FindOneAndUpdate(timeout,
-        bson.M{"status": created},
-		map[string]bson.M{"$set": {"status": running}},
-		docOpt)
+	trCtx, cancelTransfer := context.WithCancel(trCtx)
+	defer cancelTransfer()
 
-    log.Println("Parsing...")
-    if err := result.Decode(&newJob); err != nil {
-        // do not consider ErrNoDocuments as error
-        if err != mongo.ErrNoDocuments {
-            transErr = err
-            log.Println("error:", err)
-        } else {
-            log.Println("No new jobs. Skipping...")
-        }
-        return
-    }
+	var jobCancelled bool
 
-    log.Println("Starting transfer from", newJob.SrcUrl, "to", newJob.DstUrl)
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	monitorDone := make(chan struct{})
+	go func() {
+		defer close(monitorDone)
+		if !monitorJob(monitorCtx, store, newJob.Id, s, &done, &total) {
+			jobCancelled = true
+			cancelTransfer()
+		}
+	}()
 
-    update := map[string]string{"status": complete}
+	var files *jobFiles
+	var result *jobResult
+	if netio.IsDirectoryTransfer(newJob.SrcUrl, opt) {
+		var r netio.DirectoryResult
+		r, err = netio.TransferDirectory(trCtx, newJob.SrcUrl, newJob.DstUrl, opt)
+		summary := newJobFiles(r)
+		files = &summary
+	} else {
+		var r netio.Result
+		r, err = netio.Transfer(trCtx, newJob.SrcUrl, newJob.DstUrl, opt)
+		summary := newJobResult(r)
+		result = &summary
+	}
 
-    // bucket can be 'path-style' or 'virtual-hosted–style'
-    // TODO: read options from the db
-    opt := map[string]string{"bucketNameStyle": "path-style"}
+	stopMonitor()
+	<-monitorDone
+
+	jobSpan.SetAttributes("cancelled", jobCancelled)
+	jobSpan.SetError(err)
+
+	// ctx might be cancelled by now so
+	// setup db op timeout from scratch
+	timeout, cancel = context.WithTimeout(context.Background(), s.DbOpTimeout)
+	defer cancel()
+
+	_, statusSpan := tracing.Start(ctx, "status update")
+	defer statusSpan.End()
+
+	if files != nil && !jobCancelled {
+		if err := store.Files(timeout, newJob.Id, *files); err != nil {
+			logger.Error("can't update job files", "err", err)
+		}
+	}
+	if result != nil && err == nil && !jobCancelled {
+		if err := store.Result(timeout, newJob.Id, *result); err != nil {
+			logger.Error("can't update job result", "err", err)
+		}
+	}
+
+	// sent once the store has the new status
+	notification := jobNotification{Job: *newJob, Result: result, Files: files}
+
+	switch {
+	case jobCancelled:
+		logger.Info("job has been cancelled")
+		notify.notify(ctx, jobNotification{Event: cancelled, Job: *newJob})
+		return
+	case err == nil:
+		err := store.Complete(timeout, newJob.Id)
+		if errors.Is(err, errInvalidJobState) {
+			// cancelled after the last heartbeat
+			logger.Info("job is not running anymore")
+			break
+		}
+		if err != nil {
+			jobsFailed.With(srcScheme, dstScheme).Inc()
+			transErr = fmt.Errorf("error updating job status: %v", err)
+			break
+		}
+		jobsCompleted.With(srcScheme, dstScheme).Inc()
+		notification.Event = complete
+		notify.notify(ctx, notification)
+	case ctx.Err() != nil:
+		// the agent is shutting down: put the job back to the queue for
+		// another agent to start it over; the transfer isn't resumed
+		logger.Info("releasing job")
+		if err := store.Release(timeout, newJob.Id); err != nil {
+			transErr = fmt.Errorf("error releasing job: %v", err)
+		}
+	case netio.IsTemporary(err) && newJob.Attempts+1 < s.MaxAttempts:
+		jobsFailed.With(srcScheme, dstScheme).Inc()
+		transErr = fmt.Errorf("can't perform transfer: %v", err)
+		notBefore := time.Now().UTC().Add(retryBackoff(s.RetryBackoff, newJob.Attempts))
+		logger.Warn("job will be retried", "notBefore", notBefore, "err", err)
+		if err := store.Reschedule(timeout, newJob.Id, netio.ErrorCode(err), transErr.Error(), notBefore); err != nil {
+			logger.Error("can't reschedule job", "err", err)
+		}
+	default:
+		jobsFailed.With(srcScheme, dstScheme).Inc()
+		transErr = fmt.Errorf("can't perform transfer: %v", err)
+		if err := store.Fail(timeout, newJob.Id, netio.ErrorCode(err), transErr.Error()); err != nil {
+			logger.Error("can't update job status", "err", err)
+			break
+		}
+		notification.Event = failed
+		notification.Error = transErr.Error()
+		notification.ErrorCode = netio.ErrorCode(err)
+		notify.notify(ctx, notification)
+	}
+
+	switch {
+	case transErr != nil:
+		logger.Error("transfer interrupted", "err", transErr)
+	case err != nil: // released
+	case result != nil:
+		logger.Info("finished transfer", "bytes", result.Bytes, "duration", result.Duration,
+			"strategy", result.Strategy, "upToDate", result.UpToDate)
+	default:
+		logger.Info("finished transfer", "files", files.Copied, "bytes", files.Bytes)
+	}
+}
+
+// retryBackoff returns the delay before the next attempt
+// doubling base for every failed one
+func retryBackoff(base time.Duration, attempts int) time.Duration {
+	const maxBackoff = 24 * time.Hour
+	backoff := base
+	for i := 0; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
 
-    if err := netio.Transfer(ctx, newJob.SrcUrl, newJob.DstUrl, opt); err != nil {
-        transErr = fmt.Errorf("can't perform transfer: %v", err)
-        update["status"] = failed
-        update["error"]  = transErr.Error()
-    }
+// monitorJob periodically updates the job heartbeat and its progress until
+// ctx is done. It returns false as soon as the job gets cancelled.
+func monitorJob(ctx context.Context, store JobStore, id primitive.ObjectID, s settings,
+	done *int64, total *int64) bool {
 
-    // setup db op timeout again
-    timeout, cancel = context.WithTimeout(ctx, t)
-    defer cancel()
+	ticker := time.NewTicker(s.HeartbeatInterval)
+	defer ticker.Stop() // to prevent ticker goroutine leak
 
-    result = collection.FindOneAndUpdate(timeout,
-        map[string]primitive.ObjectID{"_id": newJob.Id},
-        map[string]map[string]string{"$set": update})
+	logger := logging.FromContext(ctx)
 
-    if err := result.Err(); err != nil {
-        transErr = fmt.Errorf("error updating job status: %v", err)
-    }
+	var reported int64
 
-    if transErr != nil {
-        log.Println("Transfer interrupted")
-    } else {
-        log.Println("Finished transfer")
-    }
+	for {
+		select {
+		case <-ticker.C:
+			timeout, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+			running, err := store.Heartbeat(timeout, id)
+			if err != nil {
+				logger.Error("can't update job heartbeat", "err", err)
+			} else if !running {
+				cancel()
+				return false
+			}
+			if cur := atomic.LoadInt64(done); cur != reported {
+				if err := store.Progress(timeout, id, cur, atomic.LoadInt64(total)); err != nil {
+					logger.Error("can't update job progress", "err", err)
+				} else {
+					reported = cur
+					logger.Info("progress", "bytes", cur, "total", atomic.LoadInt64(total))
+				}
+			}
+			cancel()
+		case <-ctx.Done():
+			return true
+		}
+	}
 }
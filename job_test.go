@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/viktorburka/octopus/logging"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJobSettings returns the settings the test jobs run with
+func testJobSettings() settings {
+	return settings{DbOpTimeout: time.Second, HeartbeatInterval: time.Second, Queues: []string{defaultQueue}}
+}
+
+// newTestDir creates a temporary directory with the given files
+func newTestDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// newTestCopyJob returns a job copying src.txt to dst.txt of the directory
+func newTestCopyJob(dir string) job {
+	j := newTestJob()
+	j.SrcUrl = "file://" + filepath.Join(dir, "src.txt")
+	j.DstUrl = "file://" + filepath.Join(dir, "dst.txt")
+	return j
+}
+
+// runTestJob claims and runs one job from the store
+func runTestJob(t *testing.T, ctx context.Context, store JobStore, s settings, notify *notifier) jobStatus {
+
+	claims := make(chan bool, 1)
+	proc := make(chan jobStatus, 1)
+
+	go startJob(ctx, store, s, notify, nil, claims, proc)
+
+	if !<-claims {
+		t.Fatalf("expected a job to be claimed")
+	}
+
+	select {
+	case status := <-proc:
+		return status
+	case <-time.After(10 * time.Second):
+		t.Fatalf("job didn't finish in time")
+	}
+	return jobStatus{}
+}
+
+func TestDirectoryJob(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	src, cleanupSrc := newTestDir(t, map[string]string{"a.txt": "a.txt", "b.txt": "b.txt"})
+	defer cleanupSrc()
+
+	dst := filepath.Join(src, "copy")
+	ctx := context.Background()
+
+	j := newTestJob()
+	j.SrcUrl = "file://" + src + "/"
+	j.DstUrl = "file://" + dst + "/"
+	j.Options = map[string]string{"parallelFiles": "1"}
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := runTestJob(t, ctx, store, testJobSettings(), nil); status.jobError != nil {
+		t.Fatal(status.jobError)
+	}
+
+	done, err := store.Get(ctx, j.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Status != complete {
+		t.Fatalf("expected job to be %v but got %v (%v)", complete, done.Status, done.Error)
+	}
+	if done.Files == nil || done.Files.Total != 2 || done.Files.Copied != 2 || done.Files.Bytes != 10 {
+		t.Fatalf("expected 2 files copied but got %+v", done.Files)
+	}
+}
+
+func TestFileJobResult(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	dir, cleanupDir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer cleanupDir()
+
+	ctx := context.Background()
+	j := newTestCopyJob(dir)
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := runTestJob(t, ctx, store, testJobSettings(), nil); status.jobError != nil {
+		t.Fatal(status.jobError)
+	}
+
+	done, err := store.Get(ctx, j.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Result == nil || done.Result.Bytes != 7 || done.Result.Parts != 1 {
+		t.Fatalf("expected 7 bytes copied but got %+v", done.Result)
+	}
+	if md5 := done.Result.Checksums["md5"]; md5 != "9a0364b9e99bb480dd25e1f0284c8555" {
+		t.Fatalf("expected md5 checksum of the content but got %q", md5)
+	}
+}
+
+func TestCancelledJobNotCompleted(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	dir, cleanupDir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer cleanupDir()
+
+	srv, requests := newTestWebhook(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+	j := newTestCopyJob(dir)
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	completed := jobsCompleted.With("file", "file").Value()
+	notify := newTestNotifier(srv.URL)
+	flaky := &flakyStore{JobStore: store, cancelOnComplete: true}
+	if status := runTestJob(t, ctx, flaky, testJobSettings(), notify); status.jobError != nil {
+		t.Fatal(status.jobError)
+	}
+	if err := notify.wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Complete(ctx, j.Id); err != errInvalidJobState {
+		t.Fatalf("expected the cancelled job not to be completed but got %v", err)
+	}
+	if cancelledJob, err := store.Get(ctx, j.Id); err != nil || cancelledJob.Status != cancelled {
+		t.Fatalf("expected the job to stay cancelled but got %+v (%v)", cancelledJob, err)
+	}
+	if v := jobsCompleted.With("file", "file").Value(); v != completed {
+		t.Fatalf("expected %v completed jobs but got %v", completed, v)
+	}
+	for _, req := range requests() {
+		if req.notification.Event == complete {
+			t.Fatalf("expected no complete notification but got %+v", req.notification)
+		}
+	}
+}
+
+func TestTemporaryFailureRetried(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.mp4" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	dir, cleanupDir := newTestDir(t, nil)
+	defer cleanupDir()
+
+	ctx := context.Background()
+	s := testJobSettings()
+	s.MaxAttempts = 2
+	s.RetryBackoff = time.Minute
+
+	j := newTestJob()
+	j.SrcUrl = srv.URL + "/video.mp4"
+	j.DstUrl = "file://" + filepath.Join(dir, "video.mp4")
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := runTestJob(t, ctx, store, s, nil); status.jobError == nil {
+		t.Fatalf("expected the job to fail")
+	}
+
+	retried, err := store.Get(ctx, j.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != created || retried.Attempts != 1 || retried.ErrorCode != "Unavailable" {
+		t.Fatalf("expected the job to be rescheduled but got %+v", retried)
+	}
+	if retried.NotBefore == nil || time.Until(*retried.NotBefore) < 30*time.Second {
+		t.Fatalf("expected the job to be held back but got not before %v", retried.NotBefore)
+	}
+
+	// the last attempt fails the job
+	if err := store.updateJob(ctx, j.Id, func(j *job) { j.NotBefore = nil }); err != nil {
+		t.Fatal(err)
+	}
+	runTestJob(t, ctx, store, s, nil)
+
+	if done, _ := store.Get(ctx, j.Id); done.Status != failed || done.Attempts != 1 {
+		t.Fatalf("expected the job to fail after 2 attempts but got %+v", done)
+	}
+
+	// permanent errors are not retried
+	missing := newTestJob()
+	missing.SrcUrl = srv.URL + "/missing.mp4"
+	missing.DstUrl = j.DstUrl
+	if err := store.Insert(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	runTestJob(t, ctx, store, s, nil)
+
+	if done, _ := store.Get(ctx, missing.Id); done.Status != failed || done.ErrorCode != "NotFound" {
+		t.Fatalf("expected the job to fail right away but got %+v", done)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	if d := retryBackoff(time.Second, 0); d != time.Second {
+		t.Fatalf("expected first retry in 1s but got %v", d)
+	}
+	if d := retryBackoff(time.Second, 3); d != 8*time.Second {
+		t.Fatalf("expected fourth retry in 8s but got %v", d)
+	}
+	if d := retryBackoff(time.Hour, 10); d != 24*time.Hour {
+		t.Fatalf("expected backoff to be limited to 24h but got %v", d)
+	}
+}
+
+func TestJobLogRecords(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	dir, cleanupDir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer cleanupDir()
+
+	j := newTestCopyJob(dir)
+	if err := store.Insert(context.Background(), j); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, logging.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := logging.NewContext(context.Background(), logger)
+	if status := runTestJob(t, ctx, store, testJobSettings(), nil); status.jobError != nil {
+		t.Fatal(status.jobError)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected the transfer start and finish records but got\n%v", buf.String())
+	}
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected a JSON record but got %q: %v", line, err)
+		}
+		if record["job"] != j.Id.Hex() || record["src"] != j.SrcUrl || record["attempt"] != float64(1) {
+			t.Fatalf("expected the record to carry the job fields but got %v", line)
+		}
+	}
+}
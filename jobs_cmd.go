@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const jobsUsage = `usage: octopus jobs <command> [flags] [args]
+
+Manages the jobs in the job store configured with OCTOPUS_* variables.
+
+commands:
+  list     [-status Failed,Cancelled] [-limit 100] [-json]
+  show     [-json] <id>
+  submit   [-json] [-description ..] [-queue ..] [-requires a,b] [-depends-on id,id]
+           [-not-before 2019-04-10T02:00:00Z] [-option key=value]... <src> <dst>
+  cancel   <id>...
+  retry    <id>...
+  purge    [-status Complete,Failed,Cancelled] [-older-than 720h]
+`
+
+// usageError is returned for invalid command line
+type usageError struct {
+	error
+}
+
+type jobsCommand func(ctx context.Context, store JobStore, s settings, args []string, out io.Writer) error
+
+var jobsCommands = map[string]jobsCommand{
+	"list":   jobsList,
+	"show":   jobsShow,
+	"submit": jobsSubmit,
+	"cancel": jobsCancel,
+	"retry":  jobsRetry,
+	"purge":  jobsPurge,
+}
+
+// runJobs runs octopus jobs command and returns the process exit code
+func runJobs(ctx context.Context, s settings, args []string, out io.Writer, errOut io.Writer) int {
+
+	if len(args) == 0 || jobsCommands[args[0]] == nil {
+		fmt.Fprint(errOut, jobsUsage)
+		return exitUsage
+	}
+
+	store, err := newJobStore(ctx, s)
+	if err != nil {
+		fmt.Fprintln(errOut, "octopus jobs:", err)
+		return exitFailure
+	}
+	defer closeJobStore(store, s)
+
+	err = jobsCommands[args[0]](ctx, store, s, args[1:], out)
+	if err == flag.ErrHelp {
+		fmt.Fprint(errOut, jobsUsage)
+		return exitOk
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "octopus jobs %v: %v\n", args[0], err)
+		if _, ok := err.(usageError); ok {
+			return exitUsage
+		}
+		return exitFailure
+	}
+
+	return exitOk
+}
+
+func newJobsFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard) // errors are reported by runJobs
+	return fs
+}
+
+func parseJobsFlags(fs *flag.FlagSet, args []string, minArgs int, maxArgs int) ([]string, error) {
+	positional, err := parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil, err
+	}
+	if err != nil {
+		return nil, usageError{err}
+	}
+	if len(positional) < minArgs || maxArgs >= 0 && len(positional) > maxArgs {
+		return nil, usageError{fmt.Errorf("unexpected number of arguments: %v", len(positional))}
+	}
+	return positional, nil
+}
+
+func jobsList(ctx context.Context, store JobStore, s settings, args []string, out io.Writer) error {
+
+	fs := newJobsFlagSet("list")
+	status := fs.String("status", "", "comma separated statuses")
+	limit := fs.Int64("limit", defaultListLimit, "maximum number of jobs")
+	asJSON := fs.Bool("json", false, "JSON output")
+
+	if _, err := parseJobsFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	filter := jobFilter{Status: splitList(*status), Limit: *limit}
+
+	opCtx, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	jobs, err := store.List(opCtx, filter)
+	if err != nil {
+		return err
+	}
+
+	views := make([]job, 0, len(jobs)) // printed as [] rather than null
+	for _, j := range jobs {
+		views = append(views, j.redacted())
+	}
+
+	if *asJSON {
+		return printJSON(out, views)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tQUEUE\tPROGRESS\tSOURCE\tDESTINATION")
+	for _, j := range views {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			j.Id.Hex(), j.Status, jobQueue(j), formatProgress(j.Progress), j.SrcUrl, j.DstUrl)
+	}
+	return w.Flush()
+}
+
+func jobsShow(ctx context.Context, store JobStore, s settings, args []string, out io.Writer) error {
+
+	fs := newJobsFlagSet("show")
+	asJSON := fs.Bool("json", false, "JSON output")
+
+	positional, err := parseJobsFlags(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	id, err := primitive.ObjectIDFromHex(positional[0])
+	if err != nil {
+		return usageError{fmt.Errorf("invalid job id %q", positional[0])}
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	j, err := store.Get(opCtx, id)
+	if err != nil {
+		return err
+	}
+	view := j.redacted()
+
+	if *asJSON {
+		return printJSON(out, view)
+	}
+
+	return printJob(out, &view)
+}
+
+func jobsSubmit(ctx context.Context, store JobStore, s settings, args []string, out io.Writer) error {
+
+	var req jobRequest
+	options := optionsFlag{}
+
+	fs := newJobsFlagSet("submit")
+	fs.StringVar(&req.Description, "description", "", "job description")
+	fs.StringVar(&req.Queue, "queue", "", "job queue")
+	requires := fs.String("requires", "", "comma separated required capabilities")
+	dependsOn := fs.String("depends-on", "", "comma separated ids of the jobs to wait for")
+	notBefore := fs.String("not-before", "", "don't start the job before RFC 3339 date")
+	fs.Var(options, "option", "transfer option key=value (repeatable)")
+	asJSON := fs.Bool("json", false, "JSON output")
+
+	positional, err := parseJobsFlags(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	req.SrcUrl = positional[0]
+	req.DstUrl = positional[1]
+	req.Requires = splitList(*requires)
+	if len(options) > 0 {
+		req.Options = options
+	}
+
+	for _, dep := range splitList(*dependsOn) {
+		id, err := primitive.ObjectIDFromHex(dep)
+		if err != nil {
+			return usageError{fmt.Errorf("invalid job id %q", dep)}
+		}
+		req.DependsOn = append(req.DependsOn, id)
+	}
+
+	if *notBefore != "" {
+		t, err := time.Parse(time.RFC3339, *notBefore)
+		if err != nil {
+			return usageError{fmt.Errorf("invalid -not-before: %v", err)}
+		}
+		t = t.UTC()
+		req.NotBefore = &t
+	}
+
+	newJob, err := req.newJob()
+	if err != nil {
+		return usageError{err}
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	if err := store.Insert(opCtx, newJob); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(out, newJob.redacted())
+	}
+
+	fmt.Fprintln(out, newJob.Id.Hex())
+	return nil
+}
+
+func jobsCancel(ctx context.Context, store JobStore, s settings, args []string, out io.Writer) error {
+	return transitionJobs(ctx, store, s, "cancel", args, out, store.Cancel)
+}
+
+func jobsRetry(ctx context.Context, store JobStore, s settings, args []string, out io.Writer) error {
+	return transitionJobs(ctx, store, s, "retry", args, out, store.Retry)
+}
+
+// transitionJobs applies fn to every job given and prints their new status
+func transitionJobs(ctx context.Context, store JobStore, s settings, name string, args []string, out io.Writer,
+	fn func(ctx context.Context, id primitive.ObjectID) error) error {
+
+	positional, err := parseJobsFlags(newJobsFlagSet(name), args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	var ids []primitive.ObjectID
+	for _, arg := range positional {
+		id, err := primitive.ObjectIDFromHex(arg)
+		if err != nil {
+			return usageError{fmt.Errorf("invalid job id %q", arg)}
+		}
+		ids = append(ids, id)
+	}
+
+	failures := 0
+	for _, id := range ids {
+		opCtx, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+		err := fn(opCtx, id)
+		var j *job
+		if err == nil {
+			j, err = store.Get(opCtx, id)
+		}
+		cancel()
+		if err != nil {
+			fmt.Fprintf(out, "%v\terror: %v\n", id.Hex(), err)
+			failures++
+			continue
+		}
+		fmt.Fprintf(out, "%v\t%v\n", id.Hex(), j.Status)
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%v of %v jobs failed", failures, len(ids))
+	}
+	return nil
+}
+
+func jobsPurge(ctx context.Context, store JobStore, s settings, args []string, out io.Writer) error {
+
+	fs := newJobsFlagSet("purge")
+	status := fs.String("status", strings.Join(finishedStatuses, ","), "comma separated statuses")
+	olderThan := fs.Duration("older-than", 0, "only purge the jobs created before that long ago")
+
+	if _, err := parseJobsFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	filter := purgeFilter{Status: splitList(*status)}
+	if err := filter.validate(); err != nil {
+		return usageError{err}
+	}
+	if *olderThan > 0 {
+		filter.Before = time.Now().Add(-*olderThan)
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	purged, err := store.Purge(opCtx, filter)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "purged %v jobs\n", purged)
+	return nil
+}
+
+func printJob(out io.Writer, j *job) error {
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(w, "%v:\t%v\n", name, value)
+	}
+
+	field("ID", j.Id.Hex())
+	field("Status", j.Status)
+	field("Submitted", jobCreatedAt(j.Id).Format(time.RFC3339))
+	field("Source", j.SrcUrl)
+	field("Destination", j.DstUrl)
+	if j.Description != "" {
+		field("Description", j.Description)
+	}
+	field("Queue", jobQueue(*j))
+	if len(j.Requires) > 0 {
+		field("Requires", strings.Join(j.Requires, ", "))
+	}
+	if len(j.DependsOn) > 0 {
+		var deps []string
+		for _, id := range j.DependsOn {
+			deps = append(deps, id.Hex())
+		}
+		field("Depends on", strings.Join(deps, ", "))
+	}
+	if j.NotBefore != nil {
+		field("Not before", j.NotBefore.Format(time.RFC3339))
+	}
+	if j.Attempts > 0 {
+		field("Attempts", fmt.Sprint(j.Attempts))
+	}
+	if j.ScheduleId != nil {
+		field("Schedule", j.ScheduleId.Hex())
+	}
+	if j.Heartbeat != nil {
+		field("Heartbeat", j.Heartbeat.Format(time.RFC3339))
+	}
+	if j.Progress != nil {
+		field("Progress", formatProgress(j.Progress))
+	}
+	if j.Files != nil {
+		files := fmt.Sprintf("%v of %v copied, %v failed (%v)",
+			j.Files.Copied, j.Files.Total, j.Files.Failed, formatBytes(j.Files.Bytes))
+		if j.Files.Skipped > 0 || j.Files.Deleted > 0 {
+			files += fmt.Sprintf(", %v up to date, %v deleted", j.Files.Skipped, j.Files.Deleted)
+		}
+		field("Files", files)
+		label := "File errors:"
+		for _, e := range j.Files.Errors {
+			fmt.Fprintf(w, "%v\t%v: %v\n", label, e.Path, e.Error)
+			label = ""
+		}
+	}
+	if j.Result != nil {
+		field("Result", j.Result.summary())
+		if j.Result.ETag != "" {
+			field("ETag", j.Result.ETag)
+		}
+		if j.Result.VersionId != "" {
+			field("Version", j.Result.VersionId)
+		}
+		var names []string
+		for name := range j.Result.Checksums {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field("Checksum "+name, j.Result.Checksums[name])
+		}
+	}
+	if j.Error != "" {
+		field("Error", j.Error)
+	}
+	if j.ErrorCode != "" {
+		field("Error code", j.ErrorCode)
+	}
+	if len(j.Options) > 0 {
+		var keys []string
+		for key := range j.Options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		label := "Options:"
+		for _, key := range keys {
+			fmt.Fprintf(w, "%v\t%v=%v\n", label, key, j.Options[key])
+			label = ""
+		}
+	}
+
+	return w.Flush()
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func jobQueue(j job) string {
+	if j.Queue == "" {
+		return defaultQueue
+	}
+	return j.Queue
+}
+
+func formatProgress(p *jobProgress) string {
+	if p == nil {
+		return "-"
+	}
+	if p.Total <= 0 {
+		return formatBytes(p.Bytes)
+	}
+	return fmt.Sprintf("%v / %v (%d%%)", formatBytes(p.Bytes), formatBytes(p.Total), p.Bytes*100/p.Total)
+}
+
+// splitList splits comma separated list skipping empty items
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// optionsFlag collects repeated -option key=value flags
+type optionsFlag map[string]string
+
+func (o optionsFlag) String() string {
+	var pairs []string
+	for key, val := range o {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (o optionsFlag) Set(value string) error {
+	idx := strings.Index(value, "=")
+	if idx <= 0 {
+		return fmt.Errorf("expected key=value but got %q", value)
+	}
+	o[value[:idx]] = value[idx+1:]
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobsCommands(t *testing.T) {
+
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := settings{Store: "file", StorePath: filepath.Join(dir, "octopus.json"), DbOpTimeout: time.Second}
+	ctx := context.Background()
+
+	run := func(expectedCode int, args ...string) string {
+		var out, errOut bytes.Buffer
+		if code := runJobs(ctx, s, args, &out, &errOut); code != expectedCode {
+			t.Fatalf("expected %q to exit with %v but got %v: %v", args, expectedCode, code, errOut.String())
+		}
+		return strings.TrimSpace(out.String())
+	}
+
+	id := run(exitOk, "submit", "-queue", "edge", "-option", "checksum=md5", "-option", "awsSessionToken=FQoG",
+		"http://example.com/file.mp4", "file:///tmp/file.mp4")
+
+	if out := run(exitOk, "show", id); strings.Contains(out, "FQoG") || !strings.Contains(out, "awsSessionToken=REDACTED") {
+		t.Fatalf("expected the session token redacted but got:\n%v", out)
+	}
+
+	var j job
+	if err := json.Unmarshal([]byte(run(exitOk, "show", "-json", id)), &j); err != nil {
+		t.Fatal(err)
+	}
+	if j.Id.Hex() != id || j.Status != created || j.Queue != "edge" || j.Options["checksum"] != "md5" {
+		t.Fatalf("unexpected job submitted: %+v", j)
+	}
+
+	list := run(exitOk, "list", "-status", created)
+	if !strings.Contains(list, id) || !strings.HasPrefix(list, "ID") {
+		t.Fatalf("expected job %v in the list but got:\n%v", id, list)
+	}
+
+	if out := run(exitOk, "cancel", id); !strings.Contains(out, cancelled) {
+		t.Fatalf("expected job to be cancelled but got %v", out)
+	}
+	run(exitFailure, "cancel", id)
+
+	if out := run(exitOk, "purge", "-status", cancelled); out != "purged 1 jobs" {
+		t.Fatalf("expected one job purged but got %v", out)
+	}
+	run(exitFailure, "show", id)
+
+	run(exitUsage, "submit", "ftp://example.com/file.mp4", "file:///tmp/file.mp4")
+	run(exitUsage, "show", "not-an-id")
+	run(exitUsage, "purge", "-status", running)
+	run(exitUsage, "unknown")
+}
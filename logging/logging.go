@@ -0,0 +1,261 @@
+// Package logging implements a leveled logger writing one structured
+// record (logfmt or JSON) per line. The logger travels in the context
+// so the records of a job carry its fields (job id, urls, part number).
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of the name (debug, info, warn or error)
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Record formats
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// output is shared by a logger and all the loggers derived from it
+type output struct {
+	m      sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+	now    func() time.Time
+}
+
+// Logger writes the records of the level and above adding its fields to every one
+type Logger struct {
+	out    *output
+	fields []interface{} // key value pairs
+}
+
+// New creates a logger writing records in the format (logfmt or json)
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	if format != FormatLogfmt && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return &Logger{out: &output{w: w, format: format, level: level, now: time.Now}}, nil
+}
+
+// Discard is a logger that doesn't write anything
+var Discard = &Logger{out: &output{w: ioutil.Discard, format: FormatLogfmt, level: LevelError + 1, now: time.Now}}
+
+var (
+	defaultMutex  sync.Mutex
+	defaultLogger = &Logger{out: &output{w: os.Stderr, format: FormatLogfmt, level: LevelInfo, now: time.Now}}
+)
+
+// Default returns the logger used when the context doesn't have one
+func Default() *Logger {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the logger used when the context doesn't have one
+func SetDefault(l *Logger) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultLogger = l
+}
+
+type loggerKey struct{}
+
+// NewContext returns ctx carrying the logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger of ctx or the default one
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// With returns a logger adding the key value pairs to every record
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled tells if the records of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+
+	if !l.Enabled(level) {
+		return
+	}
+
+	pairs := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	pairs = append(pairs, "time", l.out.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	pairs = append(pairs, l.fields...)
+	pairs = append(pairs, kv...)
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs[:len(pairs)-1], "!BADKEY", pairs[len(pairs)-1])
+	}
+
+	var buf bytes.Buffer
+	if l.out.format == FormatJSON {
+		writeJSON(&buf, pairs)
+	} else {
+		writeLogfmt(&buf, pairs)
+	}
+	buf.WriteByte('\n')
+
+	l.out.m.Lock()
+	defer l.out.m.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+func writeLogfmt(buf *bytes.Buffer, pairs []interface{}) {
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtKey(fmt.Sprint(pairs[i])))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(valueString(pairs[i+1])))
+	}
+}
+
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes the value if it has spaces, quotes, '=' or control characters
+func logfmtValue(val string) string {
+	if val == "" {
+		return `""`
+	}
+	for _, r := range val {
+		if r <= ' ' || r == '=' || r == '"' || unicode.IsControl(r) {
+			return strconv.Quote(val)
+		}
+	}
+	return val
+}
+
+func writeJSON(buf *bytes.Buffer, pairs []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(pairs[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(jsonValue(pairs[i+1]))
+	}
+	buf.WriteByte('}')
+}
+
+func jsonValue(val interface{}) []byte {
+	switch v := val.(type) {
+	case nil:
+		return []byte("null")
+	case error, fmt.Stringer, time.Time, time.Duration:
+		b, _ := json.Marshal(valueString(v))
+		return b
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(val))
+	}
+	return b
+}
+
+func valueString(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Limiter lets a record through at most once per interval;
+// it keeps the progress lines of long transfers in check
+type Limiter struct {
+	interval time.Duration
+	m        sync.Mutex
+	last     time.Time
+}
+
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{interval: interval}
+}
+
+// Allow tells if the interval has passed since the last allowed call
+func (l *Limiter) Allow() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() && now.Sub(l.last) < l.interval {
+		return false
+	}
+	l.last = now
+	return true
+}
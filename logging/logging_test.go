@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, format string, level Level) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	l, err := New(&buf, format, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.out.now = func() time.Time { return time.Date(2019, 4, 10, 12, 0, 0, 0, time.UTC) }
+	return l, &buf
+}
+
+func TestLogfmt(t *testing.T) {
+
+	l, buf := newTestLogger(t, FormatLogfmt, LevelInfo)
+
+	job := l.With("job", "5cad", "src", "https://example.com/a b.mp4")
+	job.Debug("not written")
+	job.With("part", 3).Info("part uploaded", "bytes", 1024, "err", errors.New(`bad "digest"`))
+
+	expected := `time=2019-04-10T12:00:00Z level=info msg="part uploaded" job=5cad src="https://example.com/a b.mp4" ` +
+		`part=3 bytes=1024 err="bad \"digest\""` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("expected\n%v\nbut got\n%v", expected, buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+
+	l, buf := newTestLogger(t, FormatJSON, LevelDebug)
+
+	l.With("job", "5cad").Debug("read", "bytes", 1024, "elapsed", time.Second, "done", true, "dangling")
+
+	expected := `{"time":"2019-04-10T12:00:00Z","level":"debug","msg":"read","job":"5cad",` +
+		`"bytes":1024,"elapsed":"1s","done":true,"!BADKEY":"dangling"}` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("expected\n%v\nbut got\n%v", expected, buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+
+	if level, err := ParseLevel("WARN"); err != nil || level != LevelWarn {
+		t.Fatalf("expected warn level but got %v (%v)", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatalf("expected unknown level to fail")
+	}
+	if _, err := New(&bytes.Buffer{}, "xml", LevelInfo); err == nil {
+		t.Fatalf("expected unknown format to fail")
+	}
+}
+
+func TestContext(t *testing.T) {
+
+	if FromContext(context.Background()) != Default() {
+		t.Fatalf("expected the default logger without one in the context")
+	}
+
+	l, _ := newTestLogger(t, FormatLogfmt, LevelInfo)
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Fatalf("expected the context logger")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+
+	l := NewLimiter(time.Hour)
+	if !l.Allow() {
+		t.Fatalf("expected the first call to be allowed")
+	}
+	if l.Allow() {
+		t.Fatalf("expected the second call within the interval to be denied")
+	}
+
+	l = NewLimiter(0)
+	if !l.Allow() || !l.Allow() {
+		t.Fatalf("expected every call to be allowed without interval")
+	}
+}
@@ -2,32 +2,137 @@ package main
 
 import (
 	"context"
-	"github.com/kelseyhightower/envconfig"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"github.com/viktorburka/octopus/netio"
+	"github.com/viktorburka/octopus/tracing"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
+// settings are read from OCTOPUS_* environment variables and the
+// optional configuration file (see loadSettings). The fields tagged
+// secret are redacted by octopus config print. The agent reloads
+// them on SIGHUP applying the tunableSettings changes.
 type settings struct {
+	// YAML configuration file; the environment variables override it
+	Config string
+
+	// mongo: MongoDB server
+	// file: local JSON file at StorePath
+	Store     string `default:"mongo"`
+	StorePath string `default:"octopus.json"`
+
 	Database       string        `default:"octopus"`
 	Collection     string        `default:"jobs"`
-	DbConnection   string        `default:"mongodb://localhost:27017"`
+	DbConnection   string        `default:"mongodb://localhost:27017" secret:"url"`
 	EventLoopSleep time.Duration `default:"1s"`
 	DbOpTimeout    time.Duration `default:"5s"`
+	MaxJobs        int           `default:"3"`
+	WatchChanges   bool          `default:"false"`
+
+	// bytes per second all the jobs of the agent read the sources at
+	// with optional K, M or G suffix (e.g. 50M); unlimited if empty
+	BandwidthLimit string
+
+	// job heartbeat and progress update interval; the running jobs
+	// without a heartbeat for HeartbeatTimeout (their agent is dead)
+	// are put back to the queue
+	HeartbeatInterval time.Duration `default:"10s"`
+	HeartbeatTimeout  time.Duration `default:"1m"`
+
+	// jobs failed with temporary errors (netio.ErrUnavailable) are run
+	// up to MaxAttempts times waiting RetryBackoff doubled every attempt
+	MaxAttempts  int           `default:"3"`
+	RetryBackoff time.Duration `default:"30s"`
+
+	// HTTP API listen address; the agent doesn't serve
+	// HTTP if it is empty (octopus serve uses 127.0.0.1:8080)
+	HttpAddress string
+	// bearer token the job API requests must carry; without
+	// it the job API answers only the clients on the same host
+	ApiToken string `secret:"true"`
+	// directories the file urls of the jobs submitted with
+	// the API must be under; no file urls if it is empty
+	FileRoots []string
+	// loopback address /debug/pprof is served on apart from
+	// the API (e.g. 127.0.0.1:6060); disabled if it is empty
+	PprofAddress string
+
+	// queues to pick up jobs from and capabilities
+	// provided by this agent (e.g. zone:dmz,region:us-east-1)
+	Queues       []string `default:"default"`
+	Capabilities []string
+
+	// drain: wait for running jobs to finish
+	// release: interrupt running jobs and put them back to the queue
+	// to be started over from the beginning
+	ShutdownMode    string        `default:"drain"`
+	ShutdownTimeout time.Duration `default:"30s"`
+
+	ScheduleCollection string        `default:"schedules"`
+	ScheduleInterval   time.Duration `default:"15s"`
+
+	// the agent registers itself in AgentCollection (under AgentId or a
+	// random one) and updates the registration every AgentInterval; it
+	// is considered dead if it hasn't done that for AgentTimeout; the
+	// registration of the dead agent is removed after AgentRetention
+	AgentId         string
+	AgentCollection string        `default:"agents"`
+	AgentInterval   time.Duration `default:"15s"`
+	AgentTimeout    time.Duration `default:"1m"`
+	AgentRetention  time.Duration `default:"24h"`
+
+	// debug, info, warn or error records
+	// written to stderr as logfmt or json
+	LogLevel  string `default:"info"`
+	LogFormat string `default:"logfmt"`
+
+	// OTLP/HTTP collector the job traces are sent to
+	// (e.g. http://localhost:4318); empty disables tracing
+	TraceEndpoint    string `secret:"url"`
+	TraceServiceName string `default:"octopus"`
+
+	// the job status changes (WebhookEvents, all if empty) are POSTed to
+	// WebhookUrls signed with WebhookSecret; failed deliveries are tried up
+	// to WebhookAttempts times waiting WebhookBackoff doubled every attempt
+	WebhookUrls     []string `secret:"true"`
+	WebhookSecret   string   `secret:"true"`
+	WebhookEvents   []string
+	WebhookAttempts int           `default:"5"`
+	WebhookBackoff  time.Duration `default:"1s"`
+	WebhookTimeout  time.Duration `default:"10s"`
 }
 
 func main() {
 
-	var s settings
+	command := "agent"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	s, err := loadSettings(command)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "octopus:", err)
+		os.Exit(exitFailure)
+	}
 
-	err := envconfig.Process("octopus", &s)
+	logger, err := newLogger(s)
 	if err != nil {
 		log.Fatal(err)
 	}
+	logging.SetDefault(logger)
+
+	tracer := newTracer(s)
+	tracing.SetDefault(tracer)
+	exit := func(code int) {
+		shutdownTracer(tracer)
+		os.Exit(code)
+	}
 
 	sigtermCtx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -37,47 +142,271 @@ func main() {
 		cancel()
 	}()
 
-	ctx, releaseContext := context.WithTimeout(context.Background(), s.DbOpTimeout)
-	defer releaseContext()
+	switch command {
+	case "agent":
+		err = runAgent(sigtermCtx, s)
+	case "serve":
+		err = runServe(sigtermCtx, s)
+	case "cp":
+		exit(runCp(sigtermCtx, os.Args[2:]))
+	case "jobs":
+		exit(runJobs(sigtermCtx, s, os.Args[2:], os.Stdout, os.Stderr))
+	case "agents":
+		exit(runAgents(sigtermCtx, s, os.Args[2:], os.Stdout, os.Stderr))
+	case "config":
+		exit(runConfig(s, os.Args[2:], os.Stdout, os.Stderr))
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		exit(2)
+	}
 
-	client, err := mongo.NewClient(options.Client().ApplyURI(s.DbConnection))
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("exiting", "err", err)
+		exit(1)
 	}
+	exit(0)
+}
 
-	err = client.Connect(ctx)
+func newLogger(s settings) (*logging.Logger, error) {
+	level, err := logging.ParseLevel(s.LogLevel)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return logging.New(os.Stderr, s.LogFormat, level)
+}
 
-	eventLoop(sigtermCtx, client, s)
+// spans are exported in batches of traceBatchSize or every traceInterval
+const (
+	traceBatchSize = 512
+	traceInterval  = 5 * time.Second
+)
+
+// newTracer returns nil if tracing is disabled
+func newTracer(s settings) *tracing.Tracer {
+	if s.TraceEndpoint == "" {
+		return nil
+	}
+	exporter := &tracing.OTLPExporter{Endpoint: s.TraceEndpoint, ServiceName: s.TraceServiceName}
+	return tracing.NewTracer(exporter, traceBatchSize, traceInterval, func(err error) {
+		logging.Default().Warn("can't export spans", "err", err)
+	})
 }
 
-func eventLoop(ctx context.Context, client *mongo.Client, s settings) {
+// shutdownTracer exports the remaining spans before the process exits
+func shutdownTracer(tracer *tracing.Tracer) {
+	if tracer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceInterval)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		logging.Default().Warn("can't export remaining spans", "err", err)
+	}
+}
 
-	const MaxJobs = 3
+const usage = `usage: octopus [command]
+
+commands:
+  agent   run transfer jobs from the job store (default)
+  serve   run HTTP API only
+  cp      copy a single file (octopus cp -h for details)
+  jobs    list, show, submit, cancel, retry and purge jobs
+  agents  list the registered agents
+  config  print the effective configuration
+`
+
+// runAgent picks up and runs jobs until ctx is done
+func runAgent(ctx context.Context, s settings) error {
+
+	store, err := newJobStore(context.Background(), s)
+	if err != nil {
+		return err
+	}
+	defer closeJobStore(store, s)
+
+	go scheduleLoop(ctx, store, s)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	reloads := make(chan settings)
+	load := func() (settings, error) { return loadSettings("agent") }
+	go reloadLoop(ctx, s, hup, load, reloads)
+
+	jobsMax.Set(float64(s.MaxJobs))
+
+	health := newAgentHealth(s.MaxJobs, s.EventLoopSleep)
+	notify := newNotifier(s)
+	registry := newAgentRegistry(s)
+
+	// the registration outlives ctx to cover the jobs drained on shutdown
+	registryCtx, stopRegistry := context.WithCancel(context.Background())
+	registryDone := make(chan struct{})
+	go func() {
+		defer close(registryDone)
+		registryLoop(registryCtx, store, s, registry)
+	}()
+
+	if s.HttpAddress != "" {
+		go func() {
+			err := serveHttp(ctx, s.HttpAddress, newHttpHandler(store, s, health))
+			if err != nil && err != http.ErrServerClosed {
+				logging.FromContext(ctx).Error("http server failed", "err", err)
+			}
+		}()
+	}
+
+	servePprof(ctx, s)
+
+	eventLoop(ctx, store, s, health, notify, registry, reloads)
+
+	stopRegistry()
+	<-registryDone
+
+	// the job notifications are given the same time as the jobs
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancelWait()
+	if err := notify.wait(waitCtx); err != nil {
+		logging.FromContext(ctx).Warn("job notifications are not delivered", "err", err)
+	}
+
+	return nil
+}
+
+// runServe serves HTTP API without running any jobs until ctx is done
+func runServe(ctx context.Context, s settings) error {
+
+	store, err := newJobStore(context.Background(), s)
+	if err != nil {
+		return err
+	}
+	defer closeJobStore(store, s)
+
+	addr := s.HttpAddress
+	if addr == "" {
+		addr = defaultHttpAddress
+	}
+
+	logging.FromContext(ctx).Info("serving HTTP API", "address", addr)
+	servePprof(ctx, s)
+
+	err = serveHttp(ctx, addr, newHttpHandler(store, s, nil))
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func closeJobStore(store JobStore, s settings) {
+	ctx, releaseContext := context.WithTimeout(context.Background(), s.DbOpTimeout)
+	defer releaseContext()
+	if err := store.Close(ctx); err != nil {
+		logging.Default().Error("can't close job store", "err", err)
+	}
+}
+
+const (
+	shutdownDrain   = "drain"
+	shutdownRelease = "release"
+)
+
+// eventLoop runs up to MaxJobs jobs at a time until ctx is done. The settings
+// received from reloads replace s; the running jobs carry on with the old ones.
+func eventLoop(ctx context.Context, store JobStore, s settings, health *agentHealth,
+	notify *notifier, registry *agentRegistry, reloads <-chan settings) {
 
-	collection := client.Database(s.Database).Collection(s.Collection)
 	ticker := time.NewTicker(s.EventLoopSleep)
-	defer ticker.Stop() // to prevent ticker goroutine leak
+	defer func() { ticker.Stop() }() // to prevent ticker goroutine leak
+
+	logger := logging.FromContext(ctx)
 
-	jobs := 0
+	// new jobs notifications; the ticker keeps polling
+	// in case the store doesn't support notifications
+	changes := make(chan struct{}, 1)
+	if s.WatchChanges {
+		go func() {
+			if err := store.Watch(ctx, changes); err != nil {
+				logger.Warn("can't watch for new jobs, falling back to polling", "err", err)
+			}
+		}()
+	}
+
+	// jobs outlive ctx to be able to finish on shutdown
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	// all the jobs share the bandwidth
+	limiter := netio.NewRateLimiter(bandwidthLimit(s))
+	jobsCtx = netio.WithRateLimit(jobsCtx, limiter)
+
+	jobs := 0 // including the one being claimed
+	claiming := false
+	claims := make(chan bool)
 	proc := make(chan jobStatus)
 
+	// claim one job at a time; as long as there are jobs to
+	// run the next claim starts right after the previous one
+	// so a burst of jobs fills up all free slots immediately
+	claim := func() {
+		if !claiming && jobs < s.MaxJobs {
+			claiming = true
+			jobs++
+			go startJob(jobsCtx, store, s, notify, registry, claims, proc)
+		}
+	}
+
 	for {
+		health.tick(time.Now(), jobs)
 		select {
 		case <-ticker.C:
-			if jobs < MaxJobs {
-				jobs++
-				go startJob(ctx, collection, s.DbOpTimeout, proc)
+			claim()
+		case <-changes:
+			claim()
+		case ok := <-claims:
+			claiming = false
+			if ok {
+				claim()
+			} else {
+				jobs--
 			}
-		case status := <-proc:
+		case <-proc: // startJob logs the job errors
 			jobs--
-			if status.jobError != nil {
-				log.Println("error:", status.jobError)
+			claim()
+		case reloaded := <-reloads:
+			// fewer slots take effect as the running jobs finish
+			if reloaded.EventLoopSleep != s.EventLoopSleep {
+				ticker.Stop()
+				ticker = time.NewTicker(reloaded.EventLoopSleep)
 			}
+			limiter.SetLimit(bandwidthLimit(reloaded))
+			health.setMaxJobs(reloaded.MaxJobs)
+			health.setEventLoopSleep(reloaded.EventLoopSleep)
+			registry.setSlots(reloaded.MaxJobs)
+			jobsMax.Set(float64(reloaded.MaxJobs))
+			s = reloaded
+			claim()
 		case <-ctx.Done():
-			log.Println("Done")
+			logger.Info("shutting down", "mode", s.ShutdownMode, "jobs", jobs)
+			if s.ShutdownMode == shutdownRelease {
+				cancelJobs()
+			}
+			deadline := time.NewTimer(s.ShutdownTimeout)
+			defer deadline.Stop()
+			for jobs > 0 {
+				select {
+				case ok := <-claims:
+					claiming = false
+					if !ok {
+						jobs--
+					}
+				case <-proc:
+					jobs--
+				case <-deadline.C:
+					logger.Warn("shutdown timeout expired, releasing running jobs")
+					cancelJobs()
+				}
+			}
+			logger.Info("done")
 			return
 		}
 	}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"github.com/viktorburka/octopus/metrics"
+	"go.mongodb.org/mongo-driver/event"
+	"net/url"
+	"time"
+)
+
+var (
+	jobsClaimed = metrics.NewCounterVec("octopus_jobs_claimed_total",
+		"Jobs claimed by source and destination scheme", "src", "dst")
+	jobsCompleted = metrics.NewCounterVec("octopus_jobs_completed_total",
+		"Jobs completed by source and destination scheme", "src", "dst")
+	jobsFailed = metrics.NewCounterVec("octopus_jobs_failed_total",
+		"Jobs failed (including the ones rescheduled) by source and destination scheme", "src", "dst")
+	jobsRunning = metrics.NewGauge("octopus_jobs_running",
+		"Jobs running on the agent")
+	jobsMax = metrics.NewGauge("octopus_jobs_max",
+		"Jobs the agent runs at a time (MaxJobs)")
+	webhookDeliveries = metrics.NewCounterVec("octopus_webhook_deliveries_total",
+		"Job notifications delivered to the webhooks by outcome", "outcome")
+	mongoDuration = metrics.NewHistogramVec("octopus_mongo_command_duration_seconds",
+		"MongoDB command latency", metrics.DefaultBuckets, "command", "outcome")
+)
+
+// schemePair returns the url schemes used to label job metrics
+func schemePair(j *job) (string, string) {
+	return urlScheme(j.SrcUrl), urlScheme(j.DstUrl)
+}
+
+func urlScheme(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Scheme == "" {
+		return "unknown"
+	}
+	return u.Scheme
+}
+
+// mongoMonitor times the commands sent to MongoDB
+func mongoMonitor() *event.CommandMonitor {
+	observe := func(command string, outcome string, nanos int64) {
+		mongoDuration.With(command, outcome).Observe(time.Duration(nanos).Seconds())
+	}
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			observe(e.CommandName, "success", e.DurationNanos)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			observe(e.CommandName, "failure", e.DurationNanos)
+		},
+	}
+}
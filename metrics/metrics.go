@@ -0,0 +1,370 @@
+// Package metrics implements counters, gauges and histograms exposed
+// in the Prometheus text format (version 0.0.4) without depending on
+// the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets (seconds) suited
+// for request latencies from milliseconds to minutes
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// DefaultRegistry keeps the metrics created with the package functions
+var DefaultRegistry = NewRegistry()
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry is a set of metric families with unique names
+type Registry struct {
+	m        sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric with all its label value combinations (series)
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64 // histograms only
+
+	m      sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string // values
+
+	m      sync.Mutex
+	value  float64  // counter and gauge value; histogram sum
+	counts []uint64 // histogram observations per bucket (not cumulative)
+	count  uint64   // histogram observations
+}
+
+func (r *Registry) register(name string, help string, kind string, buckets []float64, labels []string) *family {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metric %v is already registered", name))
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series of the label values creating it on first use
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %v expects %v label values but got %v", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.m.Lock()
+	defer f.m.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *series) add(v float64) {
+	s.m.Lock()
+	s.value += v
+	s.m.Unlock()
+}
+
+func (s *series) set(v float64) {
+	s.m.Lock()
+	s.value = v
+	s.m.Unlock()
+}
+
+func (s *series) get() float64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.value
+}
+
+// Counter is a value that only goes up
+type Counter struct{ s *series }
+
+func (c Counter) Inc() { c.s.add(1) }
+
+// Add increases the counter; negative values are ignored
+func (c Counter) Add(v float64) {
+	if v > 0 {
+		c.s.add(v)
+	}
+}
+
+func (c Counter) Value() float64 { return c.s.get() }
+
+// Gauge is a value that goes up and down
+type Gauge struct{ s *series }
+
+func (g Gauge) Set(v float64)  { g.s.set(v) }
+func (g Gauge) Add(v float64)  { g.s.add(v) }
+func (g Gauge) Inc()           { g.s.add(1) }
+func (g Gauge) Dec()           { g.s.add(-1) }
+func (g Gauge) Value() float64 { return g.s.get() }
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (h Histogram) Observe(v float64) {
+	h.s.m.Lock()
+	defer h.s.m.Unlock()
+	h.s.value += v
+	h.s.count++
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.s.counts[i]++
+	}
+}
+
+// Count returns the number of observations
+func (h Histogram) Count() uint64 {
+	h.s.m.Lock()
+	defer h.s.m.Unlock()
+	return h.s.count
+}
+
+type CounterVec struct{ f *family }
+
+// With returns the counter of the label values given in the order of the labels
+func (v CounterVec) With(values ...string) Counter { return Counter{v.f.with(values)} }
+
+type GaugeVec struct{ f *family }
+
+func (v GaugeVec) With(values ...string) Gauge { return Gauge{v.f.with(values)} }
+
+type HistogramVec struct{ f *family }
+
+func (v HistogramVec) With(values ...string) Histogram {
+	return Histogram{s: v.f.with(values), buckets: v.f.buckets}
+}
+
+func (r *Registry) NewCounter(name string, help string) Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) CounterVec {
+	return CounterVec{r.register(name, help, kindCounter, nil, labels)}
+}
+
+func (r *Registry) NewGauge(name string, help string) Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) GaugeVec {
+	return GaugeVec{r.register(name, help, kindGauge, nil, labels)}
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64) Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds of
+// the buckets in increasing order; +Inf bucket is implied
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metric %v buckets are not sorted", name))
+	}
+	return HistogramVec{r.register(name, help, kindHistogram, buckets, labels)}
+}
+
+func NewCounter(name string, help string) Counter {
+	return DefaultRegistry.NewCounter(name, help)
+}
+
+func NewCounterVec(name string, help string, labels ...string) CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+func NewGauge(name string, help string) Gauge {
+	return DefaultRegistry.NewGauge(name, help)
+}
+
+func NewGaugeVec(name string, help string, labels ...string) GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+func NewHistogram(name string, help string, buckets []float64) Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets)
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// WriteTo writes all the metrics in the text format sorted by name and label values
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+
+	r.m.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.m.Unlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func (f *family) write(w *countingWriter) {
+
+	f.m.Lock()
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	f.m.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].labels, list[j].labels
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	fmt.Fprintf(w, "# HELP %v %v\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %v %v\n", f.name, f.kind)
+
+	for _, s := range list {
+		s.m.Lock()
+		if f.kind != kindHistogram {
+			fmt.Fprintf(w, "%v%v %v\n", f.name, labelPairs(f.labels, s.labels, "", 0), formatFloat(s.value))
+			s.m.Unlock()
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%v_bucket%v %v\n", f.name, labelPairs(f.labels, s.labels, "le", bound), cumulative)
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", f.name, labelPairs(f.labels, s.labels, "le", math.Inf(1)), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", f.name, labelPairs(f.labels, s.labels, "", 0), formatFloat(s.value))
+		fmt.Fprintf(w, "%v_count%v %v\n", f.name, labelPairs(f.labels, s.labels, "", 0), s.count)
+		s.m.Unlock()
+	}
+}
+
+// labelPairs formats {name="value",...} adding the extra label unless it is empty
+func labelPairs(names []string, values []string, extra string, extraValue float64) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%v=\"%v\"", name, escapeLabel(values[i]))
+	}
+	if extra != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%v=\"%v\"", extra, formatFloat(extraValue))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// countingWriter keeps the first error and the number of bytes written
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// Handler serves the registry metrics in the text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, fmt.Sprintf("method %v not allowed", req.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Handler serves the default registry metrics
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+
+	r := NewRegistry()
+
+	jobs := r.NewCounterVec("test_jobs_total", "Jobs by status", "status")
+	jobs.With("failed").Inc()
+	jobs.With("complete").Add(2)
+	jobs.With("complete").Add(-1) // ignored
+
+	running := r.NewGauge("test_running", "Running jobs")
+	running.Inc()
+	running.Inc()
+	running.Dec()
+
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency", []float64{0.1, 1}, "op")
+	latency.With("read").Observe(0.05)
+	latency.With("read").Observe(0.1)
+	latency.With("read").Observe(5)
+
+	r.NewCounterVec("test_escaped_total", "Back\\slash\nnew line", "path").With("a\"b\\c\nd").Inc()
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_escaped_total Back\\slash\nnew line
+# TYPE test_escaped_total counter
+test_escaped_total{path="a\"b\\c\nd"} 1
+# HELP test_jobs_total Jobs by status
+# TYPE test_jobs_total counter
+test_jobs_total{status="complete"} 2
+test_jobs_total{status="failed"} 1
+# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{op="read",le="0.1"} 2
+test_latency_seconds_bucket{op="read",le="1"} 2
+test_latency_seconds_bucket{op="read",le="+Inf"} 3
+test_latency_seconds_sum{op="read"} 5.15
+test_latency_seconds_count{op="read"} 3
+# HELP test_running Running jobs
+# TYPE test_running gauge
+test_running 1
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%v\nbut got\n%v", expected, buf.String())
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected registering the same name twice to panic")
+		}
+	}()
+	r.NewGauge("test_total", "Test")
+}
+
+func TestHandler(t *testing.T) {
+
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test").Inc()
+
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 but got %v", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("expected text format content type but got %q", ct)
+	}
+
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if !strings.Contains(buf.String(), "test_total 1\n") {
+		t.Fatalf("expected the counter in the response but got\n%v", buf.String())
+	}
+
+	resp, err = http.Post(srv.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405 but got %v", resp.StatusCode)
+	}
+}
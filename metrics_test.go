@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJobMetrics(t *testing.T) {
+
+	store, srv, cleanup := newTestApi(t)
+	defer cleanup()
+
+	dir, cleanupDir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer cleanupDir()
+
+	claimed := jobsClaimed.With("file", "file").Value()
+	completed := jobsCompleted.With("file", "file").Value()
+
+	ctx := context.Background()
+	j := newTestCopyJob(dir)
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	s := testJobSettings()
+	if status := runTestJob(t, ctx, store, s, nil); status.jobError != nil {
+		t.Fatal(status.jobError)
+	}
+
+	if v := jobsClaimed.With("file", "file").Value(); v != claimed+1 {
+		t.Fatalf("expected %v claimed jobs but got %v", claimed+1, v)
+	}
+	if v := jobsCompleted.With("file", "file").Value(); v != completed+1 {
+		t.Fatalf("expected %v completed jobs but got %v", completed+1, v)
+	}
+	if v := jobsRunning.Value(); v != 0 {
+		t.Fatalf("expected no running jobs but got %v", v)
+	}
+
+	// the job isn't counted complete until the store says so
+	failedJobs := jobsFailed.With("file", "file").Value()
+	j = newTestCopyJob(dir)
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyStore{JobStore: store, completeErr: errors.New("update failed")}
+	if status := runTestJob(t, ctx, flaky, s, nil); status.jobError == nil {
+		t.Fatalf("expected the failed status update to be reported")
+	}
+	if v := jobsCompleted.With("file", "file").Value(); v != completed+1 {
+		t.Fatalf("expected %v completed jobs but got %v", completed+1, v)
+	}
+	if v := jobsFailed.With("file", "file").Value(); v != failedJobs+1 {
+		t.Fatalf("expected %v failed jobs but got %v", failedJobs+1, v)
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	for _, name := range []string{
+		`octopus_jobs_completed_total{src="file",dst="file"}`,
+		`octopus_uploaded_bytes_total{scheme="file"}`,
+		`octopus_part_duration_seconds_count{op="read",scheme="file"}`,
+	} {
+		if !strings.Contains(body.String(), name) {
+			t.Errorf("expected %v in the metrics", name)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package netio
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+)
+
+// compress and decompress option values. The data is compressed or
+// decompressed on the way so the size of the data written to the
+// destination is only known once it is written.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	// bzip2 is only decompressed
+	CompressionBzip2 = "bzip2"
+)
+
+// the content types of the compressed files
+var compressionContentTypes = map[string]string{
+	CompressionGzip:  "application/gzip",
+	CompressionZstd:  "application/zstd",
+	CompressionBzip2: "application/x-bzip2",
+}
+
+// the compressed and the decompressed data are passed along in chunks of that many bytes
+const compressionChunkSize = 64 * 1024
+
+// the zstd frames needing a bigger window (zstd --long=28 and up) are not
+// decompressed to bound the memory a transfer takes
+const maxZstdWindow = 128 << 20
+
+// transferCompression tells how the data of a single file is transformed:
+// decompressed first (e.g. to recompress it with another format) and then
+// compressed; either one may be empty
+type transferCompression struct {
+	decompress string
+	compress   string
+}
+
+// newTransferCompression returns the compression the compress and decompress
+// options ask for (nil if none). Unless the contentType option is given, the
+// destination takes the content type of the compressed data or none if the
+// data is decompressed as the source one is no longer right.
+func newTransferCompression(options map[string]string) (*transferCompression, error) {
+
+	c := &transferCompression{decompress: options["decompress"], compress: options["compress"]}
+	switch c.decompress {
+	case "", CompressionGzip, CompressionZstd, CompressionBzip2:
+	default:
+		return nil, fmt.Errorf("decompress %q is not supported", c.decompress)
+	}
+	switch c.compress {
+	case "", CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("compress %q is not supported", c.compress)
+	}
+	if c.decompress == "" && c.compress == "" {
+		return nil, nil
+	}
+
+	if _, ok := options["contentType"]; !ok {
+		options["contentType"] = compressionContentTypes[c.compress]
+	}
+	return c, nil
+}
+
+// decompressStage is the pipeline stage that decompresses the data passing
+// through. The decoder reads the data from a pipe the chunks received are
+// written to. It closes out when the whole compressed data is decoded and
+// leaves it open if ctx is done or the data can't be decompressed.
+func decompressStage(ctx context.Context, format string, in <-chan dlData, out chan<- dlData) error {
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	go func() {
+		for {
+			select {
+			case chunk, ok := <-in:
+				if !ok {
+					pw.Close()
+					return
+				}
+				if _, err := pw.Write(chunk.data); err != nil {
+					return // the decoder has quit
+				}
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			}
+		}
+	}()
+
+	failed := func(err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &Error{Kind: ErrIntegrity, Err: fmt.Errorf("can't decompress %v data: %v", format, err)}
+	}
+
+	var r io.Reader
+	switch format {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(pr)
+		if err != nil {
+			return failed(err)
+		}
+		r = gz
+	case CompressionZstd:
+		zr, err := zstd.NewReader(pr, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+		if err != nil {
+			return failed(err)
+		}
+		defer zr.Close()
+		r = zr
+	case CompressionBzip2:
+		r = bzip2.NewReader(pr)
+	}
+
+	var sent int64
+	for {
+		buf := make([]byte, compressionChunkSize)
+		n, err := readChunk(r, buf)
+		if n > 0 {
+			sent += int64(n)
+			select {
+			case out <- dlData{data: buf[:n], br: sent, total: -1}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return failed(err)
+		}
+	}
+
+	// the decoders read all the streams they find so anything left isn't one
+	if n, err := io.Copy(ioutil.Discard, pr); err != nil || n > 0 {
+		if err == nil {
+			err = errors.New("data after the compressed stream")
+		}
+		return failed(err)
+	}
+
+	close(out)
+	return nil
+}
+
+// readChunk reads to buf until it is full or the read fails. Unlike
+// io.ReadFull it tells the end of the data from the truncated one.
+func readChunk(r io.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		k, err := r.Read(buf[n:])
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// compressStage is the pipeline stage that compresses the data passing
+// through. It closes out when in is closed and the compressed data is sent.
+// It leaves out open if ctx is done.
+func compressStage(ctx context.Context, format string, in <-chan dlData, out chan<- dlData) error {
+
+	// chanWriter sends copies of the chunks of the buffer
+	buffered := bufio.NewWriterSize(newChanWriter(ctx, -1, out), compressionChunkSize)
+
+	var w io.WriteCloser
+	switch format {
+	case CompressionGzip:
+		w = gzip.NewWriter(buffered)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(buffered, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		w = zw
+	}
+
+	for {
+		select {
+		case chunk, ok := <-in:
+			if !ok {
+				if err := w.Close(); err != nil {
+					return err
+				}
+				if err := buffered.Flush(); err != nil {
+					return err
+				}
+				close(out)
+				return nil
+			}
+			if _, err := w.Write(chunk.data); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package netio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// "bzip2 compressed content\n" compressed by bzip2 -9
+const testBzip2 = "QlpoOTFBWSZTWY+dYLAAAAJZgAAQQAAQAB4j3BAgACGpoZGjTyhTAATQ4isuNnRSdqHj2YQXxdyRThQkI+dYLAA="
+
+func TestCompressedTransfer(t *testing.T) {
+
+	bz, err := base64.StdEncoding.DecodeString(testBzip2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("0123456789", 20000)
+	files := map[string]string{
+		"empty.txt": "",
+		"short.txt": "content",
+		"long.txt":  long,
+	}
+	dir := newTestDir(t, files)
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "src.bz2"), bz, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{CompressionGzip, CompressionZstd} {
+		for name, content := range files {
+			src := "file://" + filepath.Join(dir, name)
+			compressed := filepath.Join(dir, format, name)
+			decompressed := "file://" + filepath.Join(dir, format+"-plain", name)
+
+			opt := map[string]string{"compress": format}
+			result, err := Transfer(context.Background(), src, "file://"+compressed, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the destination isn't told the length of the source
+			if opt["contentLength"] != "-1" {
+				t.Fatalf("expected unknown content length of compressed %v but got %v", name, opt["contentLength"])
+			}
+			data, err := ioutil.ReadFile(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Bytes != int64(len(data)) {
+				t.Fatalf("expected %v compressed to %v bytes but got %v", name, len(data), result.Bytes)
+			}
+			if content == long && len(data) > len(content)/10 {
+				t.Fatalf("expected %v bytes compressed to less than a tenth but got %v", len(content), len(data))
+			}
+
+			// the data is readable with the standard decoders
+			var plain []byte
+			if format == CompressionGzip {
+				gz, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				plain, err = ioutil.ReadAll(gz)
+			} else {
+				plain, err = readZstd(data)
+			}
+			if err != nil || string(plain) != content {
+				t.Fatalf("expected %v %v data to decompress to %v bytes but got %v (%v)",
+					format, name, len(content), len(plain), err)
+			}
+
+			if _, err := Transfer(context.Background(), "file://"+compressed, decompressed,
+				map[string]string{"decompress": format}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkTestDir(t, filepath.Join(dir, format+"-plain"), files)
+	}
+
+	// the source is decompressed and compressed again in another format
+	opt := map[string]string{"decompress": CompressionBzip2, "compress": CompressionGzip}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.bz2", "file://"+dir+"/dst.gz", opt); err != nil {
+		t.Fatal(err)
+	}
+	opt = map[string]string{"decompress": CompressionGzip}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/dst.gz", "file://"+dir+"/dst.txt", opt); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(dir + "/dst.txt"); err != nil || string(data) != "bzip2 compressed content\n" {
+		t.Fatalf("expected bzip2 data recompressed but got %q (%v)", data, err)
+	}
+}
+
+func TestCorruptDecompression(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{"src.txt": strings.Repeat("0123456789", 20000)})
+	defer os.RemoveAll(dir)
+
+	opt := map[string]string{"compress": CompressionGzip}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "file://"+dir+"/src.gz", opt); err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := ioutil.ReadFile(dir + "/src.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		"plain":     []byte("not compressed"),
+		"truncated": compressed[:len(compressed)-4],
+		"trailing":  append(append([]byte{}, compressed...), "trailing data"...),
+	} {
+		if err := ioutil.WriteFile(dir+"/bad.gz", data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		opt := map[string]string{"decompress": CompressionGzip}
+		_, err := Transfer(context.Background(), "file://"+dir+"/bad.gz", "file://"+dir+"/dst.txt", opt)
+		if !errors.Is(err, ErrIntegrity) {
+			t.Fatalf("expected %v data to fail the integrity check but got %v", name, err)
+		}
+	}
+
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "file://"+dir+"/dst.txt",
+		map[string]string{"compress": CompressionBzip2}); err == nil {
+		t.Fatalf("expected bzip2 compression to fail")
+	}
+}
+
+func TestCompressedMultipartUpload(t *testing.T) {
+
+	content := strings.Repeat("0123456789", 20000)
+	dir := newTestDir(t, map[string]string{"src.txt": content})
+	defer os.RemoveAll(dir)
+
+	// the size of the compressed data is unknown so it is uploaded in parts
+	var m sync.Mutex
+	var contentType string
+	parts := make(map[string][]byte)
+	completed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		query := r.URL.Query()
+		_, initiate := query["uploads"]
+		switch {
+		case r.Method == http.MethodPost && initiate:
+			contentType = r.Header.Get("Content-Type")
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket>`+
+				`<Key>dst.zst</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && query.Get("uploadId") == "upload":
+			parts[query.Get("partNumber")], _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("ETag", `"part"`)
+		case r.Method == http.MethodPost && query.Get("uploadId") == "upload":
+			completed = true
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+		default:
+			t.Errorf("unexpected request %v %v", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	opt := map[string]string{
+		"awsEndpoint":        srv.URL,
+		"awsRegion":          "us-east-1",
+		"awsAccessKeyId":     "id",
+		"awsSecretAccessKey": "secret",
+		"compress":           CompressionZstd,
+	}
+	result, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "s3://host/bucket/dst.zst", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if !completed || contentType != "application/zstd" || result.Strategy != StrategyConcurrent {
+		t.Fatalf("expected multipart upload of application/zstd but got %v parts of %q (%v)",
+			len(parts), contentType, result.Strategy)
+	}
+	var numbers []string
+	for n := range parts {
+		numbers = append(numbers, n)
+	}
+	sort.Strings(numbers)
+	var data []byte
+	for _, n := range numbers {
+		data = append(data, parts[n]...)
+	}
+	plain, err := readZstd(data)
+	if err != nil || string(plain) != content {
+		t.Fatalf("expected the parts to decompress to %v bytes but got %v (%v)", len(content), len(plain), err)
+	}
+}
+
+func TestCompressedEncryptedTransfer(t *testing.T) {
+
+	content := strings.Repeat("0123456789", 20000)
+	dir := newTestDir(t, map[string]string{"src.txt": content})
+	defer os.RemoveAll(dir)
+	keyFile := newTestKeyFile(t, dir, "keys", map[string]byte{"k1": 1})
+
+	// the data is compressed before it is encrypted and decrypted before it is decompressed
+	opt := map[string]string{"compress": CompressionZstd, "encryption": EncryptionEncrypt, "encryptionKeyFile": keyFile}
+	result, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "file://"+dir+"/enc.zst", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Bytes > int64(len(content)/10) {
+		t.Fatalf("expected %v bytes compressed to less than a tenth but got %v", len(content), result.Bytes)
+	}
+
+	opt = map[string]string{"decompress": CompressionZstd, "encryption": EncryptionDecrypt, "encryptionKeyFile": keyFile}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/enc.zst", "file://"+dir+"/dst.txt", opt); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(dir + "/dst.txt"); err != nil || string(data) != content {
+		t.Fatalf("expected %v bytes decrypted and decompressed but got %v (%v)", len(content), len(data), err)
+	}
+}
+
+// readZstd decompresses the data with the zstd decoder defaults
+func readZstd(data []byte) ([]byte, error) {
+	zr, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
@@ -4,6 +4,8 @@ import (
     "context"
     "fmt"
 	"io"
+	"net/http"
+	"time"
 )
 
 type dlData struct {
@@ -28,7 +30,15 @@ type receiver interface {
 }
 
 type FileInfo struct {
-	Size int64
+	Size         int64
+	ETag         string    // empty if unknown
+	ModTime      time.Time // zero if unknown
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string // user metadata (x-amz-meta-*)
+	VersionId    string            // s3 object version if versioning is enabled
+	StatusCode   int               // http(s) HEAD response status
+	Header       http.Header       // http(s) HEAD response headers
 }
 
 type Downloader interface {
@@ -47,6 +57,8 @@ func getDownloader(scheme string) (dl Downloader, err error) {
 		return DownloaderSimple{}, nil
 	case "s3":
 		return DownloaderConcurrent{}, nil
+	case "file":
+		return DownloaderSimple{}, nil
 	default:
 		return nil, fmt.Errorf("download scheme %v is not supported", scheme)
 	}
@@ -60,6 +72,8 @@ func getReceiver(scheme string, size int64) (receiver, error) {
 		return &HttpReceiver{}, nil
 	case "s3":
 		return &S3ReceiverRanged{}, nil
+	case "file":
+		return &LocalFileReceiver{}, nil
 	default:
 		return nil, fmt.Errorf("receiver scheme \"%v\" is not supported", scheme)
 	}
@@ -4,9 +4,9 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"github.com/viktorburka/octopus/logging"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -37,32 +37,58 @@ func (s DownloaderConcurrent) Download(ctx context.Context, uri string,
 		return fmt.Errorf("error reading 'contentLength' value: %v", err)
 	}
 
-	// if contentLength is less than MinAwsPartSize,
+	partSize, err := intOption(options, "partSize", MinAwsPartSize)
+	if err != nil {
+		return err
+	}
+
+	maxWorkers, err := intOption(options, "concurrency", DefaultDownloadWorkers)
+	if err != nil {
+		return err
+	}
+
+	// if contentLength is less than partSize,
 	// to properly calculate parts count use min function
-	partSize := min(int64(MinAwsPartSize), contentLength)
+	partSize = min(partSize, contentLength)
 
 	tempDir, err := ioutil.TempDir(os.TempDir(), "")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(tempDir)
+
+	var buffer tempBuffer
+	defer buffer.release()
+
+	// cancelled on the first error to stop the parts in flight
+	dlCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	log.Println("open download connection")
+	logger := logging.FromContext(ctx)
+	logger.Debug("open download connection")
 
 	if err := rc.OpenWithContext(ctx, uri, options); err != nil {
 		return err
 	}
 
-	const MaxWorkers = 3 // can't be 0!
-
 	partschan := make(chan int)
 	errorchan := make(chan error)
-	workers   := make(chan struct{}, MaxWorkers) // channel as semaphore
+	workers   := make(chan struct{}, maxWorkers) // channel as semaphore
+
+	// the part goroutines give up sending once the download is cancelled
+	// as nothing reads the channels any more
+	sendError := func(err error) {
+		select {
+		case errorchan <- err:
+		case <-dlCtx.Done():
+		}
+	}
 
 	partsCount := contentLength/partSize+last(contentLength%partSize)
 
 	var wg sync.WaitGroup
 
-	log.Println("total download parts count:", partsCount)
+	logger.Info("downloading", "parts", partsCount, "partSize", partSize)
 
 	wg.Add(1)
 	go func() {
@@ -71,26 +97,29 @@ func (s DownloaderConcurrent) Download(ctx context.Context, uri string,
 
 		var wg2 sync.WaitGroup
 
-		for partNumber := int64(0); partNumber < partsCount; partNumber++ {
+		for partNumber := int64(0); partNumber < partsCount && dlCtx.Err() == nil; partNumber++ {
 			start := partNumber*partSize
 			end   := min(start+partSize, contentLength)
 
-			workers <- struct{}{}
+			select {
+			case workers <- struct{}{}:
+			case <-dlCtx.Done():
+				continue
+			}
 			wg2.Add(1)
 			go func(rangeStart int64, rangeEnd int64, curPart int64) {
 				defer wg2.Done()
 				defer func() {<-workers}()
 
-				log.Println("start download part", curPart+1, "of", partsCount)
+				partLog := logger.With("part", curPart+1)
+				partCtx := logging.NewContext(dlCtx, partLog)
 
 				fileName := fmt.Sprintf("%v.part", curPart)
 				filePath := filepath.Join(tempDir, fileName)
 
-				log.Println("open buffer file", fileName)
-
 				file, err := os.Create(filePath)
 				if err != nil {
-					errorchan <- err
+					sendError(err)
 					return
 				}
 				defer file.Close()
@@ -101,22 +130,26 @@ func (s DownloaderConcurrent) Download(ctx context.Context, uri string,
 					"partSize":   strconv.FormatInt(partSize,10),
 				}
 
-				log.Printf("start range download %v-%v\n", opt["rangeStart"], opt["rangeEnd"])
+				partLog.Debug("start part download", "rangeStart", rangeStart, "rangeEnd", rangeEnd)
 
-				_, err = rc.ReadPartWithContext(ctx, file, opt)
+				_, err = rc.ReadPartWithContext(partCtx, file, opt)
 				if err != nil {
-					errorchan <- err
+					sendError(err)
 					return
 				}
 
 				// done writing - close file
 				if err := file.Close(); err != nil {
-					errorchan <- err
+					sendError(err)
 					return
 				}
+				buffer.add(rangeEnd-rangeStart+1)
 
-				log.Printf("part %v download finished. passing to uploader\n", curPart+1)
-				partschan <- int(curPart)
+				partLog.Debug("part downloaded")
+				select {
+				case partschan <- int(curPart):
+				case <-dlCtx.Done():
+				}
 
 			}(start, end-1, partNumber)
 		}
@@ -130,6 +163,7 @@ func (s DownloaderConcurrent) Download(ctx context.Context, uri string,
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer cancel()
 
 		parts := make([]bool, partsCount)
 		ptr   := 0
@@ -144,7 +178,6 @@ func (s DownloaderConcurrent) Download(ctx context.Context, uri string,
 				if part >= len(parts) { // prevent array index out of bound error
 					opErr = fmt.Errorf("internal downloader error: part %v is out of range [0,%v)",
 						part, len(parts))
-					//cancel()
 					break
 				}
 				parts[part] = true
@@ -155,8 +188,8 @@ func (s DownloaderConcurrent) Download(ctx context.Context, uri string,
 					fileName := fmt.Sprintf("%v.part", ptr)
 					filePath := filepath.Join(tempDir, fileName)
 
-					log.Println("sending part", ptr+1, "to uploader")
-					bw, err := writePart(ctx, filePath, sent, contentLength, data)
+					logger.Debug("sending part to uploader", "part", ptr+1)
+					bw, err := writePart(dlCtx, filePath, sent, contentLength, data)
 					if err != nil {
 						opErr = err
 						break
@@ -165,6 +198,7 @@ func (s DownloaderConcurrent) Download(ctx context.Context, uri string,
 						opErr = err
 						break
 					}
+					buffer.add(-int64(bw))
 					sent += int64(bw)
 				}
 			case err := <-errorchan:
@@ -197,8 +231,10 @@ func writePart(ctx context.Context, filePath string, totalSent int64, totalSize
 
 	// read data
 	reader := bufio.NewReader(file)
-	buffer := make([]byte, 3*1024*1024)
 	for {
+		// the buffer is passed on through the pipeline
+		// so a new one is needed for every read
+		buffer := make([]byte, 3*1024*1024)
 		br, err := reader.Read(buffer)
 		if err != nil && err != io.EOF { // its an error (io.EOF is fine)
 			return -1, err
@@ -207,7 +243,6 @@ func writePart(ctx context.Context, filePath string, totalSent int64, totalSize
 		totalBytesSent += int64(br)
 		select {
 		case data <- dlData{data: buffer[:br], br: totalBytesSent, total: totalSize}:
-			log.Println("sent", totalBytesSent, "bytes to uploader")
 			break
 		case <-ctx.Done():
 			return -1, ctx.Err()
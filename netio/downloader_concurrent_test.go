@@ -189,7 +189,8 @@ func TestConcurrentDownloaderCancellation(t *testing.T)  {
 		t.Fatal(fmt.Errorf("error: expected DownloaderConcurrent instance"))
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
 	opt := map[string]string{"contentLength": strconv.FormatInt(DownloadSize, 10)}
 	uri := "s3://amazon.aws.com/bucket/key.mp4"
 	dtx := make(chan dlData)
@@ -202,6 +203,54 @@ func TestConcurrentDownloaderCancellation(t *testing.T)  {
 	}
 }
 
+func TestConcurrentDownloaderCancelPartsInFlight(t *testing.T) {
+
+	const parts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt := map[string]string{
+		"contentLength": strconv.FormatInt(6*MinAwsPartSize, 10),
+		"concurrency":   strconv.Itoa(parts),
+	}
+	uri := "s3://amazon.aws.com/bucket/key.mp4"
+	dtx := make(chan dlData)
+	rcv := &blockingReceiver{started: make(chan struct{}, parts)}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- DownloaderConcurrent{}.Download(ctx, uri, opt, dtx, rcv)
+	}()
+
+	for i := 0; i < parts; i++ {
+		<-rcv.started
+	}
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Fatalf("expected Download() to return '%v' error but got '%v'\n", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Download() to return once cancelled")
+	}
+}
+
+// blockingReceiver downloads the parts until the context is cancelled
+type blockingReceiver struct {
+	mockReceiverRanged
+	started chan struct{}
+}
+
+func (r *blockingReceiver) ReadPartWithContext(ctx context.Context,
+	output io.WriteSeeker, opt map[string]string) (string, error) {
+
+	r.started <- struct{}{}
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
 type mockReceiverRanged struct {
 	openError error
 	readPartError error
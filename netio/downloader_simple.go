@@ -21,7 +21,7 @@ func (h DownloaderSimple) Download(ctx context.Context, uri string, options map[
 		return fmt.Errorf("error reading 'contentLength' value: %v", err)
 	}
 
-	writer := newChanWriter(contentLength, data)
+	writer := newChanWriter(ctx, contentLength, data)
 
 	_, err = rc.ReadPartWithContext(ctx, writer, options)
 	if err != nil {
@@ -35,20 +35,29 @@ func (h DownloaderSimple) Download(ctx context.Context, uri string, options map[
 
 
 type chanWriter struct {
-	data chan dlData
+	ctx context.Context
+	data chan<- dlData
 	total int64
 	totalBytesRead int64
 }
 
-func newChanWriter(contentLength int64, data chan dlData) *chanWriter {
-	return &chanWriter{data: data, total:contentLength}
+func newChanWriter(ctx context.Context, contentLength int64, data chan<- dlData) *chanWriter {
+	return &chanWriter{ctx: ctx, data: data, total:contentLength}
 }
 
 func (w *chanWriter) Write(p []byte) (n int, err error) {
 	br := len(p)
 	w.totalBytesRead += int64(br)
-	w.data <- dlData{data:p, br:w.totalBytesRead, total:w.total}
-	return br,nil
+	// the caller may reuse p as soon as Write returns
+	// while the uploader is still working with the data
+	buf := make([]byte, br)
+	copy(buf, p)
+	select {
+	case w.data <- dlData{data:buf, br:w.totalBytesRead, total:w.total}:
+		return br,nil
+	case <-w.ctx.Done(): // uploader has quit
+		return 0, w.ctx.Err()
+	}
 }
 
 func (w *chanWriter) Seek(offset int64, whence int) (int64, error) {
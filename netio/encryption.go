@@ -0,0 +1,424 @@
+package netio
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// encryption option values. The data is encrypted with AES-256-GCM in
+// authenticated chunks under a random data key of every file; the data
+// key wrapped with a master key is stored in the destination metadata.
+const (
+	// encrypt the source data before uploading it
+	EncryptionEncrypt = "encrypt"
+	// decrypt the source data encrypted by octopus
+	EncryptionDecrypt = "decrypt"
+)
+
+// EncryptionAlgorithm tells how the encrypted files are encrypted
+const EncryptionAlgorithm = "AES256-GCM-CHUNKED"
+
+// the plain data is encrypted in chunks of that many bytes
+// each followed by the authentication tag of gcmTagSize
+const (
+	encryptionChunkSize = 64 * 1024
+	gcmTagSize          = 16
+)
+
+// user metadata of the encrypted files (the envelope)
+const (
+	envelopeAlgorithm = "octopus-encryption"
+	envelopeKeyId     = "octopus-encryption-key-id"
+	envelopeDataKey   = "octopus-encryption-data-key"
+	envelopeChunkSize = "octopus-encryption-chunk-size"
+)
+
+var envelopeKeys = []string{envelopeAlgorithm, envelopeKeyId, envelopeDataKey, envelopeChunkSize}
+
+// KeyProvider hands out data keys the way KMS GenerateDataKey and Decrypt
+// do: the data is encrypted with the plain key and the wrapped one is kept
+// along with it for the provider to unwrap when the data is decrypted
+type KeyProvider interface {
+	// GenerateDataKey returns a new data key, the key wrapped with
+	// the master key keyId (the default one if empty) and its id
+	GenerateDataKey(ctx context.Context, keyId string) (key []byte, wrapped []byte, id string, err error)
+	// DecryptDataKey unwraps the data key wrapped with the master key keyId
+	DecryptDataKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+}
+
+// KeyFile is a KeyProvider with the master keys kept in a local file, one
+// per line: the key id and the base64 encoded 32 byte key. The first key
+// is the default one; the older keys are kept to decrypt the old files.
+//
+//	# octopus master keys
+//	2024-06 Bm9RSQMv3mUZ8cAyK1xD2fWn4sLhE7tJpqTgYk0oVcI=
+//	2023-01 zW1rP5gQh8LxNc0aVt3EmYf6KsJdUo2iB7lOyR9ApeM=
+type KeyFile struct {
+	keys  map[string][]byte
+	first string
+}
+
+// LoadKeyFile reads the master keys from the file at path
+func LoadKeyFile(path string) (*KeyFile, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read key file: %w", fileError(err))
+	}
+	defer file.Close()
+
+	kf := &KeyFile{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%v: expected key id and key", path, n)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%v:%v: key %v is not a base64 encoded 32 byte key", path, n, fields[0])
+		}
+		if _, ok := kf.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%v:%v: key %v is repeated", path, n, fields[0])
+		}
+		kf.keys[fields[0]] = key
+		if kf.first == "" {
+			kf.first = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read key file: %w", fileError(err))
+	}
+	if kf.first == "" {
+		return nil, fmt.Errorf("no keys in %v", path)
+	}
+
+	return kf, nil
+}
+
+func (kf *KeyFile) GenerateDataKey(ctx context.Context, keyId string) ([]byte, []byte, string, error) {
+
+	if keyId == "" {
+		keyId = kf.first
+	}
+	master, ok := kf.keys[keyId]
+	if !ok {
+		return nil, nil, "", fmt.Errorf("unknown key %v", keyId)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, "", err
+	}
+
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, "", err
+	}
+
+	// the key id authenticated along makes sure
+	// the key is unwrapped with the one it names
+	wrapped := aead.Seal(nonce, nonce, key, []byte(keyId))
+	return key, wrapped, keyId, nil
+}
+
+func (kf *KeyFile) DecryptDataKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+
+	master, ok := kf.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %v", keyId)
+	}
+
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, &Error{Kind: ErrIntegrity, Err: errors.New("invalid wrapped data key")}
+	}
+	key, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyId))
+	if err != nil {
+		return nil, &Error{Kind: ErrIntegrity, Err: fmt.Errorf("can't unwrap data key with %v: %v", keyId, err)}
+	}
+	return key, nil
+}
+
+type keyProviderKey struct{}
+
+// WithKeyProvider returns a copy of ctx that makes Transfer and TransferDirectory
+// get the data keys from p instead of the encryptionKeyFile option
+func WithKeyProvider(ctx context.Context, p KeyProvider) context.Context {
+	return context.WithValue(ctx, keyProviderKey{}, p)
+}
+
+func keyProvider(ctx context.Context, options map[string]string) (KeyProvider, error) {
+	if p, ok := ctx.Value(keyProviderKey{}).(KeyProvider); ok {
+		return p, nil
+	}
+	if options["encryptionKeyFile"] == "" {
+		return nil, fmt.Errorf("encryption requires encryptionKeyFile")
+	}
+	return LoadKeyFile(options["encryptionKeyFile"])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// transferCipher encrypts or decrypts the data of a single file
+type transferCipher struct {
+	aead    cipher.AEAD
+	encrypt bool
+	chunk   int // plain chunk size
+}
+
+// newTransferCipher returns the cipher the encryption option asks for (nil
+// if none) along with the size of the data it produces from the source of
+// info (-1 if unknown). The options are updated to store the envelope with
+// the encrypted data and not to store the source one with the decrypted data.
+func newTransferCipher(ctx context.Context, info FileInfo, options map[string]string) (*transferCipher, int64, error) {
+
+	mode := options["encryption"]
+	if mode == "" {
+		return nil, info.Size, nil
+	}
+	if mode != EncryptionEncrypt && mode != EncryptionDecrypt {
+		return nil, 0, fmt.Errorf("encryption %q is not supported", mode)
+	}
+
+	keys, err := keyProvider(ctx, options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if mode == EncryptionEncrypt {
+		key, wrapped, keyId, err := keys.GenerateDataKey(ctx, options["encryptionKeyId"])
+		if err != nil {
+			return nil, 0, err
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, 0, err
+		}
+		options[MetadataOptionPrefix+envelopeAlgorithm] = EncryptionAlgorithm
+		options[MetadataOptionPrefix+envelopeKeyId] = keyId
+		options[MetadataOptionPrefix+envelopeDataKey] = base64.StdEncoding.EncodeToString(wrapped)
+		options[MetadataOptionPrefix+envelopeChunkSize] = strconv.Itoa(encryptionChunkSize)
+		c := &transferCipher{aead: aead, encrypt: true, chunk: encryptionChunkSize}
+		if info.Size < 0 {
+			return c, -1, nil
+		}
+		return c, encryptedSize(info.Size, encryptionChunkSize), nil
+	}
+
+	envelope := make(map[string]string, len(envelopeKeys))
+	for _, key := range envelopeKeys {
+		envelope[key] = metadataValue(info.Metadata, key)
+		delete(options, MetadataOptionPrefix+key)
+	}
+	if envelope[envelopeAlgorithm] == "" {
+		return nil, 0, fmt.Errorf("source is not encrypted (no %v metadata)", envelopeAlgorithm)
+	}
+	if envelope[envelopeAlgorithm] != EncryptionAlgorithm {
+		return nil, 0, fmt.Errorf("source encryption %q is not supported", envelope[envelopeAlgorithm])
+	}
+	chunk, err := strconv.Atoi(envelope[envelopeChunkSize])
+	if err != nil || chunk <= 0 {
+		return nil, 0, fmt.Errorf("invalid encryption chunk size %q", envelope[envelopeChunkSize])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(envelope[envelopeDataKey])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid encryption data key: %v", err)
+	}
+	key, err := keys.DecryptDataKey(ctx, envelope[envelopeKeyId], wrapped)
+	if err != nil {
+		return nil, 0, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	c := &transferCipher{aead: aead, chunk: chunk}
+	if info.Size < 0 {
+		return c, -1, nil
+	}
+	size, err := decryptedSize(info.Size, chunk)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c, size, nil
+}
+
+// metadataValue looks the key up ignoring the case
+// as the storages tend to change the one of metadata
+func metadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// encryptedSize returns the size of the encrypted data of size. The
+// data is split into chunks; the empty data takes one empty chunk.
+func encryptedSize(size int64, chunk int) int64 {
+	chunks := (size + int64(chunk) - 1) / int64(chunk)
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*gcmTagSize
+}
+
+// decryptedSize returns the size of the data encrypted to size
+func decryptedSize(size int64, chunk int) (int64, error) {
+	sealed := int64(chunk + gcmTagSize)
+	chunks := (size + sealed - 1) / sealed
+	if chunks == 0 || size-(chunks-1)*sealed < gcmTagSize {
+		return 0, &Error{Kind: ErrIntegrity, Err: fmt.Errorf("invalid encrypted data size %v", size)}
+	}
+	return size - chunks*gcmTagSize, nil
+}
+
+// nonce returns the nonce of the chunk; the last chunk has its
+// own ones so that the truncated data can't be passed for whole
+func (c *transferCipher) nonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// seal encrypts or decrypts the chunk
+func (c *transferCipher) seal(counter uint64, chunk []byte, last bool) ([]byte, error) {
+	if c.encrypt {
+		return c.aead.Seal(nil, c.nonce(counter, last), chunk, nil), nil
+	}
+	plain, err := c.aead.Open(nil, c.nonce(counter, last), chunk, nil)
+	if err != nil {
+		return nil, &Error{Kind: ErrIntegrity, Err: fmt.Errorf("can't decrypt chunk %v: %v", counter, err)}
+	}
+	return plain, nil
+}
+
+// cryptStage is the pipeline stage between the downloader and the measure
+// stage that encrypts or decrypts the data passing through chunk by chunk.
+// It closes out when in is closed and the last chunk is sent. It leaves out
+// open if ctx is done or the data can't be decrypted.
+func cryptStage(ctx context.Context, c *transferCipher, in <-chan dlData, out chan<- dlData, total int64) error {
+
+	inSize := c.chunk
+	if !c.encrypt {
+		inSize += gcmTagSize
+	}
+
+	var buffer []byte
+	var counter uint64
+	var sent int64
+
+	send := func(chunk []byte, last bool) error {
+		data, err := c.seal(counter, chunk, last)
+		if err != nil {
+			return err
+		}
+		counter++
+		sent += int64(len(data))
+		select {
+		case out <- dlData{data: data, br: sent, total: total}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		select {
+		case chunk, ok := <-in:
+			if !ok {
+				if err := send(buffer, true); err != nil {
+					return err
+				}
+				close(out)
+				return nil
+			}
+			buffer = append(buffer, chunk.data...)
+			// a full chunk is the last one unless more data follows
+			for len(buffer) > inSize {
+				if err := send(buffer[:inSize], false); err != nil {
+					return err
+				}
+				buffer = buffer[inSize:]
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// the local files have no metadata to keep the envelope in
+// so it is kept in a file next to the encrypted one
+const envelopeFileSuffix = ".octopus-envelope"
+
+// writeEnvelopeFile stores the envelope given with the options
+// for the local file at path or removes the stale one
+func writeEnvelopeFile(path string, opt map[string]string) error {
+	envelope := make(map[string]string)
+	for _, key := range envelopeKeys {
+		if val := opt[MetadataOptionPrefix+key]; val != "" {
+			envelope[key] = val
+		}
+	}
+	if len(envelope) == 0 {
+		if err := os.Remove(path + envelopeFileSuffix); err != nil && !os.IsNotExist(err) {
+			return fileError(err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return fileError(ioutil.WriteFile(path+envelopeFileSuffix, data, 0600))
+}
+
+// readEnvelopeFile returns the envelope of the local file at path, nil if it isn't encrypted
+func readEnvelopeFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path + envelopeFileSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fileError(err)
+	}
+	var envelope map[string]string
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid envelope file %v: %v", path+envelopeFileSuffix, err)
+	}
+	return envelope, nil
+}
@@ -0,0 +1,201 @@
+package netio
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestKeyFile writes a key file with the keys of the given ids
+// made of the repeated byte to dir and returns its path
+func newTestKeyFile(t *testing.T, dir string, name string, keys map[string]byte) string {
+	var lines []string
+	for id, b := range keys {
+		lines = append(lines, id+" "+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32)))
+	}
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte("# test keys\n"+strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestEncryptedSize(t *testing.T) {
+
+	for _, size := range []int64{0, 1, 4095, 4096, 4097, 3 * 4096} {
+		encrypted := encryptedSize(size, 4096)
+		if decrypted, err := decryptedSize(encrypted, 4096); err != nil || decrypted != size {
+			t.Fatalf("expected %v bytes encrypted to %v to decrypt to %v but got %v (%v)",
+				size, encrypted, size, decrypted, err)
+		}
+	}
+	for _, size := range []int64{0, 15, 4096 + gcmTagSize + 3} {
+		if _, err := decryptedSize(size, 4096); !errors.Is(err, ErrIntegrity) {
+			t.Fatalf("expected %v bytes to be invalid encrypted data size but got %v", size, err)
+		}
+	}
+}
+
+func TestEncryptedTransfer(t *testing.T) {
+
+	files := map[string]string{
+		"empty.txt": "",
+		"short.txt": "content",
+		"whole.bin": strings.Repeat("x", 2*encryptionChunkSize),
+		"long.bin":  strings.Repeat("0123456789", 20000),
+	}
+	dir := newTestDir(t, files)
+	defer os.RemoveAll(dir)
+	keyFile := newTestKeyFile(t, dir, "keys", map[string]byte{"k1": 1})
+
+	for name, content := range files {
+		src := "file://" + filepath.Join(dir, name)
+		encrypted := "file://" + filepath.Join(dir, "encrypted", name)
+		decrypted := "file://" + filepath.Join(dir, "decrypted", name)
+
+		opt := map[string]string{"encryption": EncryptionEncrypt, "encryptionKeyFile": keyFile}
+		result, err := Transfer(context.Background(), src, encrypted, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := encryptedSize(int64(len(content)), encryptionChunkSize)
+		if result.Bytes != expected {
+			t.Fatalf("expected %v encrypted to %v bytes but got %v", name, expected, result.Bytes)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "encrypted", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(content) > 0 && bytes.Contains(data, []byte(content[:7])) {
+			t.Fatalf("expected %v to be encrypted", name)
+		}
+		envelope, err := readEnvelopeFile(filepath.Join(dir, "encrypted", name))
+		if err != nil || envelope[envelopeKeyId] != "k1" || envelope[envelopeAlgorithm] != EncryptionAlgorithm {
+			t.Fatalf("expected the envelope of %v but got %v (%v)", name, envelope, err)
+		}
+
+		opt = map[string]string{"encryption": EncryptionDecrypt, "encryptionKeyFile": keyFile}
+		if _, err := Transfer(context.Background(), encrypted, decrypted, opt); err != nil {
+			t.Fatal(err)
+		}
+		if envelope, err := readEnvelopeFile(filepath.Join(dir, "decrypted", name)); envelope != nil || err != nil {
+			t.Fatalf("expected the decrypted %v not to have an envelope but got %v (%v)", name, envelope, err)
+		}
+	}
+
+	checkTestDir(t, filepath.Join(dir, "decrypted"), files)
+
+	// the directories are decrypted file by file leaving the envelopes out
+	opt := map[string]string{"encryption": EncryptionDecrypt, "encryptionKeyFile": keyFile}
+	result, err := TransferDirectory(context.Background(), "file://"+dir+"/encrypted/", "file://"+dir+"/copy/", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != len(files) || result.Copied != len(files) || len(result.Errors) != 0 {
+		t.Fatalf("expected %v files decrypted but got %+v", len(files), result)
+	}
+	checkTestDir(t, filepath.Join(dir, "copy"), files)
+}
+
+func TestTamperedDecryption(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{"src.bin": strings.Repeat("0123456789", 20000)})
+	defer os.RemoveAll(dir)
+	keyFile := newTestKeyFile(t, dir, "keys", map[string]byte{"k1": 1, "k2": 2})
+	otherKeyFile := newTestKeyFile(t, dir, "other", map[string]byte{"k1": 3, "k2": 3})
+
+	opt := map[string]string{"encryption": EncryptionEncrypt, "encryptionKeyFile": keyFile, "encryptionKeyId": "k2"}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.bin", "file://"+dir+"/enc.bin", opt); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := ioutil.ReadFile(dir + "/enc.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := ioutil.ReadFile(dir + "/enc.bin" + envelopeFileSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte{}, encrypted...)
+	flipped[100000] ^= 1
+	// the first chunks make a valid size but the last one is missing
+	truncated := encrypted[:2*(encryptionChunkSize+gcmTagSize)]
+
+	for name, tc := range map[string]struct {
+		data    []byte
+		keyFile string
+	}{
+		"flipped":   {flipped, keyFile},
+		"truncated": {truncated, keyFile},
+		"wrong key": {encrypted, otherKeyFile},
+	} {
+		if err := ioutil.WriteFile(dir+"/bad.bin", tc.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dir+"/bad.bin"+envelopeFileSuffix, envelope, 0644); err != nil {
+			t.Fatal(err)
+		}
+		opt := map[string]string{"encryption": EncryptionDecrypt, "encryptionKeyFile": tc.keyFile}
+		_, err := Transfer(context.Background(), "file://"+dir+"/bad.bin", "file://"+dir+"/dst.bin", opt)
+		if !errors.Is(err, ErrIntegrity) {
+			t.Fatalf("expected %v data to fail the integrity check but got %v", name, err)
+		}
+	}
+
+	// the plain files are not decrypted
+	opt = map[string]string{"encryption": EncryptionDecrypt, "encryptionKeyFile": keyFile}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.bin", "file://"+dir+"/dst.bin", opt); err == nil {
+		t.Fatalf("expected decryption of the plain file to fail")
+	}
+}
+
+func TestEncryptedUploadMetadata(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer os.RemoveAll(dir)
+	keyFile := newTestKeyFile(t, dir, "keys", map[string]byte{"k1": 1})
+
+	var header http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/bucket/dst.txt" {
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	defer srv.Close()
+
+	opt := map[string]string{
+		"awsEndpoint":        srv.URL,
+		"awsRegion":          "us-east-1",
+		"awsAccessKeyId":     "id",
+		"awsSecretAccessKey": "secret",
+		"encryption":         EncryptionEncrypt,
+		"encryptionKeyFile":  keyFile,
+	}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "s3://host/bucket/dst.txt", opt); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body) != 7+gcmTagSize {
+		t.Fatalf("expected %v encrypted bytes but got %v", 7+gcmTagSize, len(body))
+	}
+	if header.Get("X-Amz-Meta-Octopus-Encryption") != EncryptionAlgorithm ||
+		header.Get("X-Amz-Meta-Octopus-Encryption-Key-Id") != "k1" ||
+		header.Get("X-Amz-Meta-Octopus-Encryption-Data-Key") == "" {
+		t.Fatalf("expected the envelope in the object metadata but got %v", header)
+	}
+}
@@ -0,0 +1,206 @@
+package netio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// The kinds of transfer errors. The errors returned by Transfer
+// match them with errors.Is when the cause is known.
+var (
+	// ErrNotFound tells that the file or directory doesn't exist
+	ErrNotFound = errors.New("file not found")
+	// ErrAccessDenied tells that the credentials are missing or not
+	// sufficient to access the file
+	ErrAccessDenied = errors.New("access denied")
+	// ErrThrottled tells that the server limits the request rate
+	ErrThrottled = errors.New("throttled")
+	// ErrUnavailable tells that the server is temporarily unable to
+	// serve the request (5xx, network errors)
+	ErrUnavailable = errors.New("service unavailable")
+	// ErrIntegrity tells that the data received or stored doesn't
+	// match the expected size or checksum
+	ErrIntegrity = errors.New("integrity mismatch")
+	// ErrCancelled tells that the transfer has been interrupted
+	ErrCancelled = errors.New("cancelled")
+	// ErrQuotaExceeded tells that the destination is out of space
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// Error is a transfer error of the known Kind (one of the Err* values)
+// keeping its underlying cause. errors.Is reports true for the Kind
+// and errors.As finds the cause (e.g. awserr.RequestFailure).
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// machine-readable codes of the error kinds
+var errorCodes = []struct {
+	kind error
+	code string
+}{
+	{ErrNotFound, "NotFound"},
+	{ErrAccessDenied, "AccessDenied"},
+	{ErrThrottled, "Throttled"},
+	{ErrUnavailable, "Unavailable"},
+	{ErrIntegrity, "IntegrityMismatch"},
+	{ErrCancelled, "Cancelled"},
+	{ErrQuotaExceeded, "QuotaExceeded"},
+	{context.Canceled, "Cancelled"},
+}
+
+// ErrorCode returns the machine-readable code of the error kind,
+// e.g. NotFound or Throttled; Unknown if the kind is not known
+// and empty string for nil error
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.kind) {
+			return c.code
+		}
+	}
+	return "Unknown"
+}
+
+// IsTemporary tells if the transfer failed with an error worth retrying
+func IsTemporary(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrThrottled)
+}
+
+// statusError returns nil if status is one of the expected ones
+// and the error matching the status class otherwise
+func statusError(status int, expected ...int) error {
+
+	for _, code := range expected {
+		if status == code {
+			return nil
+		}
+	}
+
+	cause := fmt.Errorf("unexpected response status %v %v", status, http.StatusText(status))
+
+	kind := statusKind(status)
+	if kind == nil {
+		return cause
+	}
+
+	return &Error{Kind: kind, Err: cause}
+}
+
+// statusKind maps the error response status to the error kind;
+// nil if it is none of them
+func statusKind(status int) error {
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return ErrNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAccessDenied
+	case status == http.StatusTooManyRequests:
+		return ErrThrottled
+	case status == http.StatusInsufficientStorage:
+		return ErrQuotaExceeded
+	case status == http.StatusRequestTimeout || status >= 500:
+		return ErrUnavailable
+	default:
+		return nil
+	}
+}
+
+// requestError marks the network errors as temporary
+// and the requests interrupted by ctx as cancelled
+func requestError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return &Error{Kind: ErrCancelled, Err: err}
+	}
+	return &Error{Kind: ErrUnavailable, Err: err}
+}
+
+// s3 error codes that tell more than the response status
+var s3ErrorKinds = map[string]error{
+	"NoSuchKey":               ErrNotFound,
+	"NoSuchBucket":            ErrNotFound,
+	"NoSuchUpload":            ErrNotFound,
+	"AccessDenied":            ErrAccessDenied,
+	"InvalidAccessKeyId":      ErrAccessDenied,
+	"SignatureDoesNotMatch":   ErrAccessDenied,
+	"ExpiredToken":            ErrAccessDenied,
+	"SlowDown":                ErrThrottled,
+	"Throttling":              ErrThrottled,
+	"RequestLimitExceeded":    ErrThrottled,
+	"BadDigest":               ErrIntegrity,
+	"InvalidDigest":           ErrIntegrity,
+	"QuotaExceeded":           ErrQuotaExceeded,
+	request.CanceledErrorCode: ErrCancelled,
+}
+
+// aws sdk error codes of the requests that got no response
+// (connection resets, DNS failures, timeouts)
+var s3RequestErrorCodes = map[string]bool{
+	"RequestError":                 true,
+	request.ErrCodeResponseTimeout: true,
+}
+
+// s3Error classifies the S3 request errors by their
+// code or response status keeping the original error;
+// the requests without a response are handled as requestError
+func s3Error(ctx context.Context, err error) error {
+
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	if kind, ok := s3ErrorKinds[awsErr.Code()]; ok {
+		return &Error{Kind: kind, Err: err}
+	}
+
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		if kind := statusKind(reqErr.StatusCode()); kind != nil {
+			return &Error{Kind: kind, Err: err}
+		}
+	}
+
+	if s3RequestErrorCodes[awsErr.Code()] {
+		return requestError(ctx, err)
+	}
+
+	return err
+}
+
+// fileError classifies the local file system errors
+func fileError(err error) error {
+
+	switch {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err):
+		return &Error{Kind: ErrNotFound, Err: err}
+	case os.IsPermission(err):
+		return &Error{Kind: ErrAccessDenied, Err: err}
+	case errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT):
+		return &Error{Kind: ErrQuotaExceeded, Err: err}
+	default:
+		return err
+	}
+}
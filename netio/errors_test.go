@@ -0,0 +1,112 @@
+package netio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+
+	tests := []struct {
+		status int
+		expect error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusGone, ErrNotFound},
+		{http.StatusForbidden, ErrAccessDenied},
+		{http.StatusUnauthorized, ErrAccessDenied},
+		{http.StatusServiceUnavailable, ErrUnavailable},
+		{http.StatusTooManyRequests, ErrThrottled},
+		{http.StatusInsufficientStorage, ErrQuotaExceeded},
+	}
+
+	for _, tt := range tests {
+		if err := statusError(tt.status, http.StatusOK); !errors.Is(err, tt.expect) {
+			t.Errorf("expected status %v to be %v but got %v", tt.status, tt.expect, err)
+		}
+	}
+
+	if err := statusError(http.StatusPartialContent, http.StatusOK, http.StatusPartialContent); err != nil {
+		t.Fatalf("expected status 206 to pass but got %v", err)
+	}
+
+	err := statusError(http.StatusTeapot, http.StatusOK)
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrAccessDenied) || IsTemporary(err) {
+		t.Fatalf("expected unclassified error but got %v", err)
+	}
+}
+
+func TestRequestError(t *testing.T) {
+
+	if !IsTemporary(requestError(context.Background(), errors.New("connection refused"))) {
+		t.Fatalf("expected network error to be temporary")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := requestError(ctx, ctx.Err()); IsTemporary(err) || !errors.Is(err, ErrCancelled) {
+		t.Fatalf("expected interrupted request to be cancelled but got %v", err)
+	}
+}
+
+func TestS3Error(t *testing.T) {
+
+	cause := awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), http.StatusServiceUnavailable, "")
+	err := s3Error(context.Background(), cause)
+	if !errors.Is(err, ErrThrottled) || !IsTemporary(err) {
+		t.Fatalf("expected SlowDown to be throttled but got %v", err)
+	}
+
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) || reqErr.StatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("expected the cause to be kept but got %v", err)
+	}
+
+	notFound := awserr.NewRequestFailure(awserr.New("NotFound", "", nil), http.StatusNotFound, "")
+	if err := s3Error(context.Background(), notFound); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected 404 to be not found but got %v", err)
+	}
+
+	reset := awserr.New("RequestError", "send request failed", errors.New("connection reset by peer"))
+	if err := s3Error(context.Background(), reset); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected request error to be unavailable but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s3Error(ctx, reset); IsTemporary(err) || !errors.Is(err, ErrCancelled) {
+		t.Fatalf("expected interrupted request error to be cancelled but got %v", err)
+	}
+
+	missingRegion := awserr.New("MissingRegion", "could not find region configuration", nil)
+	if err := s3Error(context.Background(), missingRegion); IsTemporary(err) {
+		t.Fatalf("expected configuration error not to be temporary but got %v", err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+
+	tests := []struct {
+		err    error
+		expect string
+	}{
+		{nil, ""},
+		{errors.New("boom"), "Unknown"},
+		{context.Canceled, "Cancelled"},
+		{&Error{Kind: ErrAccessDenied, Err: errors.New("forbidden")}, "AccessDenied"},
+		{fmt.Errorf("can't collect src file info: %w", &Error{Kind: ErrNotFound, Err: errors.New("404")}), "NotFound"},
+		{fileError(&os.PathError{Op: "write", Path: "/data", Err: syscall.ENOSPC}), "QuotaExceeded"},
+	}
+
+	for _, tt := range tests {
+		if code := ErrorCode(tt.err); code != tt.expect {
+			t.Errorf("expected %v code to be %q but got %q", tt.err, tt.expect, code)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package netio
+
+import (
+	"context"
+	"fmt"
+)
+
+// ObjectInfo describes a file found in a directory or prefix
+type ObjectInfo struct {
+	Path string // relative to the directory, '/' separated
+	FileInfo    // Size is -1 if unknown
+}
+
+type lister interface {
+	List(ctx context.Context, uri string, options map[string]string) ([]ObjectInfo, error)
+}
+
+func getLister(scheme string) (lister, error) {
+	switch scheme {
+	case "http":
+		fallthrough
+	case "https":
+		return HttpManifestLister{}, nil
+	case "s3":
+		return S3Lister{}, nil
+	case "file":
+		return LocalFileLister{}, nil
+	default:
+		return nil, fmt.Errorf("listing scheme %v is not supported", scheme)
+	}
+}
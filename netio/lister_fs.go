@@ -0,0 +1,56 @@
+package netio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalFileLister walks the local directory tree
+type LocalFileLister struct {
+}
+
+func (l LocalFileLister) List(ctx context.Context, uri string, options map[string]string) ([]ObjectInfo, error) {
+
+	root, err := localPath(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	var objects []ObjectInfo
+
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() { // directories, symlinks, devices
+			return nil
+		}
+		if strings.HasSuffix(p, envelopeFileSuffix) { // listed along with the encrypted file
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		fileInfo, err := localFileInfo(p, info, options)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Path: filepath.ToSlash(rel), FileInfo: fileInfo})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,60 @@
+package netio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultManifest is the name of the file listing the files
+// of http directory, one path relative to the directory per line
+const DefaultManifest = "manifest.txt"
+
+// HttpManifestLister reads the list of files from the manifest
+// in the directory (the manifest option overrides its name)
+type HttpManifestLister struct {
+}
+
+func (l HttpManifestLister) List(ctx context.Context, uri string, options map[string]string) ([]ObjectInfo, error) {
+
+	base, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := options["manifest"]
+	if manifest == "" {
+		manifest = DefaultManifest
+	}
+
+	req, err := http.NewRequest("GET", joinUrl(base, manifest), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := newHttpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(resp.StatusCode, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("can't get manifest %v: %w", req.URL, err)
+	}
+
+	var objects []ObjectInfo
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		objects = append(objects, ObjectInfo{Path: strings.TrimPrefix(line, "/"), FileInfo: FileInfo{Size: -1}})
+	}
+
+	return objects, scanner.Err()
+}
@@ -0,0 +1,60 @@
+package netio
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strings"
+)
+
+// S3Lister lists all the objects under the prefix
+type S3Lister struct {
+}
+
+func (l S3Lister) List(ctx context.Context, uri string, options map[string]string) ([]ObjectInfo, error) {
+
+	bucket, prefix, err := s3Location(uri, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// photos/ must not match photos2/...
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	s3client, err := newS3Client(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	err = s3client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if strings.HasSuffix(key, "/") { // folder placeholder
+				continue
+			}
+			objects = append(objects, ObjectInfo{
+				Path: strings.TrimPrefix(key, prefix),
+				FileInfo: FileInfo{
+					Size:    aws.Int64Value(obj.Size),
+					ETag:    aws.StringValue(obj.ETag),
+					ModTime: aws.TimeValue(obj.LastModified),
+				},
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s3Error(ctx, err)
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,122 @@
+package netio
+
+import (
+	"context"
+	"github.com/viktorburka/octopus/metrics"
+	"github.com/viktorburka/octopus/tracing"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	downloadedBytes = metrics.NewCounterVec("octopus_downloaded_bytes_total",
+		"Bytes read from the sources by scheme", "scheme")
+	uploadedBytes = metrics.NewCounterVec("octopus_uploaded_bytes_total",
+		"Bytes written to the destinations by scheme", "scheme")
+	partDuration = metrics.NewHistogramVec("octopus_part_duration_seconds",
+		"Time to read (ReadPartWithContext) or write (WritePartWithContext) a part", metrics.DefaultBuckets,
+		"op", "scheme")
+	requestRetries = metrics.NewCounterVec("octopus_retries_total",
+		"Storage requests retried by scheme", "scheme")
+	tempBufferBytes = metrics.NewGauge("octopus_temp_buffer_bytes",
+		"Bytes held in the temporary part files")
+)
+
+// measuredReceiver counts the bytes read, times and traces every part
+// and holds up the reads a rate limiter given with ctx doesn't let through
+type measuredReceiver struct {
+	receiver
+	scheme string
+}
+
+func (r measuredReceiver) ReadPartWithContext(ctx context.Context, output io.WriteSeeker,
+	opt map[string]string) (string, error) {
+
+	ctx, span := tracing.Start(ctx, "download part",
+		partAttributes(r.scheme, opt, "rangeStart", "rangeEnd")...)
+	defer span.End()
+
+	if limiter := rateLimiterFromContext(ctx); limiter != nil {
+		output = &limitedWriteSeeker{WriteSeeker: output, ctx: ctx, limiter: limiter}
+	}
+
+	begin := time.Now()
+	cw := &countingWriteSeeker{WriteSeeker: output}
+	etag, err := r.receiver.ReadPartWithContext(ctx, cw, opt)
+	downloadedBytes.With(r.scheme).Add(float64(cw.n))
+	partDuration.With("read", r.scheme).Observe(time.Since(begin).Seconds())
+	span.SetAttributes("bytes", cw.n)
+	span.SetError(err)
+	return etag, err
+}
+
+// measuredSender counts the bytes written and times and traces every part
+type measuredSender struct {
+	sender
+	scheme string
+}
+
+func (s measuredSender) WritePartWithContext(ctx context.Context, input io.ReadSeeker,
+	opt map[string]string) (string, error) {
+
+	begin := time.Now()
+	size := remaining(input)
+
+	ctx, span := tracing.Start(ctx, "upload part",
+		partAttributes(s.scheme, opt, "partNumber")...)
+	span.SetAttributes("bytes", size)
+	defer span.End()
+
+	etag, err := s.sender.WritePartWithContext(ctx, input, opt)
+	if err == nil {
+		uploadedBytes.With(s.scheme).Add(float64(size))
+	}
+	partDuration.With("write", s.scheme).Observe(time.Since(begin).Seconds())
+	span.SetError(err)
+	return etag, err
+}
+
+type countingWriteSeeker struct {
+	io.WriteSeeker
+	n int64
+}
+
+func (c *countingWriteSeeker) Write(p []byte) (int, error) {
+	n, err := c.WriteSeeker.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// remaining returns the number of bytes from the current position to the
+// end leaving the position as it was; the readers may read the part more
+// than once (e.g. to sign it) so counting the reads doesn't tell the size
+func remaining(input io.ReadSeeker) int64 {
+	cur, err := input.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0
+	}
+	end, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0
+	}
+	if _, err := input.Seek(cur, io.SeekStart); err != nil {
+		return 0
+	}
+	return end - cur
+}
+
+// tempBuffer accounts the bytes a transfer holds in the temporary part files
+type tempBuffer struct {
+	n int64
+}
+
+func (b *tempBuffer) add(n int64) {
+	atomic.AddInt64(&b.n, n)
+	tempBufferBytes.Add(float64(n))
+}
+
+// release accounts all the remaining bytes as freed
+func (b *tempBuffer) release() {
+	tempBufferBytes.Add(-float64(atomic.SwapInt64(&b.n, 0)))
+}
@@ -0,0 +1,45 @@
+package netio
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+func TestTransferMetrics(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer os.RemoveAll(dir)
+
+	downloaded := downloadedBytes.With("file").Value()
+	uploaded := uploadedBytes.With("file").Value()
+	reads := partDuration.With("read", "file").Count()
+
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "file://"+dir+"/dst.txt", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := downloadedBytes.With("file").Value(); v != downloaded+7 {
+		t.Fatalf("expected %v bytes downloaded but got %v", downloaded+7, v)
+	}
+	if v := uploadedBytes.With("file").Value(); v != uploaded+7 {
+		t.Fatalf("expected %v bytes uploaded but got %v", uploaded+7, v)
+	}
+	if n := partDuration.With("read", "file").Count(); n != reads+1 {
+		t.Fatalf("expected %v parts read but got %v", reads+1, n)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+
+	input := bytes.NewReader([]byte("content"))
+	input.Seek(3, 0)
+
+	if n := remaining(input); n != 4 {
+		t.Fatalf("expected 4 bytes remaining but got %v", n)
+	}
+	if pos, _ := input.Seek(0, 1); pos != 3 {
+		t.Fatalf("expected position to be kept at 3 but got %v", pos)
+	}
+}
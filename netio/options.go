@@ -0,0 +1,83 @@
+package netio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Transfer options understood by the downloaders, uploaders,
+// receivers and senders in addition to bucketNameStyle:
+//
+//	partSize       part size in bytes for s3 ranged download and multipart upload
+//	concurrency    number of parts downloaded or uploaded in parallel
+//	checksum       none or md5 (send Content-MD5 for every s3 part)
+//	recursive      true to transfer all the files under the source prefix
+//	parallelFiles  number of files of a directory transferred in parallel
+//	manifest       http directory manifest file name
+//	sync           true to copy only the files missing or different in the destination
+//	syncCompare    size, mtime or etag
+//	syncDelete     true to delete destination files missing from the source directory
+//	contentType    destination file content type (source one by default)
+//	cacheControl   destination file Cache-Control (source one by default)
+//	meta-<name>    destination file user metadata (source one by default)
+//	encryption     encrypt or decrypt the data on the way (see EncryptionEncrypt)
+//	encryptionKeyFile, encryptionKeyId  master keys file (see KeyFile) and the one to encrypt with
+//	compress       gzip or zstd to compress the data on the way (see CompressionGzip)
+//	decompress     gzip, zstd or bzip2 to decompress the source data on the way
+//	awsRegion, awsEndpoint, awsAccessKeyId, awsSecretAccessKey, awsSessionToken
+const (
+	DefaultDownloadWorkers = 3
+	DefaultUploadWorkers   = 5
+)
+
+// user metadata options prefix
+const MetadataOptionPrefix = "meta-"
+
+// setObjectOptions passes the source file attributes to the
+// sender unless the options already give them
+func setObjectOptions(opt map[string]string, info FileInfo) {
+	if _, ok := opt["contentType"]; !ok && info.ContentType != "" {
+		opt["contentType"] = info.ContentType
+	}
+	if _, ok := opt["cacheControl"]; !ok && info.CacheControl != "" {
+		opt["cacheControl"] = info.CacheControl
+	}
+	for name, val := range info.Metadata {
+		key := MetadataOptionPrefix + strings.ToLower(name)
+		if _, ok := opt[key]; !ok {
+			opt[key] = val
+		}
+	}
+}
+
+// metadataOptions returns the user metadata given with meta-<name> options
+func metadataOptions(opt map[string]string) map[string]string {
+	var metadata map[string]string
+	for key, val := range opt {
+		if !strings.HasPrefix(key, MetadataOptionPrefix) || len(key) == len(MetadataOptionPrefix) {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[key[len(MetadataOptionPrefix):]] = val
+	}
+	return metadata
+}
+
+// intOption returns the positive integer option value or def if not set
+func intOption(opt map[string]string, key string, def int64) (int64, error) {
+
+	val, ok := opt[key]
+	if !ok || val == "" {
+		return def, nil
+	}
+
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid '%v' value %q", key, val)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,37 @@
+package netio
+
+import (
+	"testing"
+)
+
+func TestSetObjectOptions(t *testing.T) {
+
+	info := FileInfo{
+		ContentType:  "video/mp4",
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"Owner": "media", "Origin": "camera"},
+	}
+
+	opt := map[string]string{"cacheControl": "max-age=60", "meta-owner": "ops"}
+	setObjectOptions(opt, info)
+
+	expected := map[string]string{
+		"contentType":  "video/mp4",
+		"cacheControl": "max-age=60",
+		"meta-owner":   "ops",
+		"meta-origin":  "camera",
+	}
+	if len(opt) != len(expected) {
+		t.Fatalf("expected options %v but got %v", expected, opt)
+	}
+	for key, val := range expected {
+		if opt[key] != val {
+			t.Fatalf("expected option %v to be %q but got %q", key, val, opt[key])
+		}
+	}
+
+	metadata := metadataOptions(opt)
+	if len(metadata) != 2 || metadata["owner"] != "ops" || metadata["origin"] != "camera" {
+		t.Fatalf("unexpected metadata %v", metadata)
+	}
+}
@@ -0,0 +1,57 @@
+package netio
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressLogInterval limits the progress records of a part read
+// (debug level) to one per interval
+const ProgressLogInterval = 5 * time.Second
+
+// ProgressFunc receives the number of bytes delivered to the destination
+// so far and the total number of bytes. It may be called concurrently.
+type ProgressFunc func(bytesDone int64, bytesTotal int64)
+
+type progressKey struct{}
+type progressTrackerKey struct{}
+
+type progressTracker struct {
+	done   int64 // first to be 64-bit aligned for atomic access
+	total  int64
+	fn     ProgressFunc
+	parent *progressTracker
+}
+
+// WithProgress returns a copy of ctx that makes Transfer report
+// its progress to fn as the data gets uploaded
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// withProgressTracker sets up the progress accounting for a single
+// transfer of total bytes. Within a directory transfer the bytes are
+// accounted to the directory as well and only its progress is reported.
+func withProgressTracker(ctx context.Context, total int64) context.Context {
+	if parent, ok := ctx.Value(progressTrackerKey{}).(*progressTracker); ok {
+		return context.WithValue(ctx, progressTrackerKey{}, &progressTracker{total: total, parent: parent})
+	}
+	fn, ok := ctx.Value(progressKey{}).(ProgressFunc)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, progressTrackerKey{}, &progressTracker{fn: fn, total: total})
+}
+
+// reportProgress accounts n more bytes uploaded
+func reportProgress(ctx context.Context, n int64) {
+	t, ok := ctx.Value(progressTrackerKey{}).(*progressTracker)
+	for ok && t != nil {
+		done := atomic.AddInt64(&t.done, n)
+		if t.fn != nil {
+			t.fn(done, t.total)
+		}
+		t = t.parent
+	}
+}
@@ -0,0 +1,102 @@
+package netio
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestProgressReported(t *testing.T) {
+
+	var m sync.Mutex
+	var calls int
+	var done, total int64
+
+	ctx := WithProgress(context.Background(), func(bytesDone int64, bytesTotal int64) {
+		m.Lock()
+		defer m.Unlock()
+		calls++
+		if bytesDone > done {
+			done = bytesDone
+		}
+		total = bytesTotal
+	})
+	ctx = withProgressTracker(ctx, 300)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reportProgress(ctx, 100)
+		}()
+	}
+	wg.Wait()
+
+	if calls != 3 {
+		t.Fatalf("expected progress to be reported %v times but got %v", 3, calls)
+	}
+	if done != 300 || total != 300 {
+		t.Fatalf("expected progress to be %v of %v but got %v of %v", 300, 300, done, total)
+	}
+}
+
+func TestProgressNotRequested(t *testing.T) {
+	// must not panic without WithProgress
+	ctx := withProgressTracker(context.Background(), 100)
+	reportProgress(ctx, 100)
+}
+
+func TestConcurrentUploaderReportsProgress(t *testing.T) {
+
+	const size = 256 * 1024
+
+	uploader, err := getUploader("s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var done int64
+	ctx := WithProgress(context.Background(), func(bytesDone int64, bytesTotal int64) {
+		done = bytesDone
+	})
+	ctx = withProgressTracker(ctx, size)
+
+	dtx := make(chan dlData)
+	go func() {
+		dtx <- dlData{data: make([]byte, size), done: true, br: size, total: size}
+		close(dtx)
+	}()
+
+	if err := uploader.Upload(ctx, "s3://amazon.aws.com/bucket/key.mp4", map[string]string{}, dtx, &mockSender{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if done != size {
+		t.Fatalf("expected %v bytes progress but got %v", size, done)
+	}
+}
+
+func TestNestedProgressReported(t *testing.T) {
+
+	var m sync.Mutex
+	var done, total int64
+
+	ctx := WithProgress(context.Background(), func(bytesDone int64, bytesTotal int64) {
+		m.Lock()
+		defer m.Unlock()
+		done = bytesDone
+		total = bytesTotal
+	})
+	ctx = withProgressTracker(ctx, 300) // directory
+
+	file1 := withProgressTracker(ctx, 100)
+	file2 := withProgressTracker(ctx, 200)
+	reportProgress(file1, 100)
+	reportProgress(file2, 50)
+
+	// only the directory progress is reported
+	if done != 150 || total != 300 {
+		t.Fatalf("expected progress to be %v of %v but got %v of %v", 150, 300, done, total)
+	}
+}
@@ -0,0 +1,132 @@
+package netio
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the bytes read from the sources by the transfers
+// sharing it. It lets through up to a second worth of bytes at once.
+// The limit can be changed while the transfers are running.
+type RateLimiter struct {
+	m       sync.Mutex
+	limit   int64 // bytes per second, 0 is unlimited
+	tokens  float64
+	last    time.Time
+	changed chan struct{} // closed when the limit changes
+}
+
+// NewRateLimiter returns a limiter letting through limit bytes
+// per second; the limit of 0 or less is unlimited
+func NewRateLimiter(limit int64) *RateLimiter {
+	l := &RateLimiter{changed: make(chan struct{})}
+	l.SetLimit(limit)
+	return l
+}
+
+// SetLimit changes the limit; the transfers waiting
+// for the old one go on with the new one at once
+func (l *RateLimiter) SetLimit(limit int64) {
+	if limit < 0 {
+		limit = 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.refill(time.Now())
+	l.limit = limit
+	if l.tokens > float64(limit) {
+		l.tokens = float64(limit)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Limit returns the limit in bytes per second, 0 if unlimited
+func (l *RateLimiter) Limit() int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.limit
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.limit)
+		if l.tokens > float64(l.limit) {
+			l.tokens = float64(l.limit)
+		}
+	}
+	l.last = now
+}
+
+// take waits until it can let through some of n bytes and returns how many
+func (l *RateLimiter) take(ctx context.Context, n int) (int, error) {
+	for {
+		l.m.Lock()
+		if l.limit == 0 {
+			l.m.Unlock()
+			return n, nil
+		}
+		l.refill(time.Now())
+		want := float64(n)
+		if want > float64(l.limit) {
+			want = float64(l.limit)
+		}
+		if l.tokens >= want {
+			l.tokens -= want
+			l.m.Unlock()
+			return int(want), nil
+		}
+		wait := time.Duration((want - l.tokens) / float64(l.limit) * float64(time.Second))
+		changed := l.changed
+		l.m.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		}
+	}
+}
+
+type rateLimiterKey struct{}
+
+// WithRateLimit returns a copy of ctx that makes Transfer
+// and TransferDirectory read the sources no faster than l lets
+func WithRateLimit(ctx context.Context, l *RateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, l)
+}
+
+func rateLimiterFromContext(ctx context.Context) *RateLimiter {
+	l, _ := ctx.Value(rateLimiterKey{}).(*RateLimiter)
+	return l
+}
+
+// limitedWriteSeeker holds up the writes of the downloaded data
+// the limiter doesn't let through yet
+type limitedWriteSeeker struct {
+	io.WriteSeeker
+	ctx     context.Context
+	limiter *RateLimiter
+}
+
+func (w *limitedWriteSeeker) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := w.limiter.take(w.ctx, len(p)-written)
+		if err != nil {
+			return written, err
+		}
+		n, err = w.WriteSeeker.Write(p[written : written+n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,68 @@
+package netio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedTransfer(t *testing.T) {
+
+	content := strings.Repeat("0123456789", 30000)
+	dir := newTestDir(t, map[string]string{"src.txt": content})
+	defer os.RemoveAll(dir)
+
+	// 300 KB at 1 MB/s from the empty bucket
+	ctx := WithRateLimit(context.Background(), NewRateLimiter(1000000))
+	begin := time.Now()
+	if _, err := Transfer(ctx, "file://"+dir+"/src.txt", "file://"+dir+"/dst.txt", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed < 250*time.Millisecond {
+		t.Fatalf("expected the transfer to take about 300ms but it took %v", elapsed)
+	}
+
+	copied, err := ioutil.ReadFile(dir + "/dst.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(copied) != content {
+		t.Fatalf("expected the content to be copied intact")
+	}
+}
+
+func TestRateLimiterChange(t *testing.T) {
+
+	l := NewRateLimiter(10)
+
+	taken := make(chan int)
+	go func() {
+		n, err := l.take(context.Background(), 1000)
+		if err != nil {
+			t.Error(err)
+		}
+		taken <- n
+	}()
+
+	// lifting the limit lets the waiting read through at once
+	time.Sleep(50 * time.Millisecond)
+	l.SetLimit(0)
+	select {
+	case n := <-taken:
+		if n != 1000 {
+			t.Fatalf("expected all 1000 bytes to be let through but got %v", n)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("expected the read to go on without the limit")
+	}
+
+	l.SetLimit(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.take(ctx, 1000); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait to end with the context but got %v", err)
+	}
+}
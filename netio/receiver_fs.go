@@ -0,0 +1,144 @@
+package netio
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LocalFileReceiver reads files from the local file system
+type LocalFileReceiver struct {
+	m   sync.Mutex
+	ptr *os.File
+}
+
+func (r *LocalFileReceiver) GetFileInfo(ctx context.Context, uri string, options map[string]string) (FileInfo, error) {
+
+	var info FileInfo
+
+	filePath, err := localPath(uri)
+	if err != nil {
+		return info, err
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return info, fileError(err)
+	}
+	if !stat.Mode().IsRegular() {
+		return info, fmt.Errorf("%v is not a regular file", filePath)
+	}
+
+	return localFileInfo(filePath, stat, options)
+}
+
+// localFileInfo describes the local file. The ETag (MD5 hex digest like S3 has
+// for the objects uploaded at once) is only calculated for etag sync comparison
+// as it takes reading the whole file.
+func localFileInfo(filePath string, stat os.FileInfo, options map[string]string) (FileInfo, error) {
+
+	info := FileInfo{
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+		ContentType: mime.TypeByExtension(filepath.Ext(filePath)),
+	}
+
+	envelope, err := readEnvelopeFile(filePath)
+	if err != nil {
+		return info, err
+	}
+	info.Metadata = envelope
+
+	if options["sync"] == "true" && options["syncCompare"] == SyncCompareETag {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return info, err
+		}
+		defer file.Close()
+		h := md5.New()
+		if _, err := io.Copy(h, file); err != nil {
+			return info, err
+		}
+		info.ETag = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return info, nil
+}
+
+func (r *LocalFileReceiver) OpenWithContext(ctx context.Context, uri string, opt map[string]string) error {
+
+	filePath, err := localPath(uri)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fileError(err)
+	}
+
+	r.m.Lock()
+	r.ptr = file
+	r.m.Unlock()
+
+	return nil
+}
+
+func (r *LocalFileReceiver) IsOpen() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.ptr != nil
+}
+
+func (r *LocalFileReceiver) ReadPartWithContext(ctx context.Context, output io.WriteSeeker,
+	opt map[string]string) (string, error) {
+
+	r.m.Lock()
+	file := r.ptr
+	r.m.Unlock()
+
+	if file == nil {
+		return "", fmt.Errorf("file is not open")
+	}
+	defer r.CloseWithContext(ctx)
+
+	buffer := make([]byte, 256*1024)
+	for {
+		br, err := file.Read(buffer)
+		if br > 0 {
+			if _, err := output.Write(buffer[:br]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF { // done reading
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (r *LocalFileReceiver) CancelWithContext(ctx context.Context) error {
+	return r.CloseWithContext(ctx)
+}
+
+func (r *LocalFileReceiver) CloseWithContext(ctx context.Context) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.ptr == nil {
+		return nil
+	}
+	err := r.ptr.Close()
+	r.ptr = nil
+	return err
+}
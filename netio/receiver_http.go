@@ -4,9 +4,10 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"github.com/viktorburka/octopus/logging"
 	"io"
-	"log"
 	"net/http"
+	"strings"
 	"sync"
 )
 
@@ -20,7 +21,7 @@ func (r *HttpReceiver) OpenWithContext(ctx context.Context, uri string, opt map[
 	r.m.Lock()
 	defer r.m.Unlock()
 	r.uri    = uri
-	r.client = &http.Client{}
+	r.client = newHttpClient()
 	return nil
 }
 
@@ -41,12 +42,24 @@ func (r *HttpReceiver) ReadPartWithContext(ctx context.Context, output io.WriteS
 	if err != nil {
 		return "", err
 	}
+
+	// ranged read is expected to get 206 Partial Content
+	expected := http.StatusOK
+	if rangeStart, ok := opt["rangeStart"]; ok {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", rangeStart, opt["rangeEnd"]))
+		expected = http.StatusPartialContent
+	}
+
 	resp, err := r.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return "", err
+		return "", requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
+	if err := statusError(resp.StatusCode, expected); err != nil {
+		return "", fmt.Errorf("can't download %v: %w", uri, err)
+	}
+
 	if resp.ContentLength == -1 { // ContentLength unknown
 		err := fmt.Errorf("can't start download: ContentLength unknown")
 		return "", err
@@ -54,18 +67,24 @@ func (r *HttpReceiver) ReadPartWithContext(ctx context.Context, output io.WriteS
 
 	var totalBytesRead int64
 
+	logger := logging.FromContext(ctx)
+	progress := logging.NewLimiter(ProgressLogInterval)
+
 	// read data
 	reader := bufio.NewReader(resp.Body)
 	buffer := make([]byte, 256*1024)
 	for {
-		log.Println("downloader: reading data...")
 		br, err := reader.Read(buffer)
 		if err != nil && err != io.EOF { // its an error (io.EOF is fine)
 			return "", err
 		}
 		totalBytesRead += int64(br)
-		log.Printf("downloader: received %v bytes\n", totalBytesRead)
-		output.Write(buffer[:br])
+		if progress.Allow() {
+			logger.Debug("receiving", "bytes", totalBytesRead, "total", resp.ContentLength)
+		}
+		if _, err := output.Write(buffer[:br]); err != nil {
+			return "", err
+		}
 		if err == io.EOF { // done reading
 			//close(data)
 			break
@@ -88,15 +107,48 @@ func (r *HttpReceiver) CloseWithContext(ctx context.Context) error {
 
 func (r *HttpReceiver) GetFileInfo(ctx context.Context, uri string, options map[string]string) (FileInfo, error) {
 	var info FileInfo
-	client := &http.Client{} //TODO: might also instantiate it once
+	client := newHttpClient() //TODO: might also instantiate it once
 	req, err := http.NewRequest("HEAD", uri, nil)
 	if err != nil {
 		return info, err
 	}
 	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return info, err
+		return info, requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp.StatusCode, http.StatusOK); err != nil {
+		return info, fmt.Errorf("can't probe %v: %w", uri, err)
 	}
+	// the parts of the download are planned by the size
+	if resp.ContentLength < 0 {
+		return info, fmt.Errorf("can't probe %v: Content-Length unknown", uri)
+	}
+	info.StatusCode = resp.StatusCode
+	info.Header = resp.Header
 	info.Size = resp.ContentLength
+	info.ETag = resp.Header.Get("ETag")
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	info.ContentType = resp.Header.Get("Content-Type")
+	info.CacheControl = resp.Header.Get("Cache-Control")
+	info.Metadata = headerMetadata(resp.Header)
 	return info, nil
 }
+
+// headerMetadata collects user metadata of the files served
+// by S3 compatible storage (e.g. with presigned urls)
+func headerMetadata(header http.Header) map[string]string {
+	var metadata map[string]string
+	for name := range header {
+		if !strings.HasPrefix(strings.ToLower(name), amzMetaPrefix) {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[name[len(amzMetaPrefix):]] = header.Get(name)
+	}
+	return metadata
+}
@@ -1,9 +1,14 @@
 package netio
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestHttpReceiverOpen(t *testing.T) {
@@ -35,6 +40,139 @@ func TestHttpReceiverGetFileInfo(t *testing.T) {
 	}
 }
 
+func TestHttpReceiverGetFileInfoHeaders(t *testing.T) {
+
+	modTime := time.Date(2019, 4, 10, 12, 0, 0, 0, time.UTC)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		w.Header().Set("X-Amz-Meta-Owner", "media")
+		w.Header().Set("Content-Length", "5")
+	}))
+	defer srv.Close()
+
+	rcv := &HttpReceiver{}
+	info, err := rcv.GetFileInfo(context.Background(), srv.URL+"/key.mp4", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.StatusCode != http.StatusOK || info.Size != 5 || info.ETag != `"abc"` || !info.ModTime.Equal(modTime) {
+		t.Fatalf("unexpected file info %+v", info)
+	}
+	if info.ContentType != "video/mp4" || info.CacheControl != "max-age=3600" || info.Metadata["Owner"] != "media" {
+		t.Fatalf("unexpected file attributes %+v", info)
+	}
+	if info.Header.Get("X-Amz-Meta-Owner") != "media" {
+		t.Fatalf("expected response headers but got %v", info.Header)
+	}
+}
+
+func TestHttpReceiverUnknownLength(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Transfer-Encoding", "chunked")
+	}))
+	defer srv.Close()
+
+	rcv := &HttpReceiver{}
+	if info, err := rcv.GetFileInfo(context.Background(), srv.URL+"/stream.mp4", map[string]string{}); err == nil {
+		t.Fatalf("expected GetFileInfo() to fail without Content-Length but got %+v", info)
+	}
+}
+
+func TestHttpReceiverWriteError(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	rcv := &HttpReceiver{}
+	ctx := context.Background()
+	opt := map[string]string{}
+
+	if err := rcv.OpenWithContext(ctx, srv.URL, opt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rcv.ReadPartWithContext(ctx, &mockWriteSeeker{}, opt); err == nil {
+		t.Fatalf("expected ReadPartWithContext() to fail when the output can't be written")
+	}
+}
+
+func TestHttpReceiverErrorStatus(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/private.mp4":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case "/busy.mp4":
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	rcv := &HttpReceiver{}
+	ctx := context.Background()
+	opt := map[string]string{}
+
+	if _, err := rcv.GetFileInfo(ctx, srv.URL+"/missing.mp4", opt); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+	if _, err := rcv.GetFileInfo(ctx, srv.URL+"/private.mp4", opt); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected ErrAccessDenied but got %v", err)
+	}
+
+	if err := rcv.OpenWithContext(ctx, srv.URL+"/busy.mp4", opt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rcv.ReadPartWithContext(ctx, &seekBuffer{}, opt); !IsTemporary(err) {
+		t.Fatalf("expected temporary error but got %v", err)
+	}
+}
+
+func TestHttpReceiverRangedRead(t *testing.T) {
+
+	content := []byte("0123456789")
+	ignoreRange := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ignoreRange {
+			w.Write(content)
+			return
+		}
+		http.ServeContent(w, r, "file.txt", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	rcv := &HttpReceiver{}
+	ctx := context.Background()
+	opt := map[string]string{"rangeStart": "2", "rangeEnd": "5"}
+
+	if err := rcv.OpenWithContext(ctx, srv.URL, opt); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &seekBuffer{}
+	if _, err := rcv.ReadPartWithContext(ctx, out, opt); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "2345" {
+		t.Fatalf("expected range 2-5 but got %q", out.String())
+	}
+
+	// the whole file in response to ranged request is an error
+	ignoreRange = true
+	if _, err := rcv.ReadPartWithContext(ctx, &seekBuffer{}, opt); err == nil {
+		t.Fatalf("expected ReadPartWithContext() to fail on 200 response to ranged request")
+	}
+}
+
 func TestHttpReceiverReadPartWithContext(t *testing.T) {
 
 	rcv := &HttpReceiver{}
@@ -87,3 +225,11 @@ func (ws *mockWriteSeeker) Write(p []byte) (n int, err error) {
 func (ws *mockWriteSeeker) Seek(offset int64, whence int) (int64, error) {
 	return -1, fmt.Errorf("not implemented")
 }
+
+type seekBuffer struct {
+	bytes.Buffer
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	return -1, fmt.Errorf("not implemented")
+}
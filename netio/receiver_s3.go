@@ -4,15 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/viktorburka/octopus/logging"
 	"io"
-	"log"
-	"net/url"
 	"strconv"
-	"strings"
 	"sync"
-	"time"
 )
 
 type S3ReceiverRanged struct {
@@ -26,84 +22,51 @@ type S3ReceiverRanged struct {
 func (r *S3ReceiverRanged) GetFileInfo(ctx context.Context, uri string, options map[string]string) (FileInfo, error) {
 
 	var info FileInfo
-	var bucket string
-	var keyName string
 
-	downloadUrl, err := url.Parse(uri)
+	bucket, keyName, err := s3Location(uri, options)
 	if err != nil {
 		return info, err
 	}
 
-	style, ok := options["bucketNameStyle"]
-	if !ok {
-		// set 'path-style' bucket name by default
-		// see https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html#access-bucket-intro
-		style = "path-style"
-	}
-
-	if style == "path-style" {
-		idx := strings.Index(downloadUrl.Path, "/")
-		bucket = downloadUrl.Path[:idx]
-		keyName = downloadUrl.Path[idx+1:]
-	} else {
-		hostname := downloadUrl.Hostname()
-		idx := strings.Index(hostname, ".")
-		bucket = hostname[:idx]
-		keyName = downloadUrl.Path[1:] // skip first '/' char
-	}
-
-	sess, err := session.NewSession()
+	s3client, err := newS3Client(options)
 	if err != nil {
 		return info, err
 	}
 
-	s3client := s3.New(sess)
 	hr, err := s3client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(keyName),
 	})
 	if err != nil {
-		return info, err
+		return info, s3Error(ctx, err)
 	}
 
 	info.Size = *hr.ContentLength
+	info.ETag = aws.StringValue(hr.ETag)
+	info.ModTime = aws.TimeValue(hr.LastModified)
+	info.ContentType = aws.StringValue(hr.ContentType)
+	info.CacheControl = aws.StringValue(hr.CacheControl)
+	info.Metadata = aws.StringValueMap(hr.Metadata)
+	info.VersionId = aws.StringValue(hr.VersionId)
 
 	return info, nil
 }
 
 func (r *S3ReceiverRanged) OpenWithContext(ctx context.Context, uri string, opt map[string]string) error {
 
-	downloadUrl, err := url.Parse(uri)
+	bucket, key, err := s3Location(uri, opt)
 	if err != nil {
 		return err
 	}
 
-	r.m.Lock()
-	style, ok := opt["bucketNameStyle"]
-	if !ok {
-		// set 'path-style' bucket name by default
-		// see https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html#access-bucket-intro
-		style = "path-style"
-	}
-	if style == "path-style" {
-		idx := strings.Index(downloadUrl.Path, "/")
-		r.bkt = downloadUrl.Path[:idx]
-		r.key = downloadUrl.Path[idx+1:]
-	} else {
-		hostname := downloadUrl.Hostname()
-		idx := strings.Index(hostname, ".")
-		r.bkt = hostname[:idx]
-		r.key = downloadUrl.Path[1:] // skip first '/' char
-	}
-	r.m.Unlock()
-
-	sess, err := session.NewSession()
+	c, err := newS3Client(opt)
 	if err != nil {
 		return err
 	}
 
-	c := s3.New(sess)
 	r.m.Lock()
+	r.bkt = bucket
+	r.key = key
 	r.s3client = c
 	r.m.Unlock()
 
@@ -134,21 +97,22 @@ func (r *S3ReceiverRanged) ReadPartWithContext(ctx context.Context, output io.Wr
 	key    := r.key
 	r.m.Unlock()
 
-	log.Printf("GetObjectWithContext for range '%v'\n", rg)
+	logger := logging.FromContext(ctx)
+	logger.Debug("getting object range", "bucket", bucket, "key", key, "range", rg)
 	result, err := r.s3client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 		Range:  aws.String(rg),
 	})
 	if err != nil {
-		return "", err
+		return "", s3Error(ctx, err)
 	}
 	defer result.Body.Close()
 
 	bsaved := int64(0)
 	buffer := make([]byte, partSize)
 
-	now := time.Now().UTC()
+	progress := logging.NewLimiter(ProgressLogInterval)
 
 	for {
 		br, err := result.Body.Read(buffer)
@@ -169,20 +133,18 @@ func (r *S3ReceiverRanged) ReadPartWithContext(ctx context.Context, output io.Wr
 		if err == io.EOF { // done reading
 			break
 		}
-		// print bytes downloaded every second
-		// to do not clutter the log file
-		if time.Since(now) > 1 * time.Second {
-			now = time.Now().UTC()
-			log.Printf("downloaded %v bytes\n", bsaved)
+		// to not clutter the log
+		if progress.Allow() {
+			logger.Debug("receiving", "bytes", bsaved, "range", rg)
 		}
 	}
 
-	log.Printf("downloaded %v bytes\n", bsaved)
+	logger.Debug("received range", "bytes", bsaved, "range", rg)
 
 	// making sure all bytes have been read
 	if bsaved != *result.ContentLength {
-		return "", fmt.Errorf("incomplete read operation. extected %v but received %v",
-			bsaved, *result.ContentLength)
+		return "", &Error{Kind: ErrIntegrity, Err: fmt.Errorf("incomplete read operation. extected %v but received %v",
+			bsaved, *result.ContentLength)}
 	}
 
 	// done reading - close response body
@@ -0,0 +1,22 @@
+package netio
+
+import (
+	"context"
+	"fmt"
+)
+
+// remover deletes destination files for sync with syncDelete option
+type remover interface {
+	Remove(ctx context.Context, uri string, options map[string]string) error
+}
+
+func getRemover(scheme string) (remover, error) {
+	switch scheme {
+	case "s3":
+		return S3Remover{}, nil
+	case "file":
+		return LocalFileRemover{}, nil
+	default:
+		return nil, fmt.Errorf("remove scheme %v is not supported", scheme)
+	}
+}
@@ -0,0 +1,19 @@
+package netio
+
+import (
+	"context"
+	"os"
+)
+
+type LocalFileRemover struct {
+}
+
+func (r LocalFileRemover) Remove(ctx context.Context, uri string, options map[string]string) error {
+
+	filePath, err := localPath(uri)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filePath)
+}
@@ -0,0 +1,30 @@
+package netio
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type S3Remover struct {
+}
+
+func (r S3Remover) Remove(ctx context.Context, uri string, options map[string]string) error {
+
+	bucket, key, err := s3Location(uri, options)
+	if err != nil {
+		return err
+	}
+
+	s3client, err := newS3Client(options)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+
+	return s3Error(ctx, err)
+}
@@ -0,0 +1,169 @@
+package netio
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sync"
+	"time"
+)
+
+// Transfer strategies
+const (
+	// single stream download and upload
+	StrategySimple = "simple"
+	// ranged download or multipart upload
+	StrategyConcurrent = "concurrent"
+)
+
+// Result sums up the transfer
+type Result struct {
+	Strategy       string
+	Bytes          int64 // bytes delivered to the destination
+	Duration       time.Duration
+	Throughput     int64             // average bytes per second
+	PeakThroughput int64             // bytes per second during the fastest second
+	Parts          int               // parts uploaded (1 unless multipart upload)
+	Retries        int               // requests retried by the S3 client
+	ETag           string            // destination ETag if the storage provides it
+	VersionId      string            // destination version if versioning is enabled
+	Checksums      map[string]string // hex digests of the data by algorithm (md5, sha256)
+	UpToDate       bool              // sync found nothing to transfer
+}
+
+type transferStatsKey struct{}
+
+// transferStats collects what the uploaders and senders know
+// about the transfer; they find it in the context
+type transferStats struct {
+	m         sync.Mutex
+	parts     int
+	retries   int
+	etag      string
+	versionId string
+}
+
+func withTransferStats(ctx context.Context) (context.Context, *transferStats) {
+	stats := &transferStats{}
+	return context.WithValue(ctx, transferStatsKey{}, stats), stats
+}
+
+func statsFrom(ctx context.Context) *transferStats {
+	stats, _ := ctx.Value(transferStatsKey{}).(*transferStats)
+	return stats
+}
+
+// recordPart accounts one more part uploaded
+func recordPart(ctx context.Context) {
+	if stats := statsFrom(ctx); stats != nil {
+		stats.m.Lock()
+		stats.parts++
+		stats.m.Unlock()
+	}
+}
+
+// recordRetry accounts one more request retried
+func recordRetry(ctx context.Context) {
+	if stats := statsFrom(ctx); stats != nil {
+		stats.m.Lock()
+		stats.retries++
+		stats.m.Unlock()
+	}
+}
+
+// recordObject keeps the ETag and version of the uploaded object
+func recordObject(ctx context.Context, etag string, versionId string) {
+	if stats := statsFrom(ctx); stats != nil {
+		stats.m.Lock()
+		stats.etag = etag
+		stats.versionId = versionId
+		stats.m.Unlock()
+	}
+}
+
+// fill copies the collected stats to the result
+func (s *transferStats) fill(r *Result) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r.Parts = s.parts
+	r.Retries = s.retries
+	r.ETag = s.etag
+	r.VersionId = s.versionId
+}
+
+// throughput measures the average and the peak data rate
+type throughput struct {
+	begin       time.Time
+	window      time.Time // current one second window start
+	windowBytes int64
+	total       int64
+	peak        int64
+}
+
+func newThroughput(now time.Time) *throughput {
+	return &throughput{begin: now, window: now}
+}
+
+func (t *throughput) add(n int64, now time.Time) {
+	t.total += n
+	t.windowBytes += n
+	if elapsed := now.Sub(t.window); elapsed >= time.Second {
+		if rate := int64(float64(t.windowBytes) / elapsed.Seconds()); rate > t.peak {
+			t.peak = rate
+		}
+		t.window = now
+		t.windowBytes = 0
+	}
+}
+
+// rates returns the average and the peak rate. The peak of
+// the transfers shorter than a second is the average one.
+func (t *throughput) rates(now time.Time) (int64, int64) {
+	var avg int64
+	if elapsed := now.Sub(t.begin); elapsed > 0 {
+		avg = int64(float64(t.total) / elapsed.Seconds())
+	}
+	if t.peak < avg {
+		return avg, avg
+	}
+	return avg, t.peak
+}
+
+// measureStage is the pipeline stage between the downloader and the
+// uploader. It calculates the checksums and the throughput of the data
+// passing through and closes out when in is closed. It leaves out open
+// if ctx is done for the uploader to fail rather than to finish.
+func measureStage(ctx context.Context, in <-chan dlData, out chan<- dlData, r *Result) {
+
+	hashes := map[string]hash.Hash{"md5": md5.New(), "sha256": sha256.New()}
+	rate := newThroughput(time.Now())
+
+	for {
+		select {
+		case chunk, ok := <-in:
+			if !ok {
+				r.Bytes = rate.total
+				r.Throughput, r.PeakThroughput = rate.rates(time.Now())
+				r.Checksums = make(map[string]string, len(hashes))
+				for name, h := range hashes {
+					r.Checksums[name] = hex.EncodeToString(h.Sum(nil))
+				}
+				close(out)
+				return
+			}
+			for _, h := range hashes {
+				h.Write(chunk.data)
+			}
+			rate.add(int64(len(chunk.data)), time.Now())
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package netio
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestThroughput(t *testing.T) {
+
+	begin := time.Now()
+	rate := newThroughput(begin)
+
+	rate.add(100, begin.Add(500*time.Millisecond))
+	rate.add(100, begin.Add(time.Second)) // 200 in the first second
+	rate.add(400, begin.Add(2*time.Second))
+
+	avg, peak := rate.rates(begin.Add(2 * time.Second))
+	if avg != 300 || peak != 400 {
+		t.Fatalf("expected 300 average and 400 peak but got %v and %v", avg, peak)
+	}
+
+	// shorter than a second
+	rate = newThroughput(begin)
+	rate.add(100, begin.Add(500*time.Millisecond))
+	avg, peak = rate.rates(begin.Add(500 * time.Millisecond))
+	if avg != 200 || peak != 200 {
+		t.Fatalf("expected 200 average and peak but got %v and %v", avg, peak)
+	}
+}
+
+func TestLocalTransferResult(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer os.RemoveAll(dir)
+
+	result, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "file://"+dir+"/dst.txt", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Strategy != StrategySimple || result.Bytes != 7 || result.Parts != 1 || result.UpToDate {
+		t.Fatalf("expected 7 bytes uploaded in 1 part but got %+v", result)
+	}
+	if md5 := result.Checksums["md5"]; md5 != "9a0364b9e99bb480dd25e1f0284c8555" {
+		t.Fatalf("expected md5 checksum of the content but got %q", md5)
+	}
+	if sha := result.Checksums["sha256"]; sha != "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73" {
+		t.Fatalf("expected sha256 checksum of the content but got %q", sha)
+	}
+	checkTestDir(t, dir, map[string]string{"src.txt": "content", "dst.txt": "content"})
+}
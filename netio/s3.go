@@ -0,0 +1,149 @@
+package netio
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	ChecksumNone = "none"
+	ChecksumMD5  = "md5"
+)
+
+// user metadata header prefix
+const amzMetaPrefix = "x-amz-meta-"
+
+// s3ObjectAttributes are set on the uploaded object
+type s3ObjectAttributes struct {
+	contentType  *string
+	cacheControl *string
+	metadata     map[string]*string
+}
+
+func newS3ObjectAttributes(opt map[string]string) s3ObjectAttributes {
+	var attrs s3ObjectAttributes
+	if val := opt["contentType"]; val != "" {
+		attrs.contentType = aws.String(val)
+	}
+	if val := opt["cacheControl"]; val != "" {
+		attrs.cacheControl = aws.String(val)
+	}
+	if metadata := metadataOptions(opt); len(metadata) > 0 {
+		attrs.metadata = aws.StringMap(metadata)
+	}
+	return attrs
+}
+
+// newS3Client creates S3 client configured by the transfer options
+// awsRegion, awsEndpoint, awsAccessKeyId, awsSecretAccessKey and
+// awsSessionToken. The options not given are taken from the default
+// AWS configuration (environment, shared config files, instance role).
+func newS3Client(opt map[string]string) (*s3.S3, error) {
+
+	cfg := aws.NewConfig()
+
+	if region := opt["awsRegion"]; region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+	if endpoint := opt["awsEndpoint"]; endpoint != "" {
+		// S3 compatible storages rarely support virtual hosted buckets
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(bucketNameStyle(opt) == "path-style")
+	}
+	if keyId := opt["awsAccessKeyId"]; keyId != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(
+			keyId, opt["awsSecretAccessKey"], opt["awsSessionToken"]))
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.New(sess)
+	traceS3Requests(&client.Handlers)
+	// every attempt after the first one is a retry
+	client.Handlers.Send.PushFront(func(r *request.Request) {
+		if r.RetryCount > 0 {
+			recordRetry(r.Context())
+			requestRetries.With("s3").Inc()
+		}
+	})
+
+	return client, nil
+}
+
+func bucketNameStyle(opt map[string]string) string {
+	style, ok := opt["bucketNameStyle"]
+	if !ok {
+		// set 'path-style' bucket name by default
+		// see https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html#access-bucket-intro
+		style = "path-style"
+	}
+	return style
+}
+
+// s3Location extracts bucket name and object key from the url
+// according to the bucketNameStyle option:
+//
+//	path-style:   s3://s3.amazonaws.com/bucket/key
+//	virtual-host: s3://bucket.s3.amazonaws.com/key
+func s3Location(uri string, opt map[string]string) (bucket string, key string, err error) {
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+
+	if bucketNameStyle(opt) == "path-style" {
+		idx := strings.Index(path, "/")
+		if idx < 0 {
+			return path, "", nil
+		}
+		return path[:idx], path[idx+1:], nil
+	}
+
+	hostname := u.Hostname()
+	idx := strings.Index(hostname, ".")
+	if idx < 0 {
+		return hostname, path, nil
+	}
+	return hostname[:idx], path, nil
+}
+
+// checksumMode validates the checksum option
+func checksumMode(opt map[string]string) (string, error) {
+	switch mode := opt["checksum"]; mode {
+	case "", ChecksumNone:
+		return ChecksumNone, nil
+	case ChecksumMD5:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("checksum mode %q is not supported", mode)
+	}
+}
+
+// contentMD5 returns base64 encoded MD5 digest of the input for
+// Content-MD5 header and rewinds the input to the beginning
+func contentMD5(input io.ReadSeeker) (string, error) {
+
+	h := md5.New()
+	if _, err := io.Copy(h, input); err != nil {
+		return "", err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,72 @@
+package netio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestS3Location(t *testing.T) {
+
+	tests := []struct {
+		uri    string
+		style  string
+		bucket string
+		key    string
+	}{
+		{"s3://s3.amazonaws.com/bucket/dir/key.mp4", "path-style", "bucket", "dir/key.mp4"},
+		{"s3://s3.amazonaws.com/bucket/dir/key.mp4", "", "bucket", "dir/key.mp4"},
+		{"s3://bucket.s3.amazonaws.com/dir/key.mp4", "virtual-hosted-style", "bucket", "dir/key.mp4"},
+	}
+
+	for _, test := range tests {
+		opt := map[string]string{}
+		if test.style != "" {
+			opt["bucketNameStyle"] = test.style
+		}
+		bucket, key, err := s3Location(test.uri, opt)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %v: %v", test.uri, err)
+		}
+		if bucket != test.bucket || key != test.key {
+			t.Fatalf("expected %v to be bucket %q key %q but got %q %q",
+				test.uri, test.bucket, test.key, bucket, key)
+		}
+	}
+}
+
+func TestContentMD5(t *testing.T) {
+
+	input := bytes.NewReader([]byte("octopus"))
+
+	digest, err := contentMD5(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// echo -n octopus | openssl md5 -binary | base64
+	if expected := "/PHu2FlmmWJBZ0FqHn4SLg=="; digest != expected {
+		t.Fatalf("expected digest %v but got %v", expected, digest)
+	}
+
+	// the input has to be rewound for the upload
+	rest, _ := ioutil.ReadAll(input)
+	if string(rest) != "octopus" {
+		t.Fatalf("expected contentMD5() to rewind the input")
+	}
+}
+
+func TestChecksumMode(t *testing.T) {
+
+	if mode, err := checksumMode(map[string]string{}); err != nil || mode != ChecksumNone {
+		t.Fatalf("expected default checksum mode %v but got %v (%v)", ChecksumNone, mode, err)
+	}
+
+	if mode, err := checksumMode(map[string]string{"checksum": "md5"}); err != nil || mode != ChecksumMD5 {
+		t.Fatalf("expected checksum mode %v but got %v (%v)", ChecksumMD5, mode, err)
+	}
+
+	if _, err := checksumMode(map[string]string{"checksum": "crc64"}); err == nil {
+		t.Fatalf("expected checksumMode() to return an error for unsupported mode")
+	}
+}
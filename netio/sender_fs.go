@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 type LocalFileSender struct {
@@ -15,9 +16,15 @@ func (s *LocalFileSender) IsOpen() bool {
 }
 
 func (s *LocalFileSender) OpenWithContext(ctx context.Context, uri string, opt map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(uri), 0755); err != nil {
+		return fileError(err)
+	}
+	if err := writeEnvelopeFile(uri, opt); err != nil {
+		return err
+	}
 	var err error
 	s.ptr, err = os.Create(uri)
-	return err
+	return fileError(err)
 }
 
 func (s *LocalFileSender) WritePartWithContext(ctx context.Context, input io.ReadSeeker,
@@ -25,7 +32,7 @@ func (s *LocalFileSender) WritePartWithContext(ctx context.Context, input io.Rea
 
 	var err error
 	_, err = io.Copy(s.ptr, input)
-	return "", err
+	return "", fileError(err)
 }
 
 func (s *LocalFileSender) CancelWithContext(ctx context.Context) error {
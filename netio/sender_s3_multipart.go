@@ -4,13 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"io"
-	"net/url"
 	"sort"
 	"strconv"
-	"strings"
 	"sync"
 )
 
@@ -21,54 +18,48 @@ type S3SenderMultipart struct {
 	mpu      *s3.CreateMultipartUploadOutput
 	s3client *s3.S3
 	etags  []*s3.CompletedPart
+	checksum string
 }
 
 func (s *S3SenderMultipart) OpenWithContext(ctx context.Context, uri string, opt map[string]string) error {
 
-	uploadUrl, err := url.Parse(uri)
+	bucket, key, err := s3Location(uri, opt)
 	if err != nil {
 		return err
 	}
 
-	s.m.Lock()
-	s.etags = make([]*s3.CompletedPart, 0)
-	style, ok := opt["bucketNameStyle"]
-	if !ok {
-		// set 'path-style' bucket name by default
-		// see https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html#access-bucket-intro
-		style = "path-style"
-	}
-	if style == "path-style" {
-		idx := strings.Index(uploadUrl.Path, "/")
-		s.bkt = uploadUrl.Path[:idx]
-		s.key = uploadUrl.Path[idx+1:]
-	} else {
-		hostname := uploadUrl.Hostname()
-		idx := strings.Index(hostname, ".")
-		s.bkt = hostname[:idx]
-		s.key = uploadUrl.Path[1:] // skip first '/' char
+	checksum, err := checksumMode(opt)
+	if err != nil {
+		return err
 	}
-	s.m.Unlock()
 
-	sess, err := session.NewSession()
+	c, err := newS3Client(opt)
 	if err != nil {
 		return err
 	}
 
-	c := s3.New(sess)
 	s.m.Lock()
+	s.etags = make([]*s3.CompletedPart, 0)
+	s.bkt = bucket
+	s.key = key
+	s.checksum = checksum
 	s.s3client = c
 	s.m.Unlock()
 
+	attrs := newS3ObjectAttributes(opt)
+
 	mpuInput := &s3.CreateMultipartUploadInput{
-		Bucket: aws.String(s.bkt),
-		Key:    aws.String(s.key),
+		Bucket:       aws.String(s.bkt),
+		Key:          aws.String(s.key),
+		ContentType:  attrs.contentType,
+		CacheControl: attrs.cacheControl,
+		Metadata:     attrs.metadata,
 	}
 
 	// initiate multipart upload
 	mpu, err := s.s3client.CreateMultipartUploadWithContext(ctx, mpuInput)
 	if err != nil {
-		return err
+		return s3Error(ctx, err)
 	}
 
 	s.m.Lock()
@@ -105,17 +96,28 @@ func (s *S3SenderMultipart) WritePartWithContext(ctx context.Context, input io.R
 	bucket := s.bkt
 	key    := s.key
 	uplid  := *s.mpu.UploadId // making sure we create a copy
+	checksum := s.checksum
 	s.m.Unlock()
 
-	result, err := s.s3client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+	partInput := &s3.UploadPartInput{
 		Body:       input,
 		Bucket:     aws.String(bucket),
 		Key:        aws.String(key),
 		UploadId:   aws.String(uplid),
 		PartNumber: aws.Int64(pn),
-	})
+	}
+
+	if checksum == ChecksumMD5 {
+		digest, err := contentMD5(input)
+		if err != nil {
+			return "", err
+		}
+		partInput.ContentMD5 = aws.String(digest)
+	}
+
+	result, err := s.s3client.UploadPartWithContext(ctx, partInput)
 	if err != nil {
-		return "", err
+		return "", s3Error(ctx, err)
 	}
 
 	s.m.Lock()
@@ -169,11 +171,13 @@ func (s *S3SenderMultipart) CloseWithContext(ctx context.Context) error {
 		UploadId: s.mpu.UploadId,
 	}
 
-	_, err := s.s3client.CompleteMultipartUploadWithContext(ctx, cmpuInput)
+	result, err := s.s3client.CompleteMultipartUploadWithContext(ctx, cmpuInput)
 	if err != nil {
-		return err
+		return s3Error(ctx, err)
 	}
 
+	recordObject(ctx, aws.StringValue(result.ETag), aws.StringValue(result.VersionId))
+
 	s.mpu      = nil
 	s.s3client = nil
 	s.etags    = nil
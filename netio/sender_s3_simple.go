@@ -3,12 +3,9 @@ package netio
 import (
 	"context"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/viktorburka/octopus/logging"
 	"io"
-	"log"
-	"net/url"
-	"strings"
 	"sync"
 )
 
@@ -18,6 +15,8 @@ type S3SenderSimple struct {
 	bkt string
 	isOpen   bool
 	s3client *s3.S3
+	checksum string
+	attrs    s3ObjectAttributes
 }
 
 func (s *S3SenderSimple) IsOpen() bool {
@@ -27,37 +26,26 @@ func (s *S3SenderSimple) IsOpen() bool {
 }
 
 func (s *S3SenderSimple) OpenWithContext(ctx context.Context, uri string, opt map[string]string) error {
-	uploadUrl, err := url.Parse(uri)
+	bucket, key, err := s3Location(uri, opt)
 	if err != nil {
 		return err
 	}
 
-	s.m.Lock()
-	style, ok := opt["bucketNameStyle"]
-	if !ok {
-		// set 'path-style' bucket name by default
-		// see https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html#access-bucket-intro
-		style = "path-style"
-	}
-	if style == "path-style" {
-		idx := strings.Index(uploadUrl.Path, "/")
-		s.bkt = uploadUrl.Path[:idx]
-		s.key = uploadUrl.Path[idx+1:]
-	} else {
-		hostname := uploadUrl.Hostname()
-		idx := strings.Index(hostname, ".")
-		s.bkt = hostname[:idx]
-		s.key = uploadUrl.Path[1:] // skip first '/' char
+	checksum, err := checksumMode(opt)
+	if err != nil {
+		return err
 	}
-	s.m.Unlock()
 
-	sess, err := session.NewSession()
+	c, err := newS3Client(opt)
 	if err != nil {
 		return err
 	}
 
-	c := s3.New(sess)
 	s.m.Lock()
+	s.bkt = bucket
+	s.key = key
+	s.checksum = checksum
+	s.attrs = newS3ObjectAttributes(opt)
 	s.s3client = c
 	s.isOpen = true
 	s.m.Unlock()
@@ -71,18 +59,35 @@ func (s *S3SenderSimple) WritePartWithContext(ctx context.Context, input io.Read
 	s.m.Lock()
 	bucket := s.bkt
 	key    := s.key
+	checksum := s.checksum
+	attrs := s.attrs
 	s.m.Unlock()
 
-	log.Println("sending single chunk of data to S3 via PutObjectWithContext")
-	result, err := s.s3client.PutObjectWithContext(ctx, &s3.PutObjectInput{
-		Body:   input,
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	putInput := &s3.PutObjectInput{
+		Body:         input,
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ContentType:  attrs.contentType,
+		CacheControl: attrs.cacheControl,
+		Metadata:     attrs.metadata,
+	}
+
+	if checksum == ChecksumMD5 {
+		digest, err := contentMD5(input)
+		if err != nil {
+			return "", err
+		}
+		putInput.ContentMD5 = aws.String(digest)
+	}
+
+	logging.FromContext(ctx).Debug("putting object", "bucket", bucket, "key", key)
+	result, err := s.s3client.PutObjectWithContext(ctx, putInput)
 	if err != nil {
-		return "", err
+		return "", s3Error(ctx, err)
 	}
 
+	recordObject(ctx, aws.StringValue(result.ETag), aws.StringValue(result.VersionId))
+
 	return *result.ETag, nil
 }
 
@@ -0,0 +1,63 @@
+package netio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sync option (true) makes Transfer skip the files the destination already
+// has. syncCompare tells how the source and destination files are compared
+// (the sizes always have to match):
+const (
+	// sizes only
+	SyncCompareSize = "size"
+	// the source is not newer than the destination (default)
+	SyncCompareModTime = "mtime"
+	// ETags (MD5 digest for local files) are the same
+	SyncCompareETag = "etag"
+)
+
+// syncMode tells if sync is enabled and how to compare the files
+func syncMode(opt map[string]string) (bool, string, error) {
+
+	if opt["sync"] != "true" {
+		return false, "", nil
+	}
+
+	switch compare := opt["syncCompare"]; compare {
+	case "":
+		return true, SyncCompareModTime, nil
+	case SyncCompareSize, SyncCompareModTime, SyncCompareETag:
+		return true, compare, nil
+	default:
+		return false, "", fmt.Errorf("sync comparison %q is not supported", compare)
+	}
+}
+
+// needsTransfer tells if the destination file differs from the source one.
+// When the compared attribute is unknown the files are considered different.
+func needsTransfer(src FileInfo, dst FileInfo, compare string) bool {
+
+	if src.Size < 0 || src.Size != dst.Size {
+		return true
+	}
+
+	switch compare {
+	case SyncCompareSize:
+		return false
+	case SyncCompareETag:
+		srcTag, dstTag := normalizeETag(src.ETag), normalizeETag(dst.ETag)
+		return srcTag == "" || srcTag != dstTag
+	default:
+		if src.ModTime.IsZero() || dst.ModTime.IsZero() {
+			return true
+		}
+		return src.ModTime.After(dst.ModTime)
+	}
+}
+
+// normalizeETag strips quotes and weak validator prefix
+func normalizeETag(tag string) string {
+	tag = strings.TrimPrefix(tag, "W/")
+	return strings.ToLower(strings.Trim(tag, `"`))
+}
@@ -0,0 +1,123 @@
+package netio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNeedsTransfer(t *testing.T) {
+
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		src     FileInfo
+		dst     FileInfo
+		compare string
+		expect  bool
+	}{
+		{"size differs", FileInfo{Size: 1}, FileInfo{Size: 2}, SyncCompareSize, true},
+		{"size unknown", FileInfo{Size: -1}, FileInfo{Size: -1}, SyncCompareSize, true},
+		{"same size", FileInfo{Size: 1}, FileInfo{Size: 1}, SyncCompareSize, false},
+		{"same etag", FileInfo{Size: 1, ETag: `"ABC"`}, FileInfo{Size: 1, ETag: "abc"}, SyncCompareETag, false},
+		{"etag differs", FileInfo{Size: 1, ETag: "abc"}, FileInfo{Size: 1, ETag: "abd"}, SyncCompareETag, true},
+		{"etag unknown", FileInfo{Size: 1}, FileInfo{Size: 1}, SyncCompareETag, true},
+		{"src older", FileInfo{Size: 1, ModTime: now}, FileInfo{Size: 1, ModTime: now.Add(time.Hour)}, SyncCompareModTime, false},
+		{"src newer", FileInfo{Size: 1, ModTime: now.Add(time.Hour)}, FileInfo{Size: 1, ModTime: now}, SyncCompareModTime, true},
+		{"mtime unknown", FileInfo{Size: 1}, FileInfo{Size: 1, ModTime: now}, SyncCompareModTime, true},
+	}
+
+	for _, tt := range tests {
+		if got := needsTransfer(tt.src, tt.dst, tt.compare); got != tt.expect {
+			t.Errorf("%v: expected needsTransfer() to be %v but got %v", tt.name, tt.expect, got)
+		}
+	}
+}
+
+func TestSyncMode(t *testing.T) {
+
+	if on, _, _ := syncMode(map[string]string{}); on {
+		t.Fatalf("expected sync to be off by default")
+	}
+
+	on, compare, err := syncMode(map[string]string{"sync": "true"})
+	if err != nil || !on || compare != SyncCompareModTime {
+		t.Fatalf("expected mtime comparison by default but got %v %v %v", on, compare, err)
+	}
+
+	if _, _, err := syncMode(map[string]string{"sync": "true", "syncCompare": "crc"}); err == nil {
+		t.Fatalf("expected unsupported comparison to fail")
+	}
+}
+
+func TestDirectorySync(t *testing.T) {
+
+	src := newTestDir(t, map[string]string{
+		"same.txt":    "same",
+		"changed.txt": "new content",
+		"new.txt":     "new",
+	})
+	defer os.RemoveAll(src)
+
+	dst := newTestDir(t, map[string]string{
+		"same.txt":    "same",
+		"changed.txt": "old",
+		"stale.txt":   "stale",
+	})
+	defer os.RemoveAll(dst)
+
+	opt := map[string]string{"sync": "true", "syncCompare": SyncCompareETag, "syncDelete": "true"}
+
+	result, err := TransferDirectory(context.Background(), "file://"+src+"/", "file://"+dst+"/", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 3 || result.Copied != 2 || result.Skipped != 1 || result.Deleted != 1 {
+		t.Fatalf("expected 2 copied, 1 skipped and 1 deleted but got %+v", result)
+	}
+
+	checkTestDir(t, dst, map[string]string{"same.txt": "same", "changed.txt": "new content", "new.txt": "new"})
+
+	if _, err := os.Stat(filepath.Join(dst, "stale.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected stale.txt to be deleted")
+	}
+}
+
+func TestFileSyncUpToDate(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{"src.txt": "content", "dst.txt": "content"})
+	defer os.RemoveAll(dir)
+
+	// destination newer than the source is left alone
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "src.txt"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dst.txt"), []byte("CONTENT"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := map[string]string{"sync": "true"}
+	result, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "file://"+dir+"/dst.txt", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.UpToDate || result.Bytes != 0 {
+		t.Fatalf("expected nothing to be transferred but got %+v", result)
+	}
+	checkTestDir(t, dir, map[string]string{"dst.txt": "CONTENT"})
+
+	// unless it is different in size
+	if err := ioutil.WriteFile(filepath.Join(dir, "dst.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Transfer(context.Background(), "file://"+dir+"/src.txt", "file://"+dir+"/dst.txt", opt); err != nil {
+		t.Fatal(err)
+	}
+	checkTestDir(t, dir, map[string]string{"dst.txt": "content"})
+}
@@ -0,0 +1,56 @@
+package netio
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/viktorburka/octopus/tracing"
+	"net/http"
+)
+
+// newHttpClient returns the client recording a span of every
+// request that is made within a trace
+func newHttpClient() *http.Client {
+	return &http.Client{Transport: &tracing.Transport{}}
+}
+
+type s3SpanKey struct{}
+
+// traceS3Requests records a span of every S3 operation (including its
+// retries) and passes the trace context to S3 in traceparent header
+func traceS3Requests(handlers *request.Handlers) {
+
+	handlers.Build.PushBack(func(r *request.Request) {
+		ctx, span := tracing.StartClient(r.Context(), "s3."+r.Operation.Name,
+			"aws.service", r.ClientInfo.ServiceName, "aws.operation", r.Operation.Name)
+		if span == nil {
+			return
+		}
+		r.SetContext(context.WithValue(ctx, s3SpanKey{}, span))
+		r.HTTPRequest.Header.Set(tracing.TraceParentHeader, span.TraceParent())
+	})
+
+	handlers.Complete.PushBack(func(r *request.Request) {
+		span, ok := r.Context().Value(s3SpanKey{}).(*tracing.Span)
+		if !ok {
+			return
+		}
+		span.SetAttributes("aws.retries", r.RetryCount)
+		if r.HTTPResponse != nil {
+			span.SetAttributes("http.status_code", r.HTTPResponse.StatusCode)
+		}
+		span.SetError(r.Error)
+		span.End()
+	})
+}
+
+// partAttributes returns the span attributes of a part: the scheme and
+// the part options that are set (the simple transfers don't have them)
+func partAttributes(scheme string, opt map[string]string, options ...string) []interface{} {
+	kv := []interface{}{"scheme", scheme}
+	for _, name := range options {
+		if val, ok := opt[name]; ok {
+			kv = append(kv, name, val)
+		}
+	}
+	return kv
+}
@@ -0,0 +1,108 @@
+package netio
+
+import (
+	"context"
+	"github.com/viktorburka/octopus/tracing"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingExporter struct {
+	m     sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []tracing.SpanData) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// traceTransfer runs the transfer in a trace and returns its spans by name
+func traceTransfer(t *testing.T, srcUrl string, dstUrl string, opt map[string]string) map[string]tracing.SpanData {
+
+	exporter := &recordingExporter{}
+	tracer := tracing.NewTracer(exporter, 100, time.Hour, func(err error) { t.Error(err) })
+	tracing.SetDefault(tracer)
+
+	ctx, root := tracing.Start(context.Background(), "job")
+	_, err := Transfer(ctx, srcUrl, dstUrl, opt)
+	root.End()
+
+	tracing.SetDefault(nil)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]tracing.SpanData)
+	for _, span := range exporter.spans {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func TestTraceLocalTransfer(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{"src.txt": "content"})
+	defer os.RemoveAll(dir)
+
+	spans := traceTransfer(t, "file://"+dir+"/src.txt", "file://"+dir+"/dst.txt", map[string]string{})
+
+	job, transfer := spans["job"], spans["transfer"]
+	if transfer.ParentID != job.SpanID {
+		t.Fatalf("expected transfer span to be a child of job but got %+v", spans)
+	}
+	for _, name := range []string{"probe", "download part", "upload part"} {
+		span, ok := spans[name]
+		if !ok || span.TraceID != job.TraceID || span.ParentID != transfer.SpanID {
+			t.Fatalf("expected %v span to be a child of transfer but got %+v", name, spans)
+		}
+	}
+}
+
+func TestTraceS3Requests(t *testing.T) {
+
+	dir := newTestDir(t, map[string]string{})
+	defer os.RemoveAll(dir)
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Content-Length", "7")
+		case http.MethodGet:
+			traceparent = r.Header.Get(tracing.TraceParentHeader)
+			w.Header().Set("ETag", `"9a0364b9e99bb480dd25e1f0284c8555"`)
+			w.Write([]byte("content"))
+		}
+	}))
+	defer srv.Close()
+
+	opt := map[string]string{
+		"awsEndpoint":        srv.URL,
+		"awsRegion":          "us-east-1",
+		"awsAccessKeyId":     "id",
+		"awsSecretAccessKey": "secret",
+	}
+	spans := traceTransfer(t, "s3://host/bucket/src/key.txt", "file://"+dir+"/key.txt", opt)
+
+	head, get := spans["s3.HeadObject"], spans["s3.GetObject"]
+	if head.Kind != tracing.KindClient || head.ParentID != spans["probe"].SpanID {
+		t.Fatalf("expected HeadObject span to be a child of probe but got %+v", spans)
+	}
+	if get.Kind != tracing.KindClient || get.ParentID != spans["download part"].SpanID {
+		t.Fatalf("expected GetObject span to be a child of download part but got %+v", spans)
+	}
+	expected := "00-" + get.TraceID.String() + "-" + get.SpanID.String() + "-01"
+	if traceparent != expected {
+		t.Fatalf("expected traceparent %v but got %v", expected, traceparent)
+	}
+}
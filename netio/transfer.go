@@ -2,55 +2,132 @@ package netio
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"github.com/viktorburka/octopus/tracing"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
-func Transfer(ctx context.Context, srcUrl string, dstUrl string, options map[string]string) error {
+// Transfer copies the file at srcUrl to dstUrl and reports how it went.
+// options are modified to pass the source file attributes along.
+func Transfer(ctx context.Context, srcUrl string, dstUrl string,
+	options map[string]string) (result Result, err error) {
+
+	begin := time.Now()
 
 	src, err := url.Parse(srcUrl)
 	if err != nil {
-		return fmt.Errorf("invalid srcUrl %v", err)
+		return result, fmt.Errorf("invalid srcUrl %v", err)
 	}
 
 	dst, err := url.Parse(dstUrl)
 	if err != nil {
-		return fmt.Errorf("invalid dstUrl %v", err)
+		return result, fmt.Errorf("invalid dstUrl %v", err)
 	}
 
+	ctx, span := tracing.Start(ctx, "transfer", "src.scheme", src.Scheme, "dst.scheme", dst.Scheme)
+	ctx, stats := withTransferStats(ctx)
+	defer func() {
+		stats.fill(&result)
+		result.Duration = time.Since(begin)
+		span.SetAttributes("strategy", result.Strategy, "bytes", result.Bytes,
+			"parts", result.Parts, "retries", result.Retries)
+		span.SetError(err)
+		span.End()
+	}()
+
 	info, err := probe(ctx, src.Scheme, srcUrl, options)
 	if err != nil {
-		return fmt.Errorf("can't collect src file info: %v", err)
+		return result, fmt.Errorf("can't collect src file info: %w", err)
+	}
+
+	syncing, compare, err := syncMode(options)
+	if err != nil {
+		return result, err
+	}
+
+	compression, err := newTransferCompression(options)
+	if err != nil {
+		return result, err
+	}
+
+	setObjectOptions(options, info)
+
+	// the size of the data written to the destination
+	cipher, size, err := newTransferCipher(ctx, info, options)
+	if err != nil {
+		return result, err
+	}
+	if compression != nil {
+		size = -1 // known once compressed or decompressed
+	}
+	// the downloaders read the source and the uploaders write the data
+	// the stages make of it so the content length is told to each apart
+	srcOptions := make(map[string]string, len(options)+1)
+	for k, v := range options {
+		srcOptions[k] = v
+	}
+	srcOptions["contentLength"] = strconv.FormatInt(info.Size, 10)
+	options["contentLength"] = strconv.FormatInt(size, 10)
+
+	if syncing {
+		dstInfo, err := probe(ctx, dst.Scheme, dstUrl, options)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return result, fmt.Errorf("can't collect dst file info: %w", err)
+		}
+		srcInfo := info
+		srcInfo.Size = size
+		if err == nil && !needsTransfer(srcInfo, dstInfo, compare) {
+			logging.FromContext(ctx).Info("destination is up to date", "dst", dstUrl)
+			result.UpToDate = true
+			return result, nil
+		}
 	}
 
 	dnl, err := getDownloader(src.Scheme)
 	if err != nil {
-		return fmt.Errorf("can't initialize downloader: %v", err)
+		return result, fmt.Errorf("can't initialize downloader: %v", err)
 	}
 
 	upl, err := getUploader(dst.Scheme)
 	if err != nil {
-		return fmt.Errorf("can't initialize uploader: %v", err)
+		return result, fmt.Errorf("can't initialize uploader: %v", err)
 	}
 
 	receiver, err := getReceiver(src.Scheme, info.Size)
 	if err != nil {
-		return fmt.Errorf("can't initialize receiver: %v", err)
+		return result, fmt.Errorf("can't initialize receiver: %v", err)
 	}
 
-	sender, err := getSender(dst.Scheme, info.Size)
+	sender, err := getSender(dst.Scheme, size)
 	if err != nil {
-		return fmt.Errorf("can't initialize sender: %v", err)
+		return result, fmt.Errorf("can't initialize sender: %v", err)
+	}
+
+	result.Strategy = StrategySimple
+	if _, ok := dnl.(DownloaderConcurrent); ok {
+		result.Strategy = StrategyConcurrent
+	}
+	if _, ok := sender.(*S3SenderMultipart); ok {
+		result.Strategy = StrategyConcurrent
 	}
 
-	options["contentLength"] = strconv.FormatInt(info.Size, 10)
+	receiver = measuredReceiver{receiver, src.Scheme}
+	sender = measuredSender{sender, dst.Scheme}
 
-	ioctx, cancel := context.WithCancel(ctx)
+	ioctx, cancel := context.WithCancel(withProgressTracker(ctx, size))
 	defer cancel()
 
+	// downloader -> datachan -> [decryption] -> [decompressStage] -> [compressStage] ->
+	// [encryption] -> measureStage -> upchan -> uploader; the optional stages are
+	// chained one after the other each reading the channel the previous one writes
 	datachan := make(chan dlData)
+	upchan := make(chan dlData)
 	commchan := make(chan dlMessage)
 
 	var wg sync.WaitGroup
@@ -58,10 +135,14 @@ func Transfer(ctx context.Context, srcUrl string, dstUrl string, options map[str
 
 	// helper goroutine reads errors from commchan;
 	// call close(commchan) to finish it
+	helperDone := make(chan struct{})
 	go func() {
+		defer close(helperDone)
 		// for loop on chan will finish after channel is closed
 		for msg := range commchan {
-			if msg.err != nil {
+			// the first error is the cause; the other
+			// side fails after it because of cancel()
+			if msg.err != nil && transferError == nil {
 				transferError = msg.err
 				cancel()
 			}
@@ -69,18 +150,89 @@ func Transfer(ctx context.Context, srcUrl string, dstUrl string, options map[str
 	}()
 
 	wg.Add(1)
-	go download(&wg, dnl, ioctx, srcUrl, options, datachan, commchan, receiver)
+	go download(&wg, dnl, ioctx, srcUrl, srcOptions, datachan, commchan, receiver)
+
+	measured := datachan
+	stage := func(name string, run func(in <-chan dlData, out chan<- dlData) error) {
+		in, out := measured, make(chan dlData)
+		measured = out
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(in, out); err != nil {
+				commchan <- dlMessage{name, err}
+			}
+		}()
+	}
+	crypt := func(in <-chan dlData, out chan<- dlData) error {
+		return cryptStage(ioctx, cipher, in, out, size)
+	}
+
+	if cipher != nil && !cipher.encrypt {
+		stage("decryption", crypt)
+	}
+	if compression != nil && compression.decompress != "" {
+		stage("decompression", func(in <-chan dlData, out chan<- dlData) error {
+			return decompressStage(ioctx, compression.decompress, in, out)
+		})
+	}
+	if compression != nil && compression.compress != "" {
+		stage("compression", func(in <-chan dlData, out chan<- dlData) error {
+			return compressStage(ioctx, compression.compress, in, out)
+		})
+	}
+	if cipher != nil && cipher.encrypt {
+		stage("encryption", crypt)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		measureStage(ioctx, measured, upchan, &result)
+	}()
 
 	wg.Add(1)
-	go upload(&wg, upl, ioctx, dstUrl, options, datachan, commchan, sender)
+	go upload(&wg, upl, ioctx, dstUrl, options, upchan, commchan, sender)
 
 	// wait until transfer complete or error
 	wg.Wait()
 
 	// to finish helper goroutine
 	close(commchan)
+	<-helperDone
 
-	return transferError
+	return result, transferError
+}
+
+// ValidateTransfer checks that the urls are valid and there
+// are downloader and uploader available for their schemes
+func ValidateTransfer(srcUrl string, dstUrl string) error {
+
+	src, err := url.Parse(srcUrl)
+	if err != nil {
+		return fmt.Errorf("invalid srcUrl %v", err)
+	}
+
+	dst, err := url.Parse(dstUrl)
+	if err != nil {
+		return fmt.Errorf("invalid dstUrl %v", err)
+	}
+
+	if _, err := getDownloader(src.Scheme); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(srcUrl, "/") {
+		if _, err := getLister(src.Scheme); err != nil {
+			return err
+		}
+	}
+
+	if _, err := getUploader(dst.Scheme); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func download(wg *sync.WaitGroup, dnl Downloader, ioctx context.Context, srcUrl string,
@@ -101,8 +253,13 @@ func upload(wg *sync.WaitGroup, upl Uploader, ioctx context.Context, dstUrl stri
 	}
 }
 
-func probe(ctx context.Context, scheme string, uri string, options map[string]string) (FileInfo, error) {
-	var info FileInfo
+func probe(ctx context.Context, scheme string, uri string, options map[string]string) (info FileInfo, err error) {
+	ctx, span := tracing.Start(ctx, "probe", "scheme", scheme)
+	defer func() {
+		span.SetAttributes("size", info.Size)
+		span.SetError(err)
+		span.End()
+	}()
 	dl, err := getProbeForScheme(scheme)
 	if err != nil {
 		return info, err
@@ -0,0 +1,272 @@
+package netio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// number of files transferred at a time unless parallelFiles option is given
+const DefaultParallelFiles = 4
+
+// ObjectError tells why a file of the directory wasn't transferred
+type ObjectError struct {
+	Path string
+	Err  error
+}
+
+// DirectoryResult sums up the directory transfer
+type DirectoryResult struct {
+	Total   int   // files found in the source directory
+	Copied  int   // files transferred successfully
+	Skipped int   // files up to date in the destination (sync)
+	Deleted int   // destination files missing from the source (syncDelete)
+	Bytes   int64 // bytes of the files transferred
+	Errors  []ObjectError
+}
+
+// IsDirectoryTransfer tells if srcUrl refers to a directory or
+// prefix (ends with '/') or recursive option is set to true
+func IsDirectoryTransfer(srcUrl string, options map[string]string) bool {
+	return strings.HasSuffix(srcUrl, "/") || options["recursive"] == "true"
+}
+
+// TransferDirectory copies all the files found under srcUrl to dstUrl
+// keeping their relative paths. Up to parallelFiles files are transferred
+// at a time. A failed file doesn't stop the others; the returned error
+// tells how many of them failed and the result lists them all.
+func TransferDirectory(ctx context.Context, srcUrl string, dstUrl string,
+	options map[string]string) (DirectoryResult, error) {
+
+	var result DirectoryResult
+
+	src, err := url.Parse(srcUrl)
+	if err != nil {
+		return result, fmt.Errorf("invalid srcUrl %v", err)
+	}
+
+	dst, err := url.Parse(dstUrl)
+	if err != nil {
+		return result, fmt.Errorf("invalid dstUrl %v", err)
+	}
+
+	lst, err := getLister(src.Scheme)
+	if err != nil {
+		return result, fmt.Errorf("can't initialize lister: %v", err)
+	}
+
+	if _, err := getUploader(dst.Scheme); err != nil {
+		return result, fmt.Errorf("can't initialize uploader: %v", err)
+	}
+
+	parallelFiles, err := intOption(options, "parallelFiles", DefaultParallelFiles)
+	if err != nil {
+		return result, err
+	}
+
+	objects, err := lst.List(ctx, srcUrl, options)
+	if err != nil {
+		return result, fmt.Errorf("can't list %v: %w", srcUrl, err)
+	}
+
+	result.Total = len(objects)
+
+	// the paths come from the source (manifest lines, keys) and
+	// must not lead out of the destination directory
+	var safe []ObjectInfo
+	for _, obj := range objects {
+		if !isRelativePath(obj.Path) {
+			result.Errors = append(result.Errors, ObjectError{Path: obj.Path,
+				Err: fmt.Errorf("path leads out of the directory")})
+			continue
+		}
+		safe = append(safe, obj)
+	}
+	objects = safe
+
+	srcPaths := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		srcPaths[obj.Path] = true
+	}
+
+	syncing, compare, err := syncMode(options)
+	if err != nil {
+		return result, err
+	}
+
+	// destination files by path
+	var existing map[string]FileInfo
+	if syncing {
+		existing, err = listDestination(ctx, dst.Scheme, dstUrl, options)
+		if err != nil {
+			return result, err
+		}
+		var changed []ObjectInfo
+		for _, obj := range objects {
+			if dstInfo, ok := existing[obj.Path]; ok && !needsTransfer(obj.FileInfo, dstInfo, compare) {
+				result.Skipped++
+				continue
+			}
+			changed = append(changed, obj)
+		}
+		objects = changed
+	}
+
+	// the directory progress is reported instead of the files one
+	var total int64
+	for _, obj := range objects {
+		if obj.Size < 0 { // unknown
+			total = 0
+			break
+		}
+		total += obj.Size
+	}
+	dirCtx := withProgressTracker(ctx, total)
+
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, parallelFiles) // channel as semaphore
+
+	for _, obj := range objects {
+
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(obj ObjectInfo) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			// Transfer modifies options
+			opt := make(map[string]string, len(options))
+			for key, val := range options {
+				opt[key] = val
+			}
+			delete(opt, "recursive")
+			delete(opt, "sync") // already compared
+
+			r, err := Transfer(dirCtx, joinUrl(src, obj.Path), joinUrl(dst, obj.Path), opt)
+
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				result.Errors = append(result.Errors, ObjectError{Path: obj.Path, Err: err})
+				return
+			}
+			result.Copied++
+			result.Bytes += r.Bytes
+		}(obj)
+	}
+
+	wg.Wait()
+
+	sort.Slice(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Path < result.Errors[j].Path
+	})
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	// deleting only after a clean run to not lose
+	// destination files because of a partial listing
+	if syncing && options["syncDelete"] == "true" && len(result.Errors) == 0 {
+		if err := deleteMissing(ctx, dst, existing, srcPaths, options, &result); err != nil {
+			return result, err
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		first := result.Errors[0]
+		return result, fmt.Errorf("%v of %v files failed (%v: %w)",
+			len(result.Errors), result.Total, first.Path, first.Err)
+	}
+
+	return result, nil
+}
+
+// listDestination returns the files that are already in the destination
+func listDestination(ctx context.Context, scheme string, dstUrl string,
+	options map[string]string) (map[string]FileInfo, error) {
+
+	lst, err := getLister(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("can't sync: %v", err)
+	}
+
+	objects, err := lst.List(ctx, dstUrl, options)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("can't list %v: %w", dstUrl, err)
+	}
+
+	existing := make(map[string]FileInfo, len(objects))
+	for _, obj := range objects {
+		existing[obj.Path] = obj.FileInfo
+	}
+
+	return existing, nil
+}
+
+// deleteMissing removes the destination files that are not in the source
+func deleteMissing(ctx context.Context, dst *url.URL, existing map[string]FileInfo, srcPaths map[string]bool,
+	options map[string]string, result *DirectoryResult) error {
+
+	rm, err := getRemover(dst.Scheme)
+	if err != nil {
+		return fmt.Errorf("can't delete: %v", err)
+	}
+
+	var missing []string
+	for p := range existing {
+		if !srcPaths[p] {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, p := range missing {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("deleting file missing from the source", "path", p)
+		if err := rm.Remove(ctx, joinUrl(dst, p), options); err != nil {
+			result.Errors = append(result.Errors, ObjectError{Path: p, Err: fmt.Errorf("can't delete: %v", err)})
+			continue
+		}
+		result.Deleted++
+	}
+
+	return nil
+}
+
+// isRelativePath tells if the '/' separated path stays under the directory
+func isRelativePath(p string) bool {
+	if p == "" || path.IsAbs(p) {
+		return false
+	}
+	clean := path.Clean(p)
+	return clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// joinUrl appends '/' separated relative path to the url path
+func joinUrl(base *url.URL, rel string) string {
+	u := *base
+	if u.Opaque != "" { // file:relative/dir/
+		u.Opaque = strings.TrimSuffix(u.Opaque, "/") + "/" + rel
+		return u.String()
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + rel
+	u.RawPath = ""
+	return u.String()
+}
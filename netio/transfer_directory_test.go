@@ -0,0 +1,194 @@
+package netio
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func checkTestDir(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, []byte(content)) {
+			t.Fatalf("expected %v to contain %q but got %q", name, content, buf)
+		}
+	}
+}
+
+func TestLocalDirectoryTransfer(t *testing.T) {
+
+	files := map[string]string{
+		"a.txt":       "first file",
+		"sub/b.txt":   "second file",
+		"sub/c/d.txt": string(bytes.Repeat([]byte{0xEF}, 300*1024)),
+	}
+
+	src := newTestDir(t, files)
+	defer os.RemoveAll(src)
+	dst := newTestDir(t, nil)
+	defer os.RemoveAll(dst)
+
+	var done, total int64
+	ctx := WithProgress(context.Background(), func(bytesDone int64, bytesTotal int64) {
+		done = bytesDone
+		total = bytesTotal
+	})
+	opt := map[string]string{"parallelFiles": "1"} // progress callback isn't synchronized
+
+	result, err := TransferDirectory(ctx, "file://"+src+"/", "file://"+dst+"/copy/", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 3 || result.Copied != 3 || len(result.Errors) != 0 {
+		t.Fatalf("expected 3 files copied but got %+v", result)
+	}
+
+	expectedBytes := int64(len("first file") + len("second file") + 300*1024)
+	if result.Bytes != expectedBytes || done != expectedBytes || total != expectedBytes {
+		t.Fatalf("expected %v bytes copied but got %v (progress %v of %v)", expectedBytes, result.Bytes, done, total)
+	}
+
+	checkTestDir(t, filepath.Join(dst, "copy"), files)
+}
+
+func TestDirectoryTransferPartialFailure(t *testing.T) {
+
+	src := newTestDir(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer os.RemoveAll(src)
+
+	// b.txt can't be created over the directory
+	dst := newTestDir(t, map[string]string{"b.txt/keep": "keep"})
+	defer os.RemoveAll(dst)
+
+	result, err := TransferDirectory(context.Background(), "file://"+src+"/", "file://"+dst+"/", map[string]string{})
+	if err == nil {
+		t.Fatalf("expected TransferDirectory() to return an error")
+	}
+
+	if result.Total != 2 || result.Copied != 1 || len(result.Errors) != 1 || result.Errors[0].Path != "b.txt" {
+		t.Fatalf("expected b.txt to fail but got %+v", result)
+	}
+
+	checkTestDir(t, dst, map[string]string{"a.txt": "a"})
+}
+
+func TestHttpManifestDirectoryTransfer(t *testing.T) {
+
+	files := map[string]string{
+		"videos/one.mp4": "one",
+		"videos/two.mp4": "two",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/videos/manifest.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# videos\none.mp4\n\ntwo.mp4\n"))
+	})
+	mux.HandleFunc("/videos/", func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path[1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dst := newTestDir(t, nil)
+	defer os.RemoveAll(dst)
+
+	result, err := TransferDirectory(context.Background(), srv.URL+"/videos/", "file://"+dst+"/videos/", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Copied != 2 {
+		t.Fatalf("expected 2 files copied but got %+v", result)
+	}
+
+	checkTestDir(t, dst, files)
+}
+
+func TestDirectoryTransferPathOutside(t *testing.T) {
+
+	// the server would serve any path it is asked for
+	mux := http.NewServeMux()
+	mux.HandleFunc("/videos/manifest.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("one.mp4\n../escape.txt\nsub/../../escape.txt\n"))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dst := newTestDir(t, nil)
+	defer os.RemoveAll(dst)
+
+	result, err := TransferDirectory(context.Background(), srv.URL+"/videos/", "file://"+dst+"/videos/", map[string]string{})
+	if err == nil {
+		t.Fatalf("expected TransferDirectory() to return an error")
+	}
+	if result.Total != 3 || result.Copied != 1 || len(result.Errors) != 2 {
+		t.Fatalf("expected the paths out of the directory to fail but got %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "escape.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written out of the destination directory but got %v", err)
+	}
+	checkTestDir(t, dst, map[string]string{"videos/one.mp4": "content"})
+}
+
+func TestIsRelativePath(t *testing.T) {
+	for p, expected := range map[string]bool{
+		"a.txt":        true,
+		"sub/a.txt":    true,
+		"sub/../a.txt": true,
+		"":             false,
+		"/etc/passwd":  false,
+		"..":           false,
+		"../a.txt":     false,
+		"sub/../../a":  false,
+	} {
+		if isRelativePath(p) != expected {
+			t.Fatalf("expected isRelativePath(%q) to be %v", p, expected)
+		}
+	}
+}
+
+func TestIsDirectoryTransfer(t *testing.T) {
+
+	if !IsDirectoryTransfer("s3://s3.amazonaws.com/bucket/photos/", map[string]string{}) {
+		t.Fatalf("expected url ending with / to be a directory")
+	}
+	if !IsDirectoryTransfer("s3://s3.amazonaws.com/bucket/photos", map[string]string{"recursive": "true"}) {
+		t.Fatalf("expected recursive transfer to be a directory")
+	}
+	if IsDirectoryTransfer("s3://s3.amazonaws.com/bucket/photo.jpg", map[string]string{}) {
+		t.Fatalf("expected single file transfer")
+	}
+}
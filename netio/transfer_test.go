@@ -95,7 +95,7 @@ func TestTransferReturnsError(t *testing.T) {
 	src := "s3://amazon.aws.com/src/key.mp4"
 	dst := "s3://amazon.aws.com/dst/key.mp4"
 	opt := map[string]string{}
-	err := Transfer(ctx, src, dst, opt)
+	_, err := Transfer(ctx, src, dst, opt)
 	if err == nil {
 		t.Fatalf("expected Transfer() to return an error but got nil")
 	}
@@ -113,7 +113,7 @@ func TestTransferInvalidParameters(t *testing.T) {
 	src = "s3://amazon!,aws,com/src/key.mp4"
 	dst = "s3://amazon.aws.com/dst/key.mp4"
 
-	err = Transfer(ctx, src, dst, opt)
+	_, err = Transfer(ctx, src, dst, opt)
 	if err == nil {
 		t.Fatalf("expected Transfer() to return an error but got nil")
 	}
@@ -122,7 +122,7 @@ func TestTransferInvalidParameters(t *testing.T) {
 	src = "s3://amazon.aws.com/src/key.mp4"
 	dst = "s3://amazon,!aws.com/dst/key.mp4"
 
-	err = Transfer(ctx, src, dst, opt)
+	_, err = Transfer(ctx, src, dst, opt)
 	if err == nil {
 		t.Fatalf("expected Transfer() to return an error but got nil")
 	}
@@ -140,8 +140,34 @@ func TestTransferUnsupportedScheme(t *testing.T) {
 	src = "unsupported://amazon.aws.com/src/key.mp4"
 	dst = "unsupported://amazon.aws.com/dst/key.mp4"
 
-	err = Transfer(ctx, src, dst, opt)
+	_, err = Transfer(ctx, src, dst, opt)
 	if err == nil {
 		t.Fatalf("expected Transfer() to return an error but got nil")
 	}
 }
+
+func TestValidateTransfer(t *testing.T) {
+
+	tests := []struct {
+		src   string
+		dst   string
+		valid bool
+	}{
+		{"s3://amazon.aws.com/src/key.mp4", "s3://amazon.aws.com/dst/key.mp4", true},
+		{"https://example.com/key.mp4", "file:///tmp/key.mp4", true},
+		{"s3://amazon.aws.com/src/%zz.mp4", "s3://amazon.aws.com/dst/key.mp4", false},
+		{"s3://amazon.aws.com/src/key.mp4", "s3://amazon aws com/dst/key.mp4", false},
+		{"ftp://example.com/key.mp4", "s3://amazon.aws.com/dst/key.mp4", false},
+		{"s3://amazon.aws.com/src/key.mp4", "http://example.com/key.mp4", false},
+	}
+
+	for _, test := range tests {
+		err := ValidateTransfer(test.src, test.dst)
+		if test.valid && err != nil {
+			t.Fatalf("expected %v -> %v to be valid but got '%v'", test.src, test.dst, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("expected %v -> %v to be invalid", test.src, test.dst)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 )
 
 type sender interface {
@@ -21,6 +22,9 @@ type Uploader interface {
 
 const MinAwsPartSize = 5 * 1024 * 1024 // 5MB
 
+// AbortTimeout limits the time to abort an interrupted upload
+const AbortTimeout = 30 * time.Second
+
 func getUploader(scheme string) (dl Uploader, err error) {
 	switch scheme {
 	case "file":
@@ -32,12 +36,14 @@ func getUploader(scheme string) (dl Uploader, err error) {
 	}
 }
 
+// getSender returns the sender of the data of size (-1 if unknown)
 func getSender(scheme string, size int64) (s sender, err error) {
 	switch scheme {
 	case "file":
 		return &LocalFileSender{}, nil
 	case "s3":
-		if size >= MinAwsPartSize {
+		// the data of unknown size may take more than a part
+		if size < 0 || size >= MinAwsPartSize {
 			return &S3SenderMultipart{}, nil
 		}
 		return &S3SenderSimple{}, nil
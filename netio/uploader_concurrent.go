@@ -3,8 +3,9 @@ package netio
 import (
 	"context"
 	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"github.com/viktorburka/octopus/tracing"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -18,25 +19,40 @@ type UploaderConcurrent struct {
 func (s UploaderConcurrent) Upload(ctx context.Context, uri string,
 	options map[string]string, data chan dlData, snd sender) error {
 
+	partSize, err := intOption(options, "partSize", MinAwsPartSize)
+	if err != nil {
+		return err
+	}
+	if partSize < MinAwsPartSize {
+		return fmt.Errorf("part size %v is less than minimum %v", partSize, MinAwsPartSize)
+	}
+
+	maxWorkers, err := intOption(options, "concurrency", DefaultUploadWorkers)
+	if err != nil {
+		return err
+	}
+
 	tempDir, err := ioutil.TempDir(os.TempDir(), "")
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tempDir)
 
+	var buffer tempBuffer
+	defer buffer.release()
+
 	uplCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	log.Println("open upload connection")
+	logger := logging.FromContext(ctx)
+	logger.Debug("open upload connection")
 
 	if err := snd.OpenWithContext(uplCtx, uri, options); err != nil {
 		return err
 	}
 
-	const MaxWorkers = 5 // can't be 0 !
-
 	errchan := make(chan error)
-	workers := make(chan struct{}, MaxWorkers) // channel as semaphore
+	workers := make(chan struct{}, maxWorkers) // channel as semaphore
 
 	isLastChunk := false
 
@@ -52,10 +68,13 @@ func (s UploaderConcurrent) Upload(ctx context.Context, uri string,
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
+		// keep draining after the first error for the
+		// other parts in flight not to block on sending
 		for err := range errchan {
-			partUploadErr = err
-			cancel()
-			break
+			if partUploadErr == nil {
+				partUploadErr = err
+				cancel()
+			}
 		}
 	}()
 
@@ -68,10 +87,10 @@ func (s UploaderConcurrent) Upload(ctx context.Context, uri string,
 				fileName := fmt.Sprintf("%v.part", counter)
 				fpath = filepath.Join(tempDir, fileName)
 				// create part file
-				log.Println("create buffer file:", fileName)
+				logger.Debug("create buffer file", "part", counter)
 				file, err = os.Create(fpath)
 				if err != nil {
-					opErr = err
+					opErr = fileError(err)
 					break
 				}
 			}
@@ -81,13 +100,14 @@ func (s UploaderConcurrent) Upload(ctx context.Context, uri string,
 			if len(chunk.data) > 0 {
 				bw, err := file.Write(chunk.data)
 				if err != nil {
-					opErr = err
+					opErr = fileError(err)
 					break
 				}
 				total += int64(bw)
+				buffer.add(int64(bw))
 			}
 
-			if total >= MinAwsPartSize || isLastChunk { // ready to start upload
+			if total >= partSize || isLastChunk { // ready to start upload
 				// close buffer file
 				if err := file.Close(); err != nil {
 					opErr = err
@@ -95,15 +115,22 @@ func (s UploaderConcurrent) Upload(ctx context.Context, uri string,
 				}
 				file = nil
 
-				workers <- struct{}{}
+				select {
+				case workers <- struct{}{}:
+				case <-uplCtx.Done():
+					opErr = uplCtx.Err()
+				}
+				if opErr != nil {
+					break
+				}
 				wg.Add(1)
-				go uploadPart(uplCtx, fpath, counter, errchan, &wg, workers, snd)
+				go uploadPart(uplCtx, fpath, counter, errchan, &wg, workers, snd, &buffer)
 				total = 0
 				counter += 1
 			}
 
 			if isLastChunk {
-				log.Println("last chunk is being uploaded")
+				logger.Debug("last chunk is being uploaded", "parts", counter-1)
 				break
 			}
 
@@ -113,7 +140,7 @@ func (s UploaderConcurrent) Upload(ctx context.Context, uri string,
 		}
 	}
 
-	log.Println("waiting for all parts upload to finish")
+	logger.Debug("waiting for all parts upload to finish")
 
 	// make sure all goroutines are finished
 	wg.Wait()
@@ -124,51 +151,57 @@ func (s UploaderConcurrent) Upload(ctx context.Context, uri string,
 	// wait until helper goroutine finishes
 	wg2.Wait()
 
-	log.Println("finished uploading all parts. opErr =", opErr, "partUploadErr =", partUploadErr)
+	logger.Debug("finished uploading parts", "opErr", opErr, "partUploadErr", partUploadErr)
 
 	if partUploadErr != nil || opErr != nil {
-		errMsg := ""
-		if partUploadErr != nil {
-			errMsg += partUploadErr.Error()
-		}
-		if opErr != nil {
-			if partUploadErr != nil {
-				errMsg += ": "
-			}
-			errMsg += opErr.Error()
+		// the part upload error is the cause; opErr is
+		// most likely the cancellation that followed it
+		uploadErr := partUploadErr
+		if uploadErr == nil {
+			uploadErr = opErr
 		}
 
-		log.Println("upload interrupted because of error. cancelling upload")
-		if err := snd.CancelWithContext(uplCtx); err != nil {
-			return fmt.Errorf("%v: error while cancelling upload: %v", errMsg, err)
+		logger.Warn("upload interrupted, cancelling", "err", uploadErr)
+		// uplCtx is most likely cancelled at this point but the upload
+		// still has to be aborted to not leave incomplete parts behind
+		abortCtx, cancelAbort := context.WithTimeout(context.Background(), AbortTimeout)
+		defer cancelAbort()
+		if err := snd.CancelWithContext(abortCtx); err != nil {
+			return fmt.Errorf("%w (error while cancelling upload: %v)", uploadErr, err)
 		}
 
-		return fmt.Errorf(errMsg)
+		return uploadErr
 	}
 
-	log.Println("close upload connection")
+	logger.Debug("close upload connection")
 
-	if err := snd.CloseWithContext(uplCtx); err != nil {
+	closeCtx, span := tracing.Start(uplCtx, "complete upload", "parts", counter-1)
+	err = snd.CloseWithContext(closeCtx)
+	span.SetError(err)
+	span.End()
+	if err != nil {
 		return err
 	}
 
-	log.Println("remove buffer files and folder")
+	logger.Debug("remove buffer files and folder")
 
 	if err := os.RemoveAll(tempDir); err != nil {
 		return fmt.Errorf("error deleting temp folder: %v", err)
 	}
 
-	log.Println("upload done")
+	logger.Info("upload done", "parts", counter-1)
 	return nil
 }
 
 func uploadPart(ctx context.Context, filePath string, pn int64, errchan chan error, wg *sync.WaitGroup,
-	workers chan struct{}, snd sender) {
+	workers chan struct{}, snd sender, buffer *tempBuffer) {
 
 	defer func() { <-workers }()
 	defer wg.Done()
 
-	log.Println("start uploading part", pn)
+	logger := logging.FromContext(ctx).With("part", pn)
+	ctx = logging.NewContext(ctx, logger)
+	logger.Debug("start part upload")
 
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -177,19 +210,27 @@ func uploadPart(ctx context.Context, filePath string, pn int64, errchan chan err
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		errchan <- err
+		return
+	}
+
 	opt := map[string]string {
 		"partNumber": strconv.FormatInt(pn, 10),
 	}
 
 	_, err = snd.WritePartWithContext(ctx, file, opt)
 	if err != nil {
-		log.Println("write to errchan")
+		logger.Debug("part upload failed", "err", err)
 		errchan <- err
-		log.Println("after write to errchan")
 		return
 	}
 
-	log.Println("finished uploading part", pn)
+	logger.Debug("part uploaded", "bytes", info.Size())
+	recordPart(ctx)
+
+	reportProgress(ctx, info.Size())
 
 	if err := file.Close(); err != nil {
 		errchan <- err
@@ -200,6 +241,7 @@ func uploadPart(ctx context.Context, filePath string, pn int64, errchan chan err
 		errchan <- err
 		return
 	}
+	buffer.add(-info.Size())
 }
 
 
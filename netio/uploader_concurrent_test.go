@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -98,7 +99,8 @@ func TestConcurrentUploaderCancellation(t *testing.T) {
 		t.Fatal(fmt.Errorf("error: expected UploaderConcurrent instance"))
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
 	opt := map[string]string{}
 	uri := "s3://amazon.aws.com/bucket/key.mp4"
 	dtx := make(chan dlData)
@@ -109,6 +111,14 @@ func TestConcurrentUploaderCancellation(t *testing.T) {
 	if uploadError == nil {
 		t.Fatalf("expected Upload() to return '%v' error but got '%v'\n", ctx.Err(), uploadError)
 	}
+
+	if !sdr.cancelled {
+		t.Fatalf("expected Upload() to cancel the upload")
+	}
+
+	if sdr.cancelCtxError != nil {
+		t.Fatalf("expected upload to be cancelled with live context but got '%v'", sdr.cancelCtxError)
+	}
 }
 
 func TestConcurrentUploaderWritePartError(t *testing.T) {
@@ -147,6 +157,63 @@ func TestConcurrentUploaderWritePartError(t *testing.T) {
 	}
 }
 
+func TestConcurrentUploaderCancelPartsInFlight(t *testing.T) {
+
+	const parts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opt := map[string]string{"concurrency": strconv.Itoa(parts)}
+	uri := "s3://amazon.aws.com/bucket/key.mp4"
+	dtx := make(chan dlData)
+	sdr := &blockingSender{started: make(chan struct{}, parts)}
+
+	// data provider goroutine
+	go func() {
+		buf := make([]byte, MinAwsPartSize)
+		for i := 0; i < parts+1; i++ {
+			select {
+			case dtx <- dlData{data: buf}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- UploaderConcurrent{}.Upload(ctx, uri, opt, dtx, sdr)
+	}()
+
+	for i := 0; i < parts; i++ {
+		<-sdr.started
+	}
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Fatalf("expected Upload() to return '%v' error but got '%v'\n", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Upload() to return once cancelled")
+	}
+}
+
+// blockingSender uploads the parts until the context is cancelled
+type blockingSender struct {
+	mockSender
+	started chan struct{}
+}
+
+func (s *blockingSender) WritePartWithContext(ctx context.Context, input io.ReadSeeker,
+	opt map[string]string) (string, error) {
+
+	s.started <- struct{}{}
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
 type mockSender struct {
 	openError      error
 	writePartError error
@@ -154,6 +221,8 @@ type mockSender struct {
 	closeError     error
 	isOpen         bool
 	buf            []byte
+	cancelled      bool
+	cancelCtxError error
 }
 
 func (s *mockSender) OpenWithContext(ctx context.Context, uri string, opt map[string]string) error {
@@ -197,6 +266,8 @@ func (s *mockSender) WritePartWithContext(ctx context.Context, input io.ReadSeek
 }
 
 func (s *mockSender) CancelWithContext(ctx context.Context) error {
+	s.cancelled = true
+	s.cancelCtxError = ctx.Err()
 	return s.cancelError
 }
 
@@ -3,36 +3,27 @@ package netio
 import (
 	"bytes"
 	"context"
-	"io/ioutil"
-	"log"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
 	"net/url"
-	"os"
-	"path"
 	"path/filepath"
 )
 
-// UploaderSimple is mostly for testing purposes to store locally
-// whats downloaded by different schemas to verify
+// UploaderSimple writes the data sequentially to the local file system.
+// It is mostly for testing purposes to store locally whats downloaded
+// by different schemas to verify.
 type UploaderSimple struct {
 }
 
 func (f UploaderSimple) Upload(ctx context.Context, uri string, options map[string]string,
 	data chan dlData, s sender) error {
 
-	tempDir, err := ioutil.TempDir(os.TempDir(), "")
+	filePath, err := localPath(uri)
 	if err != nil {
 		return err
 	}
 
-	uploadUrl, err := url.Parse(uri)
-	if err != nil {
-		return err
-	}
-
-	fileName := path.Base(uploadUrl.Path)
-	tempFilePath := filepath.Join(tempDir, fileName)
-
-	if err := s.OpenWithContext(context.Background(), tempFilePath, options); err != nil {
+	if err := s.OpenWithContext(context.Background(), filePath, options); err != nil {
 		return err
 	}
 	defer s.CloseWithContext(context.Background())
@@ -43,17 +34,39 @@ func (f UploaderSimple) Upload(ctx context.Context, uri string, options map[stri
 		select {
 		case chunk, ok := <-data:
 			if !ok { // channel closed
-				log.Println("Upload finished. Total size:", totalBytes)
+				logging.FromContext(ctx).Debug("upload finished", "bytes", totalBytes)
+				recordPart(ctx)
 				return nil
 			}
 			reader := bytes.NewReader(chunk.data)
-			_, err := s.WritePartWithContext(context.Background(), reader, map[string]string{})
+			_, err := s.WritePartWithContext(ctx, reader, map[string]string{})
 			if err != nil {
 				return err
 			}
 			totalBytes += uint64(len(chunk.data))
+			reportProgress(ctx, int64(len(chunk.data)))
 		case <-ctx.Done(): // there is cancellation
 			return ctx.Err()
 		}
 	}
 }
+
+// localPath returns the file path of file url. Both absolute
+// (file:///tmp/file) and relative (file://./file) paths are accepted.
+func localPath(uri string) (string, error) {
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+
+	p := u.Host + u.Path
+	if p == "" {
+		p = u.Opaque // file:relative/file
+	}
+	if p == "" {
+		return "", fmt.Errorf("no file path in %v", uri)
+	}
+
+	return filepath.FromSlash(p), nil
+}
@@ -50,3 +50,26 @@ func TestSimpleUploaderHappyPath(t *testing.T) {
 		t.Fatalf("invalid content")
 	}
 }
+
+func TestLocalPath(t *testing.T) {
+
+	tests := map[string]string{
+		"file:///tmp/octopus/key.mp4": "/tmp/octopus/key.mp4",
+		"file://./key.mp4":            "./key.mp4",
+		"file:key.mp4":                "key.mp4",
+	}
+
+	for uri, expected := range tests {
+		p, err := localPath(uri)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", uri, err)
+		}
+		if p != expected {
+			t.Fatalf("expected %v path to be %v but got %v", uri, expected, p)
+		}
+	}
+
+	if _, err := localPath("file://"); err == nil {
+		t.Fatalf("expected localPath() to return an error for empty path")
+	}
+}
@@ -63,6 +63,12 @@ func TestKnownSenderScheme(t *testing.T) {
 		t.Fatal("expected type S3SenderMultipart")
 	}
 
+	// the size of the compressed data is only known in the end
+	iface, _ = getSender("s3", -1)
+	if _, ok = iface.(*S3SenderMultipart); !ok {
+		t.Fatal("expected type S3SenderMultipart for unknown size")
+	}
+
 	iface, _ = getSender("file", 0)
 	if iface == nil {
 		t.Fatal("expected local file sender instance but received nil")
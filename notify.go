@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// webhook request headers
+const (
+	webhookEventHeader     = "X-Octopus-Event"
+	webhookDeliveryHeader  = "X-Octopus-Delivery"
+	webhookTimestampHeader = "X-Octopus-Timestamp"
+	webhookSignatureHeader = "X-Octopus-Signature"
+)
+
+// jobNotification is the webhook payload sent when a job changes its status
+type jobNotification struct {
+	Event     string     `json:"event"` // new job status
+	Time      time.Time  `json:"time"`
+	Job       job        `json:"job"`
+	Result    *jobResult `json:"result,omitempty"`
+	Files     *jobFiles  `json:"files,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ErrorCode string     `json:"errorCode,omitempty"`
+}
+
+// notifier posts the job notifications to the webhooks in the background
+// retrying failed deliveries. A nil notifier (no webhooks) ignores the calls.
+type notifier struct {
+	urls     []string
+	secret   string
+	events   map[string]bool // nil for all
+	attempts int
+	backoff  time.Duration
+	client   *http.Client
+	wg       sync.WaitGroup
+}
+
+// newNotifier returns nil if there are no webhooks configured
+func newNotifier(s settings) *notifier {
+	if len(s.WebhookUrls) == 0 {
+		return nil
+	}
+	var events map[string]bool
+	for _, e := range s.WebhookEvents {
+		if events == nil {
+			events = make(map[string]bool)
+		}
+		events[e] = true
+	}
+	return &notifier{
+		urls:     s.WebhookUrls,
+		secret:   s.WebhookSecret,
+		events:   events,
+		attempts: s.WebhookAttempts,
+		backoff:  s.WebhookBackoff,
+		client:   &http.Client{Timeout: s.WebhookTimeout},
+	}
+}
+
+// notify sends the notification to every webhook unless its event is filtered out
+func (n *notifier) notify(ctx context.Context, msg jobNotification) {
+
+	if n == nil || (n.events != nil && !n.events[msg.Event]) {
+		return
+	}
+
+	logger := logging.FromContext(ctx)
+
+	msg.Time = time.Now().UTC()
+	msg.Job = msg.Job.redacted() // the webhooks may be run by third parties
+	msg.Job.Status = msg.Event
+	body, err := json.Marshal(msg)
+	if err != nil {
+		logger.Error("can't encode notification", "event", msg.Event, "err", err)
+		return
+	}
+
+	for _, u := range n.urls {
+		n.wg.Add(1)
+		go func(u string) {
+			defer n.wg.Done()
+			if err := n.deliver(u, msg.Event, body); err != nil {
+				webhookDeliveries.With("failure").Inc()
+				logger.Warn("can't deliver notification", "event", msg.Event, "url", u, "err", err)
+				return
+			}
+			webhookDeliveries.With("success").Inc()
+		}(u)
+	}
+}
+
+// deliver posts the body up to n.attempts times doubling the backoff after
+// every failed attempt; the client errors (4xx except 408 and 429) are not retried
+func (n *notifier) deliver(url string, event string, body []byte) error {
+
+	// the receivers can tell the retries of the same delivery by its id
+	var id [16]byte
+	rand.Read(id[:])
+	delivery := hex.EncodeToString(id[:])
+
+	backoff := n.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = n.post(url, event, delivery, body)
+		if err == nil || !retry || attempt >= n.attempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one webhook request; it returns true if a failed one can be retried
+func (n *notifier) post(url string, event string, delivery string, body []byte) (bool, error) {
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookDeliveryHeader, delivery)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	if n.secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(n.secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("webhook responded %v", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook responded %v", resp.Status)
+	}
+}
+
+// webhookSignature returns hex encoded HMAC-SHA256 of "<timestamp>.<body>";
+// signing the timestamp lets the receivers reject replayed requests
+func webhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// wait waits for the deliveries in progress until ctx is done
+func (n *notifier) wait(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
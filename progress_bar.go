@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressBarWidth   = 30
+	progressBarRefresh = 200 * time.Millisecond
+)
+
+// progressBar renders transfer progress on a terminal:
+//
+//	[=============>                ]  45%  12.3 MiB / 27.1 MiB  5.2 MiB/s
+type progressBar struct {
+	done    int64 // first to be 64-bit aligned for atomic access
+	total   int64
+	w       io.Writer
+	begin   time.Time
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{
+		w:       w,
+		begin:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// update is netio.ProgressFunc
+func (b *progressBar) update(done int64, total int64) {
+	atomic.StoreInt64(&b.done, done)
+	atomic.StoreInt64(&b.total, total)
+}
+
+// start redraws the bar periodically until finish is called
+func (b *progressBar) start() {
+	go func() {
+		defer close(b.stopped)
+		ticker := time.NewTicker(progressBarRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.render()
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+}
+
+// finish draws the final state and moves to the next line
+func (b *progressBar) finish() {
+	close(b.stop)
+	<-b.stopped
+	b.render()
+	fmt.Fprintln(b.w)
+}
+
+func (b *progressBar) render() {
+	fmt.Fprint(b.w, "\r"+b.line(atomic.LoadInt64(&b.done), atomic.LoadInt64(&b.total), time.Since(b.begin)))
+}
+
+func (b *progressBar) line(done int64, total int64, elapsed time.Duration) string {
+
+	var rate int64
+	if elapsed > 0 {
+		rate = int64(float64(done) / elapsed.Seconds())
+	}
+
+	if total <= 0 { // size unknown
+		return fmt.Sprintf("%v  %v/s", formatBytes(done), formatBytes(rate))
+	}
+
+	percent := done * 100 / total
+	if percent > 100 {
+		percent = 100
+	}
+
+	filled := int(percent) * progressBarWidth / 100
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+
+	return fmt.Sprintf("[%v] %3d%%  %v / %v  %v/s",
+		bar, percent, formatBytes(done), formatBytes(total), formatBytes(rate))
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"github.com/viktorburka/octopus/logging"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// tunableSettings are applied by the running agent when it reloads
+// the settings; changing the others takes a restart
+var tunableSettings = map[string]bool{
+	"maxJobs":           true,
+	"eventLoopSleep":    true,
+	"bandwidthLimit":    true,
+	"heartbeatInterval": true,
+	"maxAttempts":       true,
+	"retryBackoff":      true,
+}
+
+// reloadLoop loads the settings on every signal from hup and sends them
+// to reloads if any of the tunable ones has changed until ctx is done
+func reloadLoop(ctx context.Context, s settings, hup <-chan os.Signal, load func() (settings, error),
+	reloads chan<- settings) {
+
+	logger := logging.FromContext(ctx)
+
+	for {
+		select {
+		case <-hup:
+		case <-ctx.Done():
+			return
+		}
+
+		reloaded, err := load()
+		if err != nil {
+			logger.Error("can't reload settings", "err", err)
+			continue
+		}
+
+		var tuned []string
+		for _, key := range changedSettings(s, reloaded) {
+			if tunableSettings[key] {
+				tuned = append(tuned, key)
+			} else {
+				logger.Warn("setting change requires restart", "setting", key)
+			}
+		}
+		if len(tuned) == 0 {
+			logger.Info("settings reloaded, nothing to apply")
+			continue
+		}
+
+		s = applyTunables(s, reloaded)
+		logger.Info("applying reloaded settings", "settings", strings.Join(tuned, ","))
+
+		select {
+		case reloads <- s:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changedSettings returns the keys of the settings that differ
+func changedSettings(old settings, new settings) []string {
+	oldFields, newFields := settingsFields(&old), settingsFields(&new)
+	var keys []string
+	for i, f := range oldFields {
+		if !reflect.DeepEqual(f.value.Interface(), newFields[i].value.Interface()) {
+			keys = append(keys, f.key)
+		}
+	}
+	return keys
+}
+
+// applyTunables returns s with the tunable settings of reloaded
+func applyTunables(s settings, reloaded settings) settings {
+	newFields := settingsFields(&reloaded)
+	for i, f := range settingsFields(&s) {
+		if tunableSettings[f.key] {
+			f.value.Set(newFields[i].value)
+		}
+	}
+	return s
+}
+
+// bandwidthLimit returns BandwidthLimit in bytes per second, 0 if unlimited
+func bandwidthLimit(s settings) int64 {
+	if s.BandwidthLimit == "" {
+		return 0
+	}
+	limit, err := parseSize(s.BandwidthLimit)
+	if err != nil {
+		return 0 // validated by loadSettings
+	}
+	return limit
+}
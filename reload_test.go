@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadLoop(t *testing.T) {
+
+	s := settings{MaxJobs: 3, EventLoopSleep: time.Second, Store: "mongo", Queues: []string{defaultQueue}}
+
+	var loaded []settings
+	var loadErr error
+	load := func() (settings, error) {
+		n := loaded[0]
+		loaded = loaded[1:]
+		return n, loadErr
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal)
+	reloads := make(chan settings)
+	done := make(chan struct{})
+
+	// the store takes a restart and is left as it was
+	changed := s
+	changed.MaxJobs = 5
+	changed.BandwidthLimit = "10M"
+	changed.Store = "file"
+	unchanged := s
+	unchanged.Store = "file"
+	loaded = []settings{unchanged, changed}
+
+	go func() {
+		defer close(done)
+		reloadLoop(ctx, s, hup, load, reloads)
+	}()
+
+	hup <- syscall.SIGHUP
+	hup <- syscall.SIGHUP
+	reloaded := <-reloads
+
+	expected := s
+	expected.MaxJobs = 5
+	expected.BandwidthLimit = "10M"
+	if !reflect.DeepEqual(reloaded, expected) {
+		t.Fatalf("expected\n%+v\nbut got\n%+v", expected, reloaded)
+	}
+
+	// the invalid settings are not applied
+	loadErr = errors.New("invalid configuration")
+	loaded = []settings{changed}
+	hup <- syscall.SIGHUP
+	select {
+	case reloaded := <-reloads:
+		t.Fatalf("expected the invalid settings to be ignored but got %+v", reloaded)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	<-done
+}
+
+func TestEventLoopReload(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	s := settings{DbOpTimeout: time.Second, EventLoopSleep: time.Hour, MaxJobs: 1,
+		ShutdownMode: shutdownDrain, Queues: []string{defaultQueue}}
+	health := newAgentHealth(s.MaxJobs, s.EventLoopSleep)
+	registry := newAgentRegistry(s)
+	reloads := make(chan settings)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func(s settings) {
+		defer close(done)
+		eventLoop(ctx, store, s, health, nil, registry, reloads)
+	}(s)
+
+	reloaded := s
+	reloaded.MaxJobs = 4
+	reloaded.EventLoopSleep = 10 * time.Millisecond
+	reloads <- reloaded
+
+	// the loop ticks at the new interval
+	deadline := time.Now().Add(time.Second)
+	for {
+		lastTick, _, maxJobs := health.state()
+		if maxJobs == 4 && time.Since(lastTick) < 50*time.Millisecond {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the loop to run 4 jobs ticking every 10ms but got %v jobs, tick %v ago",
+				maxJobs, time.Since(lastTick))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if info := registry.snapshot(time.Now()); info.Slots != 4 {
+		t.Fatalf("expected the registration to have 4 slots but got %v", info.Slots)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestHealthzAfterReload(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	s := settings{DbOpTimeout: time.Second, EventLoopSleep: 10 * time.Millisecond, MaxJobs: 1,
+		ShutdownMode: shutdownDrain, Queues: []string{defaultQueue}}
+	health := newAgentHealth(s.MaxJobs, s.EventLoopSleep)
+	handler := newHttpHandler(store, s, health)
+	reloads := make(chan settings)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func(s settings) {
+		defer close(done)
+		eventLoop(ctx, store, s, health, nil, newAgentRegistry(s), reloads)
+	}(s)
+
+	reloaded := s
+	reloaded.EventLoopSleep = time.Hour
+	reloads <- reloaded
+
+	// the loop doesn't tick for much longer than the startup interval allows
+	time.Sleep(stalledLoopTicks * 3 * s.EventLoopSleep)
+	if code, status := getHealth(t, handler, "/healthz"); code != http.StatusOK {
+		t.Fatalf("expected the loop ticking at the reloaded interval to be healthy but got %v %+v", code, status)
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// schedule is a recurring job definition. Every time it fires
+// a fresh job document is created from it in the jobs collection
+type schedule struct {
+	Id          primitive.ObjectID `json:"_id"                bson:"_id"`
+	Cron        string             `json:"cron"               bson:"cron"`
+	Timezone    string             `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	SrcUrl      string             `json:"srcUrl"             bson:"srcUrl"`
+	DstUrl      string             `json:"dstUrl"             bson:"dstUrl"`
+	Description string             `json:"description"        bson:"description"`
+	Queue       string             `json:"queue,omitempty"    bson:"queue,omitempty"`
+	Requires    []string           `json:"requires,omitempty" bson:"requires,omitempty"`
+	Options     map[string]string  `json:"options,omitempty"  bson:"options,omitempty"`
+	Disabled    bool               `json:"disabled,omitempty" bson:"disabled,omitempty"`
+	NextRun     *time.Time         `json:"nextRun,omitempty"  bson:"nextRun,omitempty"`
+	LastRun     *time.Time         `json:"lastRun,omitempty"  bson:"lastRun,omitempty"`
+}
+
+// nextRun calculates the schedule activation time following t
+func (sc *schedule) nextRun(t time.Time) (time.Time, error) {
+	c, err := parseCron(sc.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.UTC
+	if sc.Timezone != "" {
+		if loc, err = time.LoadLocation(sc.Timezone); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %q: %v", sc.Timezone, err)
+		}
+	}
+	next := c.next(t.In(loc))
+	if next.IsZero() {
+		return next, fmt.Errorf("cron expression %q never fires", sc.Cron)
+	}
+	return next.UTC(), nil
+}
+
+func scheduleLoop(ctx context.Context, store JobStore, s settings) {
+
+	ticker := time.NewTicker(s.ScheduleInterval)
+	defer ticker.Stop() // to prevent ticker goroutine leak
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := spawnScheduledJobs(ctx, store, s); err != nil {
+				logging.FromContext(ctx).Error("can't spawn scheduled jobs", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// spawnScheduledJobs creates a job for every schedule that is due and then
+// advances the schedule. The job id is derived from the schedule and its run
+// time so the agents running it concurrently, or again after the schedule
+// failed to advance, create the job only once; a job that failed to be
+// created is created on the next pass as the schedule is still due.
+func spawnScheduledJobs(ctx context.Context, store JobStore, s settings) error {
+
+	timeout, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	due, err := store.DueSchedules(timeout, now)
+	if err != nil {
+		return fmt.Errorf("can't query schedules: %v", err)
+	}
+
+	for _, sc := range due {
+
+		next, err := sc.nextRun(now)
+		if err != nil {
+			logging.FromContext(ctx).Error("can't compute next run", "schedule", sc.Id.Hex(), "err", err)
+			continue
+		}
+
+		// a new definition has to wait for its first activation
+		if sc.NextRun != nil {
+			scheduleId := sc.Id
+			newJob := job{
+				Id:          scheduledJobId(sc.Id, *sc.NextRun),
+				Status:      created,
+				SrcUrl:      sc.SrcUrl,
+				DstUrl:      sc.DstUrl,
+				Description: sc.Description,
+				ScheduleId:  &scheduleId,
+				Queue:       sc.Queue,
+				Requires:    sc.Requires,
+				Options:     sc.Options,
+			}
+			err := store.Insert(timeout, newJob)
+			switch {
+			case err == nil:
+				logging.FromContext(ctx).Info("created scheduled job", "schedule", sc.Id.Hex(),
+					"job", newJob.Id.Hex(), "nextRun", next)
+			case errors.Is(err, errJobExists): // another agent or an earlier pass created it
+			default:
+				return fmt.Errorf("can't create job for schedule %v: %v", sc.Id.Hex(), err)
+			}
+		}
+
+		if _, err := store.AdvanceSchedule(timeout, sc.Id, sc.NextRun, next); err != nil {
+			return fmt.Errorf("can't update schedule %v: %v", sc.Id.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+// scheduledJobId returns the id of the job of the schedule run. Like the
+// generated ids it starts with the timestamp, the run time in this case.
+func scheduledJobId(scheduleId primitive.ObjectID, run time.Time) primitive.ObjectID {
+
+	var runTime [8]byte
+	binary.BigEndian.PutUint64(runTime[:], uint64(run.UnixNano()))
+	sum := sha256.Sum256(append(scheduleId[:], runTime[:]...))
+
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[:4], uint32(run.Unix()))
+	copy(id[4:], sum[:])
+	return id
+}
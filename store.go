@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// JobStore keeps jobs and schedules and
+// implements atomic job state transitions
+type JobStore interface {
+	// Claim marks a job that is ready to run and is eligible for
+	// the agent as running and returns it; nil if there is none
+	Claim(ctx context.Context, req claimRequest) (*job, error)
+	// Progress records the number of bytes transferred so far
+	Progress(ctx context.Context, id primitive.ObjectID, done int64, total int64) error
+	// Files records the outcome of the directory transfer
+	Files(ctx context.Context, id primitive.ObjectID, files jobFiles) error
+	// Result records the outcome of the file transfer
+	Result(ctx context.Context, id primitive.ObjectID, result jobResult) error
+	// Complete marks the running job as complete. It returns
+	// errInvalidJobState if the job isn't running (cancelled).
+	Complete(ctx context.Context, id primitive.ObjectID) error
+	// Fail marks the job as failed along with all the jobs depending on it
+	Fail(ctx context.Context, id primitive.ObjectID, code string, reason string) error
+	// Release puts the running job back to the queue. Nothing of the
+	// transfer is kept: the job is started over from the beginning.
+	Release(ctx context.Context, id primitive.ObjectID) error
+	// ReleaseStale releases the running jobs with the heartbeat
+	// before the given time and returns their number
+	ReleaseStale(ctx context.Context, before time.Time) (int64, error)
+	// Reschedule puts the running job failed with a temporary error back
+	// to the queue not to run before notBefore and counts the attempt
+	Reschedule(ctx context.Context, id primitive.ObjectID, code string, reason string, notBefore time.Time) error
+	// Heartbeat tells that the running job is still being worked on.
+	// It returns false if the job is not running anymore (cancelled).
+	Heartbeat(ctx context.Context, id primitive.ObjectID) (bool, error)
+	// Get returns errJobNotFound if there is no such job
+	Get(ctx context.Context, id primitive.ObjectID) (*job, error)
+	List(ctx context.Context, filter jobFilter) ([]job, error)
+	Insert(ctx context.Context, j job) error
+	// Cancel cancels the created or running job. The agent
+	// running the job interrupts it on the next heartbeat.
+	Cancel(ctx context.Context, id primitive.ObjectID) error
+	// Retry puts the failed or cancelled job back to the queue
+	Retry(ctx context.Context, id primitive.ObjectID) error
+	// Purge deletes the finished jobs matching the filter except
+	// the ones the created jobs depend on and returns their number
+	Purge(ctx context.Context, filter purgeFilter) (int64, error)
+
+	// DueSchedules returns the enabled schedules with nextRun before now
+	// or without nextRun at all (the schedules never activated before)
+	DueSchedules(ctx context.Context, now time.Time) ([]schedule, error)
+	// AdvanceSchedule moves the schedule nextRun from prev to next if
+	// it still equals prev. It returns false if it doesn't (another
+	// agent has advanced it already).
+	AdvanceSchedule(ctx context.Context, id primitive.ObjectID, prev *time.Time, next time.Time) (bool, error)
+
+	// RegisterAgent creates or replaces the agent document
+	RegisterAgent(ctx context.Context, a agentInfo) error
+	// Agents returns the registered agents ordered by id
+	Agents(ctx context.Context) ([]agentInfo, error)
+	// RemoveAgent deletes the document of the agent shutting down
+	RemoveAgent(ctx context.Context, id string) error
+
+	// Watch notifies about the jobs that may have become ready
+	// to run until ctx is done. It returns an error right away
+	// if the store doesn't support notifications.
+	Watch(ctx context.Context, changes chan<- struct{}) error
+	// Ping checks that the store is reachable
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+var (
+	errJobNotFound     = errors.New("job not found")
+	errInvalidJobState = errors.New("invalid job state")
+	errJobExists       = errors.New("job already exists")
+)
+
+// claimRequest describes the jobs the agent is eligible to run
+type claimRequest struct {
+	Now          time.Time
+	Queues       []string
+	Capabilities []string
+}
+
+type jobFilter struct {
+	Status []string
+	Limit  int64
+}
+
+// purgeFilter selects the jobs in one of the Status
+// (complete, failed or cancelled) created before Before
+type purgeFilter struct {
+	Status []string
+	Before time.Time
+}
+
+// finishedStatuses are the statuses of the jobs that may be purged
+var finishedStatuses = []string{complete, failed, cancelled}
+
+func (f purgeFilter) validate() error {
+	for _, st := range f.Status {
+		if st != complete && st != failed && st != cancelled {
+			return fmt.Errorf("can't purge %v jobs", st)
+		}
+	}
+	return nil
+}
+
+func newClaimRequest(s settings) claimRequest {
+	return claimRequest{
+		Now:          time.Now().UTC(),
+		Queues:       s.Queues,
+		Capabilities: s.Capabilities,
+	}
+}
+
+func newJobStore(ctx context.Context, s settings) (JobStore, error) {
+	switch s.Store {
+	case "mongo":
+		return newMongoStore(ctx, s)
+	case "file":
+		return newFileStore(s.StorePath), nil
+	default:
+		return nil, fmt.Errorf("job store %q is not supported", s.Store)
+	}
+}
+
+// eligible tells if the job matches the claim request
+// except for its dependencies (see dependencyState)
+func (r claimRequest) eligible(j *job) bool {
+
+	if j.Status != created {
+		return false
+	}
+	if j.NotBefore != nil && j.NotBefore.After(r.Now) {
+		return false
+	}
+
+	caps := make(map[string]bool, len(r.Capabilities))
+	for _, c := range r.Capabilities {
+		caps[c] = true
+	}
+	for _, c := range j.Requires {
+		if !caps[c] {
+			return false
+		}
+	}
+
+	queue := j.Queue
+	if queue == "" {
+		queue = defaultQueue
+	}
+	for _, q := range r.Queues {
+		if q == anyQueue || q == queue {
+			return true
+		}
+	}
+
+	return false
+}
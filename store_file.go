@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fileStore keeps jobs, schedules and agents in a local JSON file so that
+// the agent can run standalone without MongoDB server. The file is
+// guarded by an OS lock on the lock file next to it to allow other
+// processes (octopus CLI) to modify it while the agent is running.
+type fileStore struct {
+	m        sync.Mutex
+	path     string
+	lockFile *os.File // held between lock and unlock
+}
+
+type fileStoreData struct {
+	Jobs      []job       `json:"jobs"`
+	Schedules []schedule  `json:"schedules"`
+	Agents    []agentInfo `json:"agents,omitempty"`
+}
+
+func newFileStore(path string) *fileStore {
+	return &fileStore{path: path}
+}
+
+func (f *fileStore) Claim(ctx context.Context, req claimRequest) (*job, error) {
+
+	var claimed *job
+
+	err := f.update(ctx, func(d *fileStoreData) error {
+
+		for i := range d.Jobs {
+
+			j := &d.Jobs[i]
+			if !req.eligible(j) {
+				continue
+			}
+
+			state, reason := dependencyState(j.DependsOn, d.status)
+			switch state {
+			case dependenciesPending:
+				continue
+			case dependenciesFailed:
+				d.fail(j.Id, created, dependencyFailedCode, reason)
+				continue
+			}
+
+			now := req.Now
+			j.Status = running
+			j.Heartbeat = &now
+
+			cp := *j
+			claimed = &cp
+			return nil
+		}
+
+		return nil
+	})
+
+	return claimed, err
+}
+
+func (f *fileStore) Progress(ctx context.Context, id primitive.ObjectID, done int64, total int64) error {
+	return f.updateJob(ctx, id, func(j *job) {
+		j.Progress = &jobProgress{Bytes: done, Total: total}
+	})
+}
+
+func (f *fileStore) Files(ctx context.Context, id primitive.ObjectID, files jobFiles) error {
+	return f.updateJob(ctx, id, func(j *job) {
+		j.Files = &files
+	})
+}
+
+func (f *fileStore) Result(ctx context.Context, id primitive.ObjectID, result jobResult) error {
+	return f.updateJob(ctx, id, func(j *job) {
+		j.Result = &result
+	})
+}
+
+func (f *fileStore) Complete(ctx context.Context, id primitive.ObjectID) error {
+	return f.transition(ctx, id, []string{running}, func(j *job) {
+		j.Status = complete
+	})
+}
+
+func (f *fileStore) Fail(ctx context.Context, id primitive.ObjectID, code string, reason string) error {
+	return f.update(ctx, func(d *fileStoreData) error {
+		d.fail(id, running, code, reason)
+		return nil
+	})
+}
+
+func (f *fileStore) Release(ctx context.Context, id primitive.ObjectID) error {
+	return f.updateJob(ctx, id, func(j *job) {
+		if j.Status == running {
+			release(j)
+		}
+	})
+}
+
+func (f *fileStore) ReleaseStale(ctx context.Context, before time.Time) (int64, error) {
+
+	var released int64
+
+	err := f.update(ctx, func(d *fileStoreData) error {
+		for i := range d.Jobs {
+			j := &d.Jobs[i]
+			if j.Status == running && j.Heartbeat != nil && j.Heartbeat.Before(before) {
+				release(j)
+				released++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return released, nil
+}
+
+// release puts the running job back to the queue
+func release(j *job) {
+	j.Status = created
+	j.Error = ""
+	j.ErrorCode = ""
+	j.Heartbeat = nil
+	j.Progress = nil
+	j.Files = nil
+	j.Result = nil
+}
+
+func (f *fileStore) Reschedule(ctx context.Context, id primitive.ObjectID, code string, reason string, notBefore time.Time) error {
+	return f.updateJob(ctx, id, func(j *job) {
+		if j.Status == running {
+			j.Status = created
+			j.Error = reason
+			j.ErrorCode = code
+			j.NotBefore = &notBefore
+			j.Attempts++
+			j.Heartbeat = nil
+			j.Progress = nil
+			j.Files = nil
+			j.Result = nil
+		}
+	})
+}
+
+func (f *fileStore) Heartbeat(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	isRunning := true
+	err := f.updateJob(ctx, id, func(j *job) {
+		isRunning = j.Status == running
+		if isRunning {
+			now := time.Now().UTC()
+			j.Heartbeat = &now
+		}
+	})
+	return isRunning, err
+}
+
+func (f *fileStore) Get(ctx context.Context, id primitive.ObjectID) (*job, error) {
+
+	d, err := f.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	j, ok := d.job(id)
+	if !ok {
+		return nil, errJobNotFound
+	}
+
+	return j, nil
+}
+
+func (f *fileStore) Cancel(ctx context.Context, id primitive.ObjectID) error {
+	return f.transition(ctx, id, []string{created, running}, func(j *job) {
+		j.Status = cancelled
+	})
+}
+
+func (f *fileStore) Retry(ctx context.Context, id primitive.ObjectID) error {
+	return f.transition(ctx, id, []string{failed, cancelled}, func(j *job) {
+		j.Status = created
+		j.Error = ""
+		j.ErrorCode = ""
+		j.Heartbeat = nil
+		j.Progress = nil
+		j.Files = nil
+		j.Result = nil
+		j.Attempts = 0
+	})
+}
+
+// transition applies fn to the job if it is in one of the given statuses
+func (f *fileStore) transition(ctx context.Context, id primitive.ObjectID, from []string, fn func(j *job)) error {
+	return f.update(ctx, func(d *fileStoreData) error {
+		j, ok := d.job(id)
+		if !ok {
+			return errJobNotFound
+		}
+		for _, status := range from {
+			if j.Status == status {
+				fn(j)
+				return nil
+			}
+		}
+		return errInvalidJobState
+	})
+}
+
+func (f *fileStore) List(ctx context.Context, filter jobFilter) ([]job, error) {
+
+	d, err := f.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]bool)
+	for _, s := range filter.Status {
+		statuses[s] = true
+	}
+
+	var jobs []job
+	for _, j := range d.Jobs {
+		if filter.Limit > 0 && int64(len(jobs)) >= filter.Limit {
+			break
+		}
+		if len(statuses) == 0 || statuses[j.Status] {
+			jobs = append(jobs, j)
+		}
+	}
+
+	return jobs, nil
+}
+
+func (f *fileStore) Insert(ctx context.Context, j job) error {
+	return f.update(ctx, func(d *fileStoreData) error {
+		if _, ok := d.job(j.Id); ok {
+			return fmt.Errorf("%w: %v", errJobExists, j.Id.Hex())
+		}
+		d.Jobs = append(d.Jobs, j)
+		return nil
+	})
+}
+
+func (f *fileStore) Purge(ctx context.Context, filter purgeFilter) (int64, error) {
+
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+
+	statuses := filter.Status
+	if len(statuses) == 0 {
+		statuses = finishedStatuses
+	}
+
+	var purged int64
+
+	err := f.update(ctx, func(d *fileStoreData) error {
+
+		// the jobs waiting for dependencies would fail if those were gone
+		deps := make(map[primitive.ObjectID]bool)
+		for _, j := range d.Jobs {
+			if j.Status == created {
+				for _, id := range j.DependsOn {
+					deps[id] = true
+				}
+			}
+		}
+
+		kept := d.Jobs[:0]
+		for _, j := range d.Jobs {
+			if !deps[j.Id] && hasStatus(j, statuses) &&
+				(filter.Before.IsZero() || jobCreatedAt(j.Id).Before(filter.Before)) {
+				purged++
+				continue
+			}
+			kept = append(kept, j)
+		}
+		d.Jobs = kept
+
+		return nil
+	})
+
+	return purged, err
+}
+
+func (f *fileStore) DueSchedules(ctx context.Context, now time.Time) ([]schedule, error) {
+
+	d, err := f.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []schedule
+	for _, sc := range d.Schedules {
+		if !sc.Disabled && (sc.NextRun == nil || !sc.NextRun.After(now)) {
+			due = append(due, sc)
+		}
+	}
+
+	return due, nil
+}
+
+func (f *fileStore) AdvanceSchedule(ctx context.Context, id primitive.ObjectID,
+	prev *time.Time, next time.Time) (bool, error) {
+
+	advanced := false
+
+	err := f.update(ctx, func(d *fileStoreData) error {
+		for i := range d.Schedules {
+			sc := &d.Schedules[i]
+			if sc.Id != id {
+				continue
+			}
+			if (prev == nil) != (sc.NextRun == nil) || prev != nil && !prev.Equal(*sc.NextRun) {
+				return nil
+			}
+			if prev != nil {
+				lastRun := *prev
+				sc.LastRun = &lastRun
+			}
+			sc.NextRun = &next
+			advanced = true
+		}
+		return nil
+	})
+
+	return advanced, err
+}
+
+func (f *fileStore) RegisterAgent(ctx context.Context, a agentInfo) error {
+	return f.update(ctx, func(d *fileStoreData) error {
+		for i := range d.Agents {
+			if d.Agents[i].Id == a.Id {
+				d.Agents[i] = a
+				return nil
+			}
+		}
+		d.Agents = append(d.Agents, a)
+		sort.Slice(d.Agents, func(i, j int) bool { return d.Agents[i].Id < d.Agents[j].Id })
+		return nil
+	})
+}
+
+func (f *fileStore) Agents(ctx context.Context) ([]agentInfo, error) {
+	d, err := f.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d.Agents, nil
+}
+
+func (f *fileStore) RemoveAgent(ctx context.Context, id string) error {
+	return f.update(ctx, func(d *fileStoreData) error {
+		for i := range d.Agents {
+			if d.Agents[i].Id == id {
+				d.Agents = append(d.Agents[:i], d.Agents[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (f *fileStore) Watch(ctx context.Context, changes chan<- struct{}) error {
+	return fmt.Errorf("file store doesn't support change notifications")
+}
+
+func (f *fileStore) Ping(ctx context.Context) error {
+	_, err := f.read(ctx)
+	return err
+}
+
+func (f *fileStore) Close(ctx context.Context) error {
+	return nil
+}
+
+func (f *fileStore) updateJob(ctx context.Context, id primitive.ObjectID, fn func(j *job)) error {
+	return f.update(ctx, func(d *fileStoreData) error {
+		j, ok := d.job(id)
+		if !ok {
+			return errJobNotFound
+		}
+		fn(j)
+		return nil
+	})
+}
+
+func (f *fileStore) read(ctx context.Context) (*fileStoreData, error) {
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if err := f.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer f.unlock()
+
+	return f.load()
+}
+
+// update loads the file, applies fn and saves the result
+// unless fn returns an error; all under the lock
+func (f *fileStore) update(ctx context.Context, fn func(d *fileStoreData) error) error {
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if err := f.lock(ctx); err != nil {
+		return err
+	}
+	defer f.unlock()
+
+	d, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(d); err != nil {
+		return err
+	}
+
+	return f.save(d)
+}
+
+func (f *fileStore) load() (*fileStoreData, error) {
+
+	var d fileStoreData
+
+	buf, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) { // nothing stored yet
+		return &d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(buf, &d); err != nil {
+		return nil, fmt.Errorf("can't parse %v: %v", f.path, err)
+	}
+
+	return &d, nil
+}
+
+// save writes a temp file first and replaces the store file
+// with it to not end up with a half written file on crash
+func (f *fileStore) save(d *fileStoreData) error {
+
+	buf, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// lock takes the OS lock of the lock file. The lock is released
+// by the OS when the process exits so a crash doesn't leave it
+// behind; the lock file itself is kept.
+func (f *fileStore) lock(ctx context.Context) error {
+
+	file, err := os.OpenFile(f.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if locked {
+			f.lockFile = file
+			return nil
+		}
+
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			file.Close()
+			return fmt.Errorf("can't lock %v: %v", f.path, ctx.Err())
+		}
+	}
+}
+
+func (f *fileStore) unlock() {
+	unlockFile(f.lockFile)
+	f.lockFile.Close()
+	f.lockFile = nil
+}
+
+func hasStatus(j job, statuses []string) bool {
+	for _, st := range statuses {
+		if j.Status == st {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *fileStoreData) job(id primitive.ObjectID) (*job, bool) {
+	for i := range d.Jobs {
+		if d.Jobs[i].Id == id {
+			return &d.Jobs[i], true
+		}
+	}
+	return nil, false
+}
+
+func (d *fileStoreData) status(id primitive.ObjectID) (string, bool) {
+	j, ok := d.job(id)
+	if !ok {
+		return "", false
+	}
+	return j.Status, true
+}
+
+// fail marks the job in the given status as failed together
+// with all the jobs that depend on it directly or indirectly
+func (d *fileStoreData) fail(id primitive.ObjectID, status string, code string, reason string) {
+
+	j, ok := d.job(id)
+	if !ok || j.Status != status {
+		return
+	}
+	j.Status = failed
+	j.Error = reason
+	j.ErrorCode = code
+
+	for i := range d.Jobs {
+		dep := &d.Jobs[i]
+		if dep.Status != created {
+			continue
+		}
+		for _, depId := range dep.DependsOn {
+			if depId == id {
+				d.fail(dep.Id, created, dependencyFailedCode, dependencyFailedReason(id))
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFileStore(t *testing.T) (*fileStore, func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFileStore(filepath.Join(dir, "octopus.json")), func() { os.RemoveAll(dir) }
+}
+
+func newTestJob() job {
+	return job{
+		Id:     primitive.NewObjectID(),
+		Status: created,
+		SrcUrl: "http://example.com/file.mp4",
+		DstUrl: "file:///tmp/file.mp4",
+	}
+}
+
+func TestFileStoreClaim(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	req := newClaimRequest(settings{Queues: []string{defaultQueue}})
+
+	j := newTestJob()
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := store.Claim(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Id != j.Id {
+		t.Fatalf("expected job %v to be claimed but got %v", j.Id.Hex(), claimed)
+	}
+	if claimed.Status != running || claimed.Heartbeat == nil {
+		t.Fatalf("expected claimed job to be running with heartbeat")
+	}
+
+	// nothing left to claim
+	claimed, err = store.Claim(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed != nil {
+		t.Fatalf("expected no job to be claimed but got %v", claimed.Id.Hex())
+	}
+
+	if err := store.Progress(ctx, j.Id, 50, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Complete(ctx, j.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := store.List(ctx, jobFilter{Status: []string{complete}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Progress == nil || jobs[0].Progress.Bytes != 50 {
+		t.Fatalf("expected one complete job with progress but got %v", jobs)
+	}
+}
+
+func TestFileStoreClaimEligibility(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	later := time.Now().Add(time.Hour)
+
+	delayed := newTestJob()
+	delayed.NotBefore = &later
+
+	queued := newTestJob()
+	queued.Queue = "edge"
+
+	restricted := newTestJob()
+	restricted.Requires = []string{"zone:dmz"}
+
+	for _, j := range []job{delayed, queued, restricted} {
+		if err := store.Insert(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := store.Claim(ctx, newClaimRequest(settings{Queues: []string{defaultQueue}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed != nil {
+		t.Fatalf("expected no job to be claimed but got %v", claimed.Id.Hex())
+	}
+
+	claimed, err = store.Claim(ctx, newClaimRequest(settings{Queues: []string{"edge"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Id != queued.Id {
+		t.Fatalf("expected job %v from edge queue to be claimed", queued.Id.Hex())
+	}
+
+	claimed, err = store.Claim(ctx, newClaimRequest(settings{
+		Queues:       []string{anyQueue},
+		Capabilities: []string{"zone:dmz"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Id != restricted.Id {
+		t.Fatalf("expected job %v requiring zone:dmz to be claimed", restricted.Id.Hex())
+	}
+}
+
+func TestFileStoreDependencies(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	req := newClaimRequest(settings{Queues: []string{defaultQueue}})
+
+	first := newTestJob()
+	second := newTestJob()
+	second.DependsOn = []primitive.ObjectID{first.Id}
+	third := newTestJob()
+	third.DependsOn = []primitive.ObjectID{second.Id}
+
+	// dependent jobs go first to make sure they are skipped
+	for _, j := range []job{third, second, first} {
+		if err := store.Insert(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := store.Claim(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Id != first.Id {
+		t.Fatalf("expected job %v without dependencies to be claimed", first.Id.Hex())
+	}
+
+	claimed, err = store.Claim(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed != nil {
+		t.Fatalf("expected no job to be claimed but got %v", claimed.Id.Hex())
+	}
+
+	if err := store.Complete(ctx, first.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err = store.Claim(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Id != second.Id {
+		t.Fatalf("expected job %v to be claimed after its dependency completed", second.Id.Hex())
+	}
+
+	// the job waiting for its dependencies isn't running so it doesn't fail
+	if err := store.Fail(ctx, third.Id, "Unknown", "error"); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := store.Get(ctx, third.Id); err != nil || j.Status != created {
+		t.Fatalf("expected job %v to stay created but got %+v (%v)", third.Id.Hex(), j, err)
+	}
+
+	if err := store.Fail(ctx, second.Id, "Unknown", "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := store.List(ctx, jobFilter{Status: []string{failed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected failure to cascade to the dependent job but got %v failed jobs", len(jobs))
+	}
+	for _, j := range jobs {
+		if j.Id == third.Id && (j.Error != dependencyFailedReason(second.Id) || j.ErrorCode != dependencyFailedCode) {
+			t.Fatalf("expected dependent job error %q but got %q (%v)", dependencyFailedReason(second.Id), j.Error, j.ErrorCode)
+		}
+	}
+}
+
+func TestFileStoreRelease(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	req := newClaimRequest(settings{Queues: []string{defaultQueue}})
+
+	j := newTestJob()
+	if err := store.Insert(ctx, j); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Claim(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Progress(ctx, j.Id, 5, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Release(ctx, j.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := store.Claim(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Id != j.Id {
+		t.Fatalf("expected released job %v to be claimed again", j.Id.Hex())
+	}
+	if claimed.Progress != nil {
+		t.Fatalf("expected released job to start over but got progress %+v", claimed.Progress)
+	}
+}
+
+func TestFileStorePurge(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	old := newTestJob()
+	old.Id = minObjectID(time.Now().Add(-48 * time.Hour))
+	old.Status = complete
+
+	done := newTestJob()
+	done.Status = complete
+
+	dependency := newTestJob()
+	dependency.Status = complete
+
+	waiting := newTestJob()
+	waiting.DependsOn = []primitive.ObjectID{dependency.Id}
+
+	failedJob := newTestJob()
+	failedJob.Status = failed
+
+	for _, j := range []job{old, done, dependency, waiting, failedJob} {
+		if err := store.Insert(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.Purge(ctx, purgeFilter{Status: []string{running}}); err == nil {
+		t.Fatalf("expected Purge() to refuse purging running jobs")
+	}
+
+	purged, err := store.Purge(ctx, purgeFilter{Before: time.Now().Add(-24 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected only the old job to be purged but got %v", purged)
+	}
+
+	purged, err = store.Purge(ctx, purgeFilter{Status: []string{complete}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected one complete job to be purged but got %v", purged)
+	}
+
+	// the dependency of the waiting job has to stay
+	if _, err := store.Get(ctx, dependency.Id); err != nil {
+		t.Fatalf("expected dependency to be kept but got %v", err)
+	}
+	if _, err := store.Get(ctx, failedJob.Id); err != nil {
+		t.Fatalf("expected failed job to be kept but got %v", err)
+	}
+}
+
+func TestFileStoreSchedules(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	sc := schedule{Id: primitive.NewObjectID(), Cron: "@hourly"}
+	err := store.update(ctx, func(d *fileStoreData) error {
+		d.Schedules = append(d.Schedules, sc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := settings{DbOpTimeout: time.Second}
+
+	// first pass only sets up nextRun
+	if err := spawnScheduledJobs(ctx, store, s); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := store.List(ctx, jobFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("expected no jobs for new schedule but got %v", len(jobs))
+	}
+
+	// pretend the activation time has come
+	err = store.update(ctx, func(d *fileStoreData) error {
+		past := time.Now().UTC().Add(-time.Minute)
+		d.Schedules[0].NextRun = &past
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spawnScheduledJobs(ctx, store, s); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err = store.List(ctx, jobFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ScheduleId == nil || *jobs[0].ScheduleId != sc.Id {
+		t.Fatalf("expected one job spawned by the schedule but got %v", jobs)
+	}
+
+	due, err := store.DueSchedules(ctx, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("expected the schedule to be advanced")
+	}
+}
+
+func TestSyncSchedule(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	src, cleanupSrc := newTestDir(t, map[string]string{"a.txt": "first", "b.txt": "second"})
+	defer cleanupSrc()
+	dst, cleanupDst := newTestDir(t, nil)
+	defer cleanupDst()
+
+	ctx := context.Background()
+
+	sc := schedule{Id: primitive.NewObjectID(), Cron: "@hourly", SrcUrl: "file://" + src + "/", DstUrl: "file://" + dst + "/",
+		Options: map[string]string{"sync": "true", "syncCompare": "size"}}
+	if err := store.update(ctx, func(d *fileStoreData) error {
+		d.Schedules = append(d.Schedules, sc)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every run syncs the directory
+	run := func() *job {
+		past := time.Now().UTC().Add(-time.Minute)
+		if err := store.update(ctx, func(d *fileStoreData) error {
+			d.Schedules[0].NextRun = &past
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := spawnScheduledJobs(ctx, store, testJobSettings()); err != nil {
+			t.Fatal(err)
+		}
+		if status := runTestJob(t, ctx, store, testJobSettings(), nil); status.jobError != nil {
+			t.Fatal(status.jobError)
+		}
+		done, err := store.Get(ctx, scheduledJobId(sc.Id, past))
+		if err != nil || done.Status != complete {
+			t.Fatalf("expected the job of the schedule run to be complete but got %+v (%v)", done, err)
+		}
+		return done
+	}
+
+	first := run()
+	if first.Options["sync"] != "true" || first.Files == nil || first.Files.Copied != 2 {
+		t.Fatalf("expected the first run to copy 2 files but got %+v", first)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(src, "c.txt"), []byte("third"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := run()
+	if second.Id == first.Id || second.Files == nil || second.Files.Copied != 1 || second.Files.Skipped != 2 {
+		t.Fatalf("expected the second run to copy only the new file but got %+v", second.Files)
+	}
+}
+
+// flakyStore fails the first insert, completion and schedule update it is asked
+// for; with cancelOnComplete the job is cancelled right before it is completed
+type flakyStore struct {
+	JobStore
+	insertErr        error
+	completeErr      error
+	advanceErr       error
+	cancelOnComplete bool
+}
+
+func (f *flakyStore) Insert(ctx context.Context, j job) error {
+	if err := f.insertErr; err != nil {
+		f.insertErr = nil
+		return err
+	}
+	return f.JobStore.Insert(ctx, j)
+}
+
+func (f *flakyStore) Complete(ctx context.Context, id primitive.ObjectID) error {
+	if err := f.completeErr; err != nil {
+		f.completeErr = nil
+		return err
+	}
+	if f.cancelOnComplete {
+		if err := f.JobStore.Cancel(ctx, id); err != nil {
+			return err
+		}
+	}
+	return f.JobStore.Complete(ctx, id)
+}
+
+func (f *flakyStore) AdvanceSchedule(ctx context.Context, id primitive.ObjectID, prev *time.Time, next time.Time) (bool, error) {
+	if err := f.advanceErr; err != nil {
+		f.advanceErr = nil
+		return false, err
+	}
+	return f.JobStore.AdvanceSchedule(ctx, id, prev, next)
+}
+
+func TestSpawnScheduledJobsRetry(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	past := time.Now().UTC().Add(-time.Minute)
+	sc := schedule{Id: primitive.NewObjectID(), Cron: "@hourly", NextRun: &past}
+	err := store.update(ctx, func(d *fileStoreData) error {
+		d.Schedules = append(d.Schedules, sc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := settings{DbOpTimeout: time.Second}
+	flaky := &flakyStore{JobStore: store, insertErr: errors.New("insert failed"), advanceErr: errors.New("update failed")}
+
+	// the run isn't lost when the job can't be created
+	if err := spawnScheduledJobs(ctx, flaky, s); err == nil {
+		t.Fatalf("expected the failed insert to be reported")
+	}
+	if due, err := store.DueSchedules(ctx, time.Now().UTC()); err != nil || len(due) != 1 {
+		t.Fatalf("expected the schedule to stay due but got %v (%v)", due, err)
+	}
+
+	// nor created twice when the schedule can't be advanced
+	if err := spawnScheduledJobs(ctx, flaky, s); err == nil {
+		t.Fatalf("expected the failed schedule update to be reported")
+	}
+	for i := 0; i < 2; i++ {
+		if err := spawnScheduledJobs(ctx, flaky, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, err := store.List(ctx, jobFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Id != scheduledJobId(sc.Id, past) {
+		t.Fatalf("expected one job spawned by the schedule run but got %v", jobs)
+	}
+	if due, err := store.DueSchedules(ctx, time.Now().UTC()); err != nil || len(due) != 0 {
+		t.Fatalf("expected the schedule to be advanced but got %v (%v)", due, err)
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+
+	store, cleanup := newTestFileStore(t)
+	defer cleanup()
+
+	// the lock file left by a crashed process doesn't hold the lock
+	if err := ioutil.WriteFile(store.path+".lock", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the processes taking the lock at once hold it one by one
+	var holders, maxHolders int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := newFileStore(store.path)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := f.lock(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&holders, 1)
+			for {
+				max := atomic.LoadInt32(&maxHolders)
+				if n <= max || atomic.CompareAndSwapInt32(&maxHolders, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			f.unlock()
+		}()
+	}
+	wg.Wait()
+
+	if maxHolders != 1 {
+		t.Fatalf("expected the lock to be held by one process at a time but got %v", maxHolders)
+	}
+
+	// the lock isn't taken while it is held
+	if err := store.lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer store.unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := newFileStore(store.path).lock(ctx); err == nil {
+		t.Fatalf("expected the held lock not to be taken")
+	}
+}
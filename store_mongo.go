@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/viktorburka/octopus/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"time"
+)
+
+type mongoStore struct {
+	client    *mongo.Client
+	jobs      *mongo.Collection
+	schedules *mongo.Collection
+	agents    *mongo.Collection
+}
+
+func newMongoStore(ctx context.Context, s settings) (*mongoStore, error) {
+
+	timeout, cancel := context.WithTimeout(ctx, s.DbOpTimeout)
+	defer cancel()
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(s.DbConnection).SetMonitor(mongoMonitor()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Connect(timeout); err != nil {
+		return nil, err
+	}
+
+	db := client.Database(s.Database)
+
+	return &mongoStore{
+		client:    client,
+		jobs:      db.Collection(s.Collection),
+		schedules: db.Collection(s.ScheduleCollection),
+		agents:    db.Collection(s.AgentCollection),
+	}, nil
+}
+
+func (m *mongoStore) Claim(ctx context.Context, req claimRequest) (*job, error) {
+
+	docOpt := &options.FindOneAndUpdateOptions{}
+	docOpt.SetReturnDocument(options.After)
+
+	ready := claimFilter(req)
+
+	// jobs without dependencies can be claimed right away
+	filter := bson.M{"dependsOn.0": bson.M{"$exists": false}}
+	for k, v := range ready {
+		filter[k] = v
+	}
+
+	var newJob job
+
+	result := m.jobs.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"status": running, "heartbeat": req.Now}},
+		docOpt)
+
+	err := result.Decode(&newJob)
+	if err == nil {
+		return &newJob, nil
+	}
+	// do not consider ErrNoDocuments as error
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	// jobs with dependencies have to be checked one by one
+	filter = bson.M{"dependsOn.0": bson.M{"$exists": true}}
+	for k, v := range ready {
+		filter[k] = v
+	}
+
+	// however many are blocked the ready ones behind them are reached
+	cursor, err := m.jobs.Find(ctx, filter, options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetProjection(bson.M{"dependsOn": 1}).
+		SetBatchSize(dependentBatchSize))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	return claimDependent(ctx, cursor,
+		func(deps []primitive.ObjectID) (int, string, error) {
+			return m.dependencyState(ctx, deps)
+		},
+		func(id primitive.ObjectID, reason string) error {
+			return m.fail(ctx, id, created, dependencyFailedCode, reason)
+		},
+		func(id primitive.ObjectID) (*job, error) {
+			result := m.jobs.FindOneAndUpdate(ctx,
+				bson.M{"_id": id, "status": created},
+				bson.M{"$set": bson.M{"status": running, "heartbeat": req.Now}},
+				docOpt)
+			var newJob job
+			err := result.Decode(&newJob)
+			if err == mongo.ErrNoDocuments { // claimed by another agent
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &newJob, nil
+		})
+}
+
+// jobCursor is the part of mongo.Cursor the candidate jobs are read with
+type jobCursor interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+}
+
+// claimDependent reads the candidate jobs with dependencies until claim
+// takes one whose dependencies are complete. The jobs whose dependencies
+// failed are failed on the way and the pending ones are skipped.
+func claimDependent(ctx context.Context, candidates jobCursor,
+	state func(deps []primitive.ObjectID) (int, string, error),
+	fail func(id primitive.ObjectID, reason string) error,
+	claim func(id primitive.ObjectID) (*job, error)) (*job, error) {
+
+	for candidates.Next(ctx) {
+
+		var candidate job
+		if err := candidates.Decode(&candidate); err != nil {
+			return nil, err
+		}
+
+		depState, reason, err := state(candidate.DependsOn)
+		if err != nil {
+			return nil, err
+		}
+
+		switch depState {
+		case dependenciesPending:
+			continue
+		case dependenciesFailed:
+			if err := fail(candidate.Id, reason); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		claimed, err := claim(candidate.Id)
+		if claimed != nil || err != nil {
+			return claimed, err
+		}
+	}
+
+	return nil, candidates.Err()
+}
+
+// claimFilter matches the jobs that are due and that
+// the agent is eligible to run according to the request
+func claimFilter(req claimRequest) bson.M {
+
+	filter := bson.M{
+		"status": created,
+		// matches the jobs without notBefore as well
+		"notBefore": bson.M{"$not": bson.M{"$gt": req.Now}},
+		// every required capability must be provided by the agent;
+		// matches the jobs without requirements as well
+		"requires": bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"$nin": append([]string{}, req.Capabilities...),
+		}}},
+	}
+
+	queues := make([]interface{}, 0, len(req.Queues)+2)
+	for _, q := range req.Queues {
+		if q == anyQueue {
+			return filter
+		}
+		if q == defaultQueue { // the jobs without queue
+			queues = append(queues, nil, "")
+		}
+		queues = append(queues, q)
+	}
+	filter["queue"] = bson.M{"$in": queues}
+
+	return filter
+}
+
+func (m *mongoStore) dependencyState(ctx context.Context, deps []primitive.ObjectID) (int, string, error) {
+
+	cursor, err := m.jobs.Find(ctx,
+		bson.M{"_id": bson.M{"$in": deps}},
+		options.Find().SetProjection(bson.M{"status": 1}))
+	if err != nil {
+		return dependenciesPending, "", err
+	}
+	defer cursor.Close(ctx)
+
+	statuses := make(map[primitive.ObjectID]string)
+	for cursor.Next(ctx) {
+		var dep job
+		if err := cursor.Decode(&dep); err != nil {
+			return dependenciesPending, "", err
+		}
+		statuses[dep.Id] = dep.Status
+	}
+	if err := cursor.Err(); err != nil {
+		return dependenciesPending, "", err
+	}
+
+	state, reason := dependencyState(deps, func(id primitive.ObjectID) (string, bool) {
+		status, ok := statuses[id]
+		return status, ok
+	})
+
+	return state, reason, nil
+}
+
+func (m *mongoStore) Progress(ctx context.Context, id primitive.ObjectID, done int64, total int64) error {
+	_, err := m.jobs.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"progress": jobProgress{Bytes: done, Total: total}}})
+	return err
+}
+
+func (m *mongoStore) Files(ctx context.Context, id primitive.ObjectID, files jobFiles) error {
+	_, err := m.jobs.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"files": files}})
+	return err
+}
+
+func (m *mongoStore) Result(ctx context.Context, id primitive.ObjectID, result jobResult) error {
+	_, err := m.jobs.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"result": result}})
+	return err
+}
+
+func (m *mongoStore) Complete(ctx context.Context, id primitive.ObjectID) error {
+	return m.transition(ctx, id, []string{running},
+		bson.M{"$set": bson.M{"status": complete}})
+}
+
+func (m *mongoStore) Fail(ctx context.Context, id primitive.ObjectID, code string, reason string) error {
+	return m.fail(ctx, id, running, code, reason)
+}
+
+// fail marks the job in the given status as failed
+// together with all the jobs that depend on it
+func (m *mongoStore) fail(ctx context.Context, id primitive.ObjectID, status string, code string, reason string) error {
+
+	result, err := m.jobs.UpdateOne(ctx,
+		bson.M{"_id": id, "status": status},
+		bson.M{"$set": bson.M{"status": failed, "error": reason, "errorCode": code}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 { // already taken care of
+		return nil
+	}
+
+	// fail all the jobs waiting for the failed
+	// job directly or through other dependent jobs
+	queue := []primitive.ObjectID{id}
+
+	for len(queue) > 0 {
+
+		cur := queue[0]
+		queue = queue[1:]
+
+		dependents, err := m.find(ctx, bson.M{"dependsOn": cur, "status": created}, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, dep := range dependents {
+			result, err := m.jobs.UpdateOne(ctx,
+				bson.M{"_id": dep.Id, "status": created},
+				bson.M{"$set": bson.M{"status": failed, "error": dependencyFailedReason(cur), "errorCode": dependencyFailedCode}})
+			if err != nil {
+				return err
+			}
+			if result.ModifiedCount > 0 {
+				queue = append(queue, dep.Id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// releaseUpdate puts the running job back to the queue
+var releaseUpdate = bson.M{
+	"$set":   bson.M{"status": created},
+	"$unset": bson.M{"error": "", "errorCode": "", "heartbeat": "", "progress": "", "files": "", "result": ""},
+}
+
+func (m *mongoStore) Release(ctx context.Context, id primitive.ObjectID) error {
+	_, err := m.jobs.UpdateOne(ctx, bson.M{"_id": id, "status": running}, releaseUpdate)
+	return err
+}
+
+func (m *mongoStore) ReleaseStale(ctx context.Context, before time.Time) (int64, error) {
+	result, err := m.jobs.UpdateMany(ctx,
+		bson.M{"status": running, "heartbeat": bson.M{"$lt": before}},
+		releaseUpdate)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (m *mongoStore) Reschedule(ctx context.Context, id primitive.ObjectID, code string, reason string, notBefore time.Time) error {
+	_, err := m.jobs.UpdateOne(ctx,
+		bson.M{"_id": id, "status": running},
+		bson.M{
+			"$set":   bson.M{"status": created, "error": reason, "errorCode": code, "notBefore": notBefore},
+			"$inc":   bson.M{"attempts": 1},
+			"$unset": bson.M{"heartbeat": "", "progress": "", "files": "", "result": ""},
+		})
+	return err
+}
+
+func (m *mongoStore) Heartbeat(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := m.jobs.UpdateOne(ctx,
+		bson.M{"_id": id, "status": running},
+		bson.M{"$set": bson.M{"heartbeat": time.Now().UTC()}})
+	if err != nil {
+		return true, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (m *mongoStore) Get(ctx context.Context, id primitive.ObjectID) (*job, error) {
+	var j job
+	if err := m.jobs.FindOne(ctx, bson.M{"_id": id}).Decode(&j); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errJobNotFound
+		}
+		return nil, err
+	}
+	return &j, nil
+}
+
+func (m *mongoStore) Cancel(ctx context.Context, id primitive.ObjectID) error {
+	return m.transition(ctx, id, []string{created, running},
+		bson.M{"$set": bson.M{"status": cancelled}})
+}
+
+func (m *mongoStore) Retry(ctx context.Context, id primitive.ObjectID) error {
+	return m.transition(ctx, id, []string{failed, cancelled},
+		bson.M{
+			"$set":   bson.M{"status": created},
+			"$unset": bson.M{"error": "", "errorCode": "", "heartbeat": "", "progress": "", "files": "", "result": "", "attempts": ""},
+		})
+}
+
+// transition applies update to the job if it is in one of the given statuses
+func (m *mongoStore) transition(ctx context.Context, id primitive.ObjectID, from []string, update bson.M) error {
+
+	result, err := m.jobs.UpdateOne(ctx, bson.M{"_id": id, "status": bson.M{"$in": from}}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// find out why it didn't match
+	if _, err := m.Get(ctx, id); err != nil {
+		return err
+	}
+	return errInvalidJobState
+}
+
+func (m *mongoStore) List(ctx context.Context, filter jobFilter) ([]job, error) {
+	f := bson.M{}
+	if len(filter.Status) > 0 {
+		f["status"] = bson.M{"$in": filter.Status}
+	}
+	return m.find(ctx, f, filter.Limit)
+}
+
+func (m *mongoStore) find(ctx context.Context, filter interface{}, limit int64) ([]job, error) {
+
+	cursor, err := m.jobs.Find(ctx, filter, options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []job
+	for cursor.Next(ctx) {
+		var j job
+		if err := cursor.Decode(&j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, cursor.Err()
+}
+
+func (m *mongoStore) Insert(ctx context.Context, j job) error {
+	_, err := m.jobs.InsertOne(ctx, j)
+	if isDuplicateKey(err) {
+		return fmt.Errorf("%w: %v", errJobExists, j.Id.Hex())
+	}
+	return err
+}
+
+// the code of the MongoDB duplicate key error
+const duplicateKeyCode = 11000
+
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == duplicateKeyCode {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mongoStore) Purge(ctx context.Context, filter purgeFilter) (int64, error) {
+
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+
+	statuses := filter.Status
+	if len(statuses) == 0 {
+		statuses = finishedStatuses
+	}
+
+	// the jobs waiting for dependencies would fail if those were gone
+	deps, err := m.jobs.Distinct(ctx, "dependsOn", bson.M{"status": created})
+	if err != nil {
+		return 0, err
+	}
+	if deps == nil {
+		deps = []interface{}{}
+	}
+
+	id := bson.M{"$nin": deps}
+	if !filter.Before.IsZero() {
+		id["$lt"] = minObjectID(filter.Before)
+	}
+
+	res, err := m.jobs.DeleteMany(ctx, bson.M{"status": bson.M{"$in": statuses}, "_id": id})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+func (m *mongoStore) DueSchedules(ctx context.Context, now time.Time) ([]schedule, error) {
+
+	cursor, err := m.schedules.Find(ctx, bson.M{
+		"disabled": bson.M{"$ne": true},
+		"$or": []bson.M{
+			{"nextRun": bson.M{"$exists": false}},
+			{"nextRun": bson.M{"$lte": now}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var due []schedule
+	for cursor.Next(ctx) {
+		var sc schedule
+		if err := cursor.Decode(&sc); err != nil {
+			return nil, err
+		}
+		due = append(due, sc)
+	}
+
+	return due, cursor.Err()
+}
+
+func (m *mongoStore) AdvanceSchedule(ctx context.Context, id primitive.ObjectID,
+	prev *time.Time, next time.Time) (bool, error) {
+
+	filter := bson.M{"_id": id, "nextRun": bson.M{"$exists": false}}
+	update := bson.M{"nextRun": next}
+	if prev != nil {
+		filter["nextRun"] = *prev
+		update["lastRun"] = *prev
+	}
+
+	result, err := m.schedules.UpdateOne(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+func (m *mongoStore) RegisterAgent(ctx context.Context, a agentInfo) error {
+	_, err := m.agents.ReplaceOne(ctx, bson.M{"_id": a.Id}, a, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoStore) Agents(ctx context.Context) ([]agentInfo, error) {
+
+	cursor, err := m.agents.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var agents []agentInfo
+	for cursor.Next(ctx) {
+		var a agentInfo
+		if err := cursor.Decode(&a); err != nil {
+			return nil, err
+		}
+		agents = append(agents, a)
+	}
+
+	return agents, cursor.Err()
+}
+
+func (m *mongoStore) RemoveAgent(ctx context.Context, id string) error {
+	_, err := m.agents.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// Watch uses a change stream on the jobs collection. Change streams
+// require a replica set or a sharded cluster.
+func (m *mongoStore) Watch(ctx context.Context, changes chan<- struct{}) error {
+
+	pipeline := []bson.M{{"$match": bson.M{"$or": []bson.M{
+		{"operationType": "insert", "fullDocument.status": created},
+		{"operationType": "replace", "fullDocument.status": created},
+		// completed jobs may unblock their dependents
+		{"operationType": "update", "updateDescription.updatedFields.status": bson.M{
+			"$in": []string{created, complete},
+		}},
+	}}}}
+
+	stream, err := m.jobs.Watch(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	logging.FromContext(ctx).Info("watching jobs collection for changes")
+
+	for stream.Next(ctx) {
+		select {
+		case changes <- struct{}{}:
+		default: // there is a pending notification already
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return stream.Err()
+}
+
+func (m *mongoStore) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx, readpref.Primary())
+}
+
+func (m *mongoStore) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+)
+
+func TestClaimFilterQueues(t *testing.T) {
+
+	s := settings{Queues: []string{"default", "edge"}}
+
+	filter := claimFilter(newClaimRequest(s))
+
+	expected := bson.M{"$in": []interface{}{nil, "", "default", "edge"}}
+	if !reflect.DeepEqual(filter["queue"], expected) {
+		t.Fatalf("expected queue filter %v but got %v", expected, filter["queue"])
+	}
+
+	s.Queues = []string{"edge", "*"}
+
+	filter = claimFilter(newClaimRequest(s))
+
+	if _, ok := filter["queue"]; ok {
+		t.Fatalf("expected no queue filter for agent serving any queue but got %v", filter["queue"])
+	}
+}
+
+func TestClaimFilterCapabilities(t *testing.T) {
+
+	s := settings{Capabilities: []string{"zone:dmz", "region:us-east-1"}}
+
+	filter := claimFilter(newClaimRequest(s))
+
+	expected := bson.M{"$not": bson.M{"$elemMatch": bson.M{
+		"$nin": []string{"zone:dmz", "region:us-east-1"},
+	}}}
+	if !reflect.DeepEqual(filter["requires"], expected) {
+		t.Fatalf("expected requires filter %v but got %v", expected, filter["requires"])
+	}
+
+	// no capabilities should only match the jobs without requirements
+	filter = claimFilter(newClaimRequest(settings{}))
+
+	expected = bson.M{"$not": bson.M{"$elemMatch": bson.M{"$nin": []string{}}}}
+	if !reflect.DeepEqual(filter["requires"], expected) {
+		t.Fatalf("expected requires filter %v but got %v", expected, filter["requires"])
+	}
+}
+
+// sliceCursor reads the jobs one by one the way mongo.Cursor does
+type sliceCursor struct {
+	jobs []job
+	read int
+}
+
+func (c *sliceCursor) Next(ctx context.Context) bool {
+	if c.read == len(c.jobs) {
+		return false
+	}
+	c.read++
+	return true
+}
+
+func (c *sliceCursor) Decode(val interface{}) error {
+	*val.(*job) = c.jobs[c.read-1]
+	return nil
+}
+
+func (c *sliceCursor) Err() error {
+	return nil
+}
+
+func TestClaimDependentPastBlocked(t *testing.T) {
+
+	pending, done := primitive.NewObjectID(), primitive.NewObjectID()
+
+	// more blocked jobs than are read at once are ahead of the ready one
+	var candidates []job
+	for i := 0; i < 3*dependentBatchSize; i++ {
+		candidates = append(candidates, job{Id: primitive.NewObjectID(), DependsOn: []primitive.ObjectID{pending}})
+	}
+	ready := job{Id: primitive.NewObjectID(), DependsOn: []primitive.ObjectID{done}}
+	candidates = append(candidates, ready)
+
+	state := func(deps []primitive.ObjectID) (int, string, error) {
+		if deps[0] == done {
+			return dependenciesReady, "", nil
+		}
+		return dependenciesPending, "", nil
+	}
+	fail := func(id primitive.ObjectID, reason string) error {
+		t.Fatalf("expected no job to fail but %v did: %v", id.Hex(), reason)
+		return nil
+	}
+	claim := func(id primitive.ObjectID) (*job, error) {
+		return &job{Id: id, Status: running}, nil
+	}
+
+	claimed, err := claimDependent(context.Background(), &sliceCursor{jobs: candidates}, state, fail, claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.Id != ready.Id {
+		t.Fatalf("expected the ready job %v to be claimed but got %+v", ready.Id.Hex(), claimed)
+	}
+
+	// the job claimed by another agent meanwhile is passed over
+	claim = func(id primitive.ObjectID) (*job, error) {
+		return nil, nil
+	}
+	claimed, err = claimDependent(context.Background(), &sliceCursor{jobs: candidates}, state, fail, claim)
+	if err != nil || claimed != nil {
+		t.Fatalf("expected nothing to be claimed but got %+v (%v)", claimed, err)
+	}
+}
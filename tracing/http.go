@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TraceParentHeader carries the trace context to the other services
+const TraceParentHeader = "traceparent"
+
+// Transport records a client span of every request made with the request
+// context span as the parent and passes the trace context along
+type Transport struct {
+	Base http.RoundTripper // http.DefaultTransport if nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if FromContext(req.Context()) == nil { // the request isn't a part of a trace
+		return base.RoundTrip(req)
+	}
+
+	// credentials stay out of the traces
+	u := *req.URL
+	u.User = nil
+
+	ctx, span := StartClient(req.Context(), "HTTP "+req.Method,
+		"http.method", req.Method, "http.url", u.String())
+	defer span.End()
+
+	if span != nil {
+		// RoundTrip must not modify the request
+		req = req.Clone(ctx)
+		req.Header.Set(TraceParentHeader, span.TraceParent())
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	span.SetAttributes("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.SetError(fmt.Errorf("%v", resp.Status))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OTLPExporter posts the spans to an OpenTelemetry collector
+// (http://collector:4318/v1/traces) using the OTLP/HTTP JSON encoding
+type OTLPExporter struct {
+	Endpoint    string // collector url; /v1/traces is appended unless it's there
+	ServiceName string
+	Client      *http.Client
+}
+
+// OTLP status codes
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue has exactly one of the fields set
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 as a JSON string
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOtlpValue(v interface{}) otlpValue {
+	switch val := v.(type) {
+	case string:
+		return otlpValue{StringValue: &val}
+	case bool:
+		return otlpValue{BoolValue: &val}
+	case int:
+		s := strconv.Itoa(val)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(val, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &val}
+	default:
+		s := fmt.Sprint(val)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func newOtlpAttributes(attrs []Attribute) []otlpAttribute {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make([]otlpAttribute, len(attrs))
+	for i, a := range attrs {
+		out[i] = otlpAttribute{Key: a.Key, Value: newOtlpValue(a.Value)}
+	}
+	return out
+}
+
+func newOtlpRequest(service string, spans []SpanData) otlpRequest {
+
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        newOtlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: otlpStatusUnset},
+		}
+		if s.ParentID != (SpanID{}) {
+			out[i].ParentSpanID = s.ParentID.String()
+		}
+		if s.Err != "" {
+			out[i].Status = otlpStatus{Code: otlpStatusError, Message: s.Err}
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: newOtlpAttributes([]Attribute{{"service.name", service}})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "octopus"}, Spans: out}},
+	}}}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+
+	body, err := json.Marshal(newOtlpRequest(e.ServiceName, spans))
+	if err != nil {
+		return err
+	}
+
+	url := e.Endpoint
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url = strings.TrimSuffix(url, "/") + "/v1/traces"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("can't export spans: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("can't export spans: collector responded %v", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+// Package tracing records the spans of the transfers and exports them to an
+// OpenTelemetry collector (OTLP/HTTP with JSON encoding). It implements only
+// what octopus needs: spans travel in the context, the trace context is sent
+// to the storages in the W3C traceparent header and the spans are exported
+// in batches. Without a tracer set up the spans are not recorded at all.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// span kinds as OTLP defines them
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+// Attribute is a span key value pair; Value is a string,
+// bool, int, int64 or float64, anything else is formatted
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is the finished span handed to the exporter
+type SpanData struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID // zero for the root span
+	Name       string
+	Kind       int
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Err        string // empty if the operation succeeded
+}
+
+// Span is an operation being timed. A nil span (tracing
+// disabled) is valid and ignores all the calls.
+type Span struct {
+	tracer *Tracer
+	m      sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SetAttributes adds key value pairs to the span
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.data.Attributes = appendAttributes(s.data.Attributes, kv)
+}
+
+// SetError marks the span as failed; nil error is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.data.Err = err.Error()
+}
+
+// End finishes the span and queues it for export; only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	if s.ended {
+		s.m.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.m.Unlock()
+	s.tracer.queue(data)
+}
+
+// TraceParent returns the W3C traceparent header value
+// of the span; empty string for nil span
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%v-%v-01", s.data.TraceID, s.data.SpanID)
+}
+
+func appendAttributes(attrs []Attribute, kv []interface{}) []Attribute {
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, Attribute{Key: fmt.Sprint(kv[i]), Value: kv[i+1]})
+	}
+	return attrs
+}
+
+type spanKey struct{}
+
+// FromContext returns the span of ctx; nil if there is none
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start begins a span as a child of the ctx one. The span is
+// nil if no tracer is set up.
+func Start(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return StartAt(ctx, name, time.Now(), kv...)
+}
+
+// StartAt begins a span that has started at the given time
+func StartAt(ctx context.Context, name string, start time.Time, kv ...interface{}) (context.Context, *Span) {
+	return startSpan(ctx, name, KindInternal, start, kv)
+}
+
+// StartClient begins a span of a request sent to another service
+func StartClient(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	return startSpan(ctx, name, KindClient, time.Now(), kv)
+}
+
+func startSpan(ctx context.Context, name string, kind int, start time.Time, kv []interface{}) (context.Context, *Span) {
+
+	tracer := Default()
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	s := &Span{tracer: tracer, data: SpanData{
+		Name:       name,
+		Kind:       kind,
+		Start:      start,
+		Attributes: appendAttributes(nil, kv),
+	}}
+	rand.Read(s.data.SpanID[:])
+	if parent := FromContext(ctx); parent != nil {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentID = parent.data.SpanID
+	} else {
+		rand.Read(s.data.TraceID[:])
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Exporter sends the finished spans to the tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Tracer batches the finished spans and exports them in the background
+type Tracer struct {
+	exporter  Exporter
+	batchSize int
+	interval  time.Duration
+	onError   func(error)
+
+	spans chan SpanData
+	flush chan chan struct{}
+	done  chan struct{}
+}
+
+// number of spans kept before the new ones are dropped
+const maxQueuedSpans = 2048
+
+// NewTracer starts exporting the spans every interval or once batchSize
+// of them are finished; onError receives the export errors
+func NewTracer(exporter Exporter, batchSize int, interval time.Duration, onError func(error)) *Tracer {
+	t := &Tracer{
+		exporter:  exporter,
+		batchSize: batchSize,
+		interval:  interval,
+		onError:   onError,
+		spans:     make(chan SpanData, maxQueuedSpans),
+		flush:     make(chan chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) queue(data SpanData) {
+	select {
+	case t.spans <- data:
+	default: // the exporter doesn't keep up; tracing is best effort
+	}
+}
+
+func (t *Tracer) run() {
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case data := <-t.spans:
+			batch = append(batch, data)
+			if len(batch) >= t.batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			export()
+			close(flushed)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// time given to a batch export
+const exportTimeout = 10 * time.Second
+
+// Shutdown exports the spans finished so far and stops the tracer
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	close(t.done)
+	return nil
+}
+
+var (
+	defaultMutex  sync.Mutex
+	defaultTracer *Tracer
+)
+
+// Default returns the tracer recording the spans; nil if tracing is disabled
+func Default() *Tracer {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	return defaultTracer
+}
+
+// SetDefault sets up the tracer recording the spans; nil disables tracing
+func SetDefault(t *Tracer) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultTracer = t
+}
@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingExporter struct {
+	m     sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// setTestTracer sets up a tracer for the test; call the
+// returned function to export the spans and disable tracing
+func setTestTracer(t *testing.T, exporter Exporter) func() {
+	tracer := NewTracer(exporter, 100, time.Hour, func(err error) { t.Error(err) })
+	SetDefault(tracer)
+	return func() {
+		SetDefault(nil)
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+
+	exporter := &recordingExporter{}
+	shutdown := setTestTracer(t, exporter)
+
+	ctx, root := Start(context.Background(), "job", "job", "5cad")
+	_, child := Start(ctx, "probe")
+	child.SetAttributes("size", int64(1024))
+	child.SetError(errors.New("not found"))
+	child.End()
+	child.End() // only the first call counts
+	root.End()
+
+	shutdown()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expected 2 spans but got %+v", exporter.spans)
+	}
+	probe, job := exporter.spans[0], exporter.spans[1]
+	if job.ParentID != (SpanID{}) || probe.TraceID != job.TraceID || probe.ParentID != job.SpanID {
+		t.Fatalf("expected probe to be a child of job but got %+v and %+v", probe, job)
+	}
+	if probe.Err != "not found" || len(probe.Attributes) != 1 || probe.Attributes[0] != (Attribute{"size", int64(1024)}) {
+		t.Fatalf("unexpected probe span %+v", probe)
+	}
+	if job.End.Before(probe.End) {
+		t.Fatalf("expected job to end after probe")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+
+	ctx, span := Start(context.Background(), "job")
+	if span != nil || FromContext(ctx) != nil {
+		t.Fatalf("expected no span without tracer")
+	}
+
+	// nil span ignores the calls
+	span.SetAttributes("bytes", 1)
+	span.SetError(errors.New("failed"))
+	span.End()
+	if span.TraceParent() != "" {
+		t.Fatalf("expected no traceparent of nil span")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+
+	var body otlpRequest
+	var path string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer collector.Close()
+
+	exporter := &OTLPExporter{Endpoint: collector.URL, ServiceName: "octopus"}
+	span := SpanData{
+		TraceID:    TraceID{1},
+		SpanID:     SpanID{2},
+		ParentID:   SpanID{3},
+		Name:       "upload part",
+		Kind:       KindInternal,
+		Start:      time.Unix(0, 100),
+		End:        time.Unix(0, 200),
+		Attributes: []Attribute{{"bytes", int64(5 << 20)}, {"partNumber", "3"}},
+		Err:        "access denied",
+	}
+	if err := exporter.Export(context.Background(), []SpanData{span}); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/v1/traces" || len(body.ResourceSpans) != 1 || len(body.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %v %+v", path, body)
+	}
+	res := body.ResourceSpans[0]
+	if *res.Resource.Attributes[0].Value.StringValue != "octopus" {
+		t.Fatalf("expected service name but got %+v", res.Resource)
+	}
+	got := res.ScopeSpans[0].Spans[0]
+	if got.TraceID != "01000000000000000000000000000000" || got.SpanID != "0200000000000000" ||
+		got.ParentSpanID != "0300000000000000" || got.StartTimeUnixNano != "100" || got.EndTimeUnixNano != "200" {
+		t.Fatalf("unexpected span ids or times %+v", got)
+	}
+	if *got.Attributes[0].Value.IntValue != "5242880" || *got.Attributes[1].Value.StringValue != "3" {
+		t.Fatalf("unexpected attributes %+v", got.Attributes)
+	}
+	if got.Status.Code != otlpStatusError || got.Status.Message != "access denied" {
+		t.Fatalf("unexpected status %+v", got.Status)
+	}
+
+	collector.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	if err := exporter.Export(context.Background(), []SpanData{span}); err == nil {
+		t.Fatalf("expected rejected export to fail")
+	}
+}
+
+func TestTransport(t *testing.T) {
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(TraceParentHeader)
+	}))
+	defer srv.Close()
+
+	exporter := &recordingExporter{}
+	shutdown := setTestTracer(t, exporter)
+
+	client := &http.Client{Transport: &Transport{}}
+	get := func(ctx context.Context) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/file.mp4", nil)
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	get(context.Background())
+	if traceparent != "" {
+		t.Fatalf("expected no traceparent outside of a trace but got %v", traceparent)
+	}
+
+	ctx, root := Start(context.Background(), "download part")
+	get(ctx)
+	root.End()
+
+	shutdown()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expected client and root spans but got %+v", exporter.spans)
+	}
+	httpSpan := exporter.spans[0]
+	if httpSpan.Kind != KindClient || httpSpan.ParentID != root.data.SpanID || httpSpan.Name != "HTTP GET" {
+		t.Fatalf("unexpected client span %+v", httpSpan)
+	}
+	if !strings.HasPrefix(traceparent, "00-"+root.data.TraceID.String()+"-"+httpSpan.SpanID.String()) {
+		t.Fatalf("expected traceparent of the client span but got %v", traceparent)
+	}
+}